@@ -343,6 +343,16 @@ func (g *gitArtifactRangeReader) discoveryHeaders() http.Header {
 	return headers
 }
 
+// GitProxyURL returns the URL that clones or fetches repoURL through the
+// cachew git proxy.
+func (c *Client) GitProxyURL(repoURL string) (string, error) {
+	endpoint, err := gitEndpointURL(c.baseURL, repoURL, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(endpoint, "/"), nil
+}
+
 // gitEndpointURL builds a /git/{host}/{repoPath}/{suffix} URL from a cachew
 // base URL and an upstream repository URL (e.g. https://github.com/org/repo).
 func gitEndpointURL(baseURL, repoURL, suffix string) (string, error) {
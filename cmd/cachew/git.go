@@ -39,7 +39,8 @@ func inSpan(ctx context.Context, name string, attrs []attribute.KeyValue, fn fun
 // GitCmd groups git-aware subcommands that talk directly to cachew's
 // /git/ strategy endpoints (not the generic object-store API).
 type GitCmd struct {
-	Restore GitRestoreCmd `cmd:"" help:"Restore a repository from a cachew git snapshot."`
+	Restore    GitRestoreCmd    `cmd:"" help:"Restore a repository from a cachew git snapshot."`
+	RestoreAll GitRestoreAllCmd `cmd:"" help:"Restore every repository in a workspace manifest concurrently."`
 }
 
 // GitRestoreCmd fetches a git snapshot, extracts it, and optionally applies
@@ -47,14 +48,25 @@ type GitCmd struct {
 // ensure those refs/commits are fresh and runs `git pull --ff-only` so the
 // working tree catches up to upstream.
 type GitRestoreCmd struct {
-	RepoURL             string            `arg:"" help:"Repository URL (e.g. https://github.com/org/repo)."`
-	Directory           string            `arg:"" help:"Target directory for the clone." type:"path"`
-	Ref                 map[string]string `help:"Required refs to freshen on the server before pulling, in the form 'name=sha' (e.g. 'refs/heads/main=abc123'). An empty SHA means any SHA is acceptable. Setting this (or --commit) runs a final 'git pull' from origin so the working tree is brought up to date."`
-	Commit              []string          `help:"Required commit SHAs that must exist on the server, regardless of which ref points at them. May be repeated."`
-	NoBundle            bool              `help:"Skip applying delta bundle."`
-	ZstdThreads         int               `help:"Threads for zstd decompression (0 = all CPU cores)." default:"0"`
-	DownloadConcurrency int               `help:"Concurrent range requests for the snapshot download (1 = single streaming request)." default:"8"`
-	DownloadChunkSizeMB int               `help:"Chunk size in MiB for parallel snapshot downloads." default:"16"`
+	RepoURL              string            `arg:"" help:"Repository URL (e.g. https://github.com/org/repo)."`
+	Directory            string            `arg:"" help:"Target directory for the clone." type:"path"`
+	Ref                  map[string]string `help:"Required refs to freshen on the server before pulling, in the form 'name=sha' (e.g. 'refs/heads/main=abc123'). An empty SHA means any SHA is acceptable. Setting this (or --commit) runs a final 'git pull' from origin so the working tree is brought up to date."`
+	Commit               []string          `help:"Required commit SHAs that must exist on the server, regardless of which ref points at them. May be repeated."`
+	NoBundle             bool              `help:"Skip applying delta bundle."`
+	ZstdThreads          int               `help:"Threads for zstd decompression (0 = all CPU cores)." default:"0"`
+	DownloadConcurrency  int               `help:"Concurrent range requests for the snapshot download (1 = single streaming request)." default:"8"`
+	DownloadChunkSizeMB  int               `help:"Chunk size in MiB for parallel snapshot downloads." default:"16"`
+	RecurseSubmodules    bool              `help:"Restore submodules, including nested ones, at their pinned commits. Each is restored from its own snapshot, or cloned through the cachew git proxy if it has none."`
+	SubmoduleConcurrency int               `help:"Submodules to restore concurrently." default:"4"`
+
+	authorization string
+}
+
+// AfterApply picks up the CLI's authorization so git commands run against
+// the cachew git proxy present it too.
+func (c *GitRestoreCmd) AfterApply(cli *CLI) error {
+	c.authorization = cli.Authorization
+	return nil
 }
 
 func (c *GitRestoreCmd) Run(ctx context.Context, api *client.Client) error {
@@ -64,6 +76,7 @@ func (c *GitRestoreCmd) Run(ctx context.Context, api *client.Client) error {
 			attribute.String("cachew.directory", c.Directory),
 			attribute.Bool("cachew.no_bundle", c.NoBundle),
 			attribute.Int("cachew.zstd_threads", c.ZstdThreads),
+			attribute.Bool("cachew.recurse_submodules", c.RecurseSubmodules),
 		),
 	)
 	defer span.End()
@@ -110,6 +123,14 @@ func (c *GitRestoreCmd) Run(ctx context.Context, api *client.Client) error {
 		}
 	}
 
+	if c.RecurseSubmodules {
+		if err := c.restoreSubmodules(ctx, api, c.Directory, c.RepoURL); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return errors.Wrap(err, "restore submodules")
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/block/cachew/client"
+)
+
+// GitRestoreAllCmd restores every repository in a workspace manifest,
+// concurrently, through a single client.
+type GitRestoreAllCmd struct {
+	Manifest             string `arg:"" help:"YAML or JSON list of repositories to restore, each with a url and optional directory, refs and commits." type:"existingfile"`
+	Concurrency          int    `help:"Repositories to restore concurrently." default:"4"`
+	MaxBandwidthMB       int    `help:"Total snapshot and bundle download bandwidth across all restores, in MiB/s (0 = unlimited)." default:"0"`
+	NoBundle             bool   `help:"Skip applying delta bundles."`
+	ZstdThreads          int    `help:"Threads for zstd decompression per restore (0 = all CPU cores)." default:"0"`
+	DownloadConcurrency  int    `help:"Concurrent range requests per snapshot download (1 = single streaming request)." default:"8"`
+	DownloadChunkSizeMB  int    `help:"Chunk size in MiB for parallel snapshot downloads." default:"16"`
+	RecurseSubmodules    bool   `help:"Restore each repository's submodules at their pinned commits."`
+	SubmoduleConcurrency int    `help:"Submodules to restore concurrently per repository." default:"4"`
+}
+
+// RestoreManifestEntry is one repository in a restore-all manifest. A
+// relative Directory is resolved against the manifest's directory, and an
+// empty one defaults to the repository name.
+type RestoreManifestEntry struct {
+	URL       string            `yaml:"url"`
+	Directory string            `yaml:"directory"`
+	Refs      map[string]string `yaml:"refs"`
+	Commits   []string          `yaml:"commits"`
+}
+
+// restoreResult is the outcome of restoring one manifest entry.
+type restoreResult struct {
+	entry   RestoreManifestEntry
+	elapsed time.Duration
+	err     error
+}
+
+func (c *GitRestoreAllCmd) Run(ctx context.Context, api *client.Client, cli *CLI) error {
+	ctx, span := tracer.Start(ctx, "cachew.git_restore_all",
+		trace.WithAttributes(
+			attribute.String("cachew.manifest", c.Manifest),
+			attribute.Int("cachew.concurrency", c.Concurrency),
+			attribute.Int("cachew.max_bandwidth_mb", c.MaxBandwidthMB),
+		),
+	)
+	defer span.End()
+
+	entries, err := loadRestoreManifest(c.Manifest)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("cachew.repos", len(entries)))
+
+	// Every restore shares one client, so the bandwidth budget and connection
+	// pool are global rather than per repository.
+	if c.MaxBandwidthMB > 0 {
+		limiter := newBandwidthLimiter(float64(c.MaxBandwidthMB) * (1 << 20))
+		httpClient := *api.HTTP()
+		base := httpClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		httpClient.Transport = &limitedTransport{base: base, limiter: limiter}
+		api = client.NewWithHTTPClient(api.BaseURL(), &httpClient)
+	}
+
+	totalStart := time.Now()
+	results := make([]restoreResult, len(entries))
+	var completed atomic.Int32
+	var g errgroup.Group
+	g.SetLimit(max(c.Concurrency, 1))
+	for i, entry := range entries {
+		g.Go(func() error {
+			fmt.Fprintf(os.Stderr, "==> Restoring %s into %s\n", entry.URL, entry.Directory) //nolint:forbidigo
+			start := time.Now()
+			restore := &GitRestoreCmd{
+				RepoURL:              entry.URL,
+				Directory:            entry.Directory,
+				Ref:                  entry.Refs,
+				Commit:               entry.Commits,
+				NoBundle:             c.NoBundle,
+				ZstdThreads:          c.ZstdThreads,
+				DownloadConcurrency:  c.DownloadConcurrency,
+				DownloadChunkSizeMB:  c.DownloadChunkSizeMB,
+				RecurseSubmodules:    c.RecurseSubmodules,
+				SubmoduleConcurrency: c.SubmoduleConcurrency,
+				authorization:        cli.Authorization,
+			}
+			err := restore.Run(ctx, api)
+			results[i] = restoreResult{entry: entry, elapsed: time.Since(start), err: err}
+			status := "restored"
+			if err != nil {
+				status = "FAILED"
+			}
+			fmt.Fprintf(os.Stderr, "<== [%d/%d] %s %s in %s\n", completed.Add(1), len(entries), entry.URL, status, results[i].elapsed.Round(time.Millisecond)) //nolint:forbidigo
+			return nil
+		})
+	}
+	_ = g.Wait() //nolint:errcheck // restores report failures through results
+
+	return summariseRestores(os.Stderr, results, time.Since(totalStart))
+}
+
+// summariseRestores writes one line per restore and returns an error naming
+// the failed repositories, if any.
+func summariseRestores(w io.Writer, results []restoreResult, elapsed time.Duration) error {
+	var failed []string
+	fmt.Fprintf(w, "\nRestore summary (%s):\n", elapsed.Round(time.Millisecond)) //nolint:errcheck
+	for _, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = "FAILED: " + r.err.Error()
+			failed = append(failed, r.entry.URL)
+		}
+		fmt.Fprintf(w, "  %-60s %10s  %s\n", r.entry.URL, r.elapsed.Round(time.Millisecond), status) //nolint:errcheck
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("%d of %d restores failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// loadRestoreManifest parses a restore-all manifest. JSON is valid YAML, so
+// one decoder reads both.
+func loadRestoreManifest(manifest string) ([]RestoreManifestEntry, error) {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	var entries []RestoreManifestEntry
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrapf(err, "parse manifest %s", manifest)
+	}
+	if len(entries) == 0 {
+		return nil, errors.Errorf("manifest %s lists no repositories", manifest)
+	}
+	base := filepath.Dir(manifest)
+	seen := map[string]string{}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.URL == "" {
+			return nil, errors.Errorf("manifest entry %d has no url", i+1)
+		}
+		if entry.Directory == "" {
+			entry.Directory = strings.TrimSuffix(path.Base(strings.TrimSuffix(entry.URL, "/")), ".git")
+		}
+		if !filepath.IsAbs(entry.Directory) {
+			entry.Directory = filepath.Join(base, entry.Directory)
+		}
+		entry.Directory = filepath.Clean(entry.Directory)
+		if other, ok := seen[entry.Directory]; ok {
+			return nil, errors.Errorf("%s and %s both restore into %s", other, entry.URL, entry.Directory)
+		}
+		seen[entry.Directory] = entry.URL
+	}
+	return entries, nil
+}
+
+// bandwidthLimiter is a token bucket shared by every download, capping their
+// combined throughput.
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond float64) *bandwidthLimiter {
+	// A quarter-second burst keeps throughput smooth without letting one
+	// large read overshoot the budget.
+	burst := max(bytesPerSecond/4, 32<<10)
+	return &bandwidthLimiter{rate: bytesPerSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// maxRead is the largest single read a limited body makes, so the limiter
+// can interleave concurrent downloads.
+func (l *bandwidthLimiter) maxRead() int {
+	return int(l.burst)
+}
+
+// wait blocks until n bytes may be consumed.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limitedTransport throttles response bodies through a shared limiter.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *bandwidthLimiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: t.limiter}
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context //nolint:containedctx // scoped to a single response
+	limiter *bandwidthLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > b.limiter.maxRead() {
+		p = p[:b.limiter.maxRead()]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err //nolint:wrapcheck
+}
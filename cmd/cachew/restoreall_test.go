@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/client"
+)
+
+func TestGitRestoreAll(t *testing.T) {
+	snapshots := map[string][]byte{}
+	for _, name := range []string{"one", "two"} {
+		srcDir := t.TempDir()
+		initGitRepo(t, srcDir, map[string]string{name + ".txt": name})
+		snapshots["/git/github.com/test/"+name+"/snapshot.tar.zst"] = createTarZst(t, srcDir)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := snapshots[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zstd")
+		w.Write(data) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	workspace := t.TempDir()
+	manifest := filepath.Join(workspace, "workspace.yaml")
+	assert.NoError(t, os.WriteFile(manifest, []byte(`
+- url: https://github.com/test/one
+- url: https://github.com/test/two.git
+  directory: libs/two
+- url: https://github.com/test/missing
+`), 0o600))
+
+	cmd := &GitRestoreAllCmd{Manifest: manifest, Concurrency: 2, MaxBandwidthMB: 64, NoBundle: true}
+	api := client.NewWithHTTPClient(srv.URL, srv.Client())
+	err := cmd.Run(context.Background(), api, &CLI{})
+	assert.EqualError(t, err, "1 of 3 restores failed: https://github.com/test/missing")
+
+	content, err := os.ReadFile(filepath.Join(workspace, "one", "one.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(content))
+	content, err = os.ReadFile(filepath.Join(workspace, "libs", "two", "two.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(content))
+}
+
+func TestLoadRestoreManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "manifest")
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	entries, err := loadRestoreManifest(write(`[
+  {"url": "https://github.com/org/a", "directory": "/abs/a", "refs": {"refs/heads/main": ""}},
+  {"url": "https://github.com/org/b", "commits": ["abc123"]}
+]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []RestoreManifestEntry{
+		{URL: "https://github.com/org/a", Directory: "/abs/a", Refs: map[string]string{"refs/heads/main": ""}},
+		{URL: "https://github.com/org/b", Directory: filepath.Join(dir, "b"), Commits: []string{"abc123"}},
+	}, entries)
+
+	_, err = loadRestoreManifest(write(`[{"directory": "x"}]`))
+	assert.EqualError(t, err, "manifest entry 1 has no url")
+
+	_, err = loadRestoreManifest(write("- url: https://github.com/org/a\n- url: https://gitlab.com/org/a\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "both restore into")
+
+	_, err = loadRestoreManifest(write("[]"))
+	assert.Error(t, err)
+}
+
+func TestBandwidthLimiterSharedAcrossDownloads(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(body) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	// 4 MiB/s with a 1 MiB burst: three concurrent 1 MiB downloads must wait
+	// for at least 2 MiB of refill.
+	httpClient := &http.Client{Transport: &limitedTransport{base: http.DefaultTransport, limiter: newBandwidthLimiter(4 << 20)}}
+	start := time.Now()
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			resp, err := httpClient.Get(srv.URL) //nolint:noctx
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			n, err := io.Copy(io.Discard, resp.Body)
+			if err == nil && n != int64(len(body)) {
+				err = io.ErrUnexpectedEOF
+			}
+			errs <- err
+		}()
+	}
+	for range 3 {
+		assert.NoError(t, <-errs)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 400*time.Millisecond, "downloads finished in %s", elapsed)
+	assert.True(t, elapsed < 5*time.Second, "downloads finished in %s", elapsed)
+}
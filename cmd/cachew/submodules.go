@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/block/cachew/client"
+)
+
+// submodule is a submodule declared in .gitmodules, pinned by its gitlink.
+type submodule struct {
+	name   string
+	path   string
+	url    string
+	commit string
+}
+
+// restoreSubmodules restores every submodule of the checkout in directory,
+// whose origin is repoURL, at the commit its gitlink pins. Each submodule is
+// restored from its own snapshot when the server has one, and cloned through
+// the cachew git proxy otherwise. Nested submodules are restored in turn.
+func (c *GitRestoreCmd) restoreSubmodules(ctx context.Context, api *client.Client, directory, repoURL string) error {
+	submodules, err := listSubmodules(ctx, directory, repoURL)
+	if err != nil {
+		return err
+	}
+	if len(submodules) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Restoring %d submodule(s) of %s\n", len(submodules), repoURL) //nolint:forbidigo
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(c.SubmoduleConcurrency, 1))
+	for _, sm := range submodules {
+		g.Go(func() error {
+			return inSpan(gctx, "cachew.restore_submodule",
+				[]attribute.KeyValue{
+					attribute.String("cachew.submodule_path", sm.path),
+					attribute.String("cachew.repo_url", sm.url),
+					attribute.String("cachew.commit", sm.commit),
+				},
+				func(ctx context.Context) error {
+					return errors.Wrapf(c.restoreSubmodule(ctx, api, directory, sm), "submodule %s", sm.path)
+				})
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err //nolint:wrapcheck // wrapped per submodule
+	}
+
+	args := []string{"-C", directory, "submodule", "init", "--"}
+	for _, sm := range submodules {
+		args = append(args, sm.path)
+	}
+	if output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil { //nolint:gosec
+		return errors.Wrapf(err, "git submodule init: %s", string(output))
+	}
+	return nil
+}
+
+func (c *GitRestoreCmd) restoreSubmodule(ctx context.Context, api *client.Client, parentDir string, sm submodule) error {
+	dir := filepath.Join(parentDir, filepath.FromSlash(sm.path))
+	child := &GitRestoreCmd{
+		RepoURL:              sm.url,
+		Directory:            dir,
+		NoBundle:             c.NoBundle,
+		ZstdThreads:          c.ZstdThreads,
+		DownloadConcurrency:  c.DownloadConcurrency,
+		DownloadChunkSizeMB:  c.DownloadChunkSizeMB,
+		SubmoduleConcurrency: c.SubmoduleConcurrency,
+		authorization:        c.authorization,
+	}
+
+	_, bundleURL, err := child.fetchAndExtractSnapshot(ctx, api)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "No snapshot for submodule %s, cloning through cachew\n", sm.path) //nolint:forbidigo
+		if err := child.cloneThroughProxy(ctx, api); err != nil {
+			return err
+		}
+	case err != nil:
+		return errors.Wrap(err, "restore snapshot")
+	case bundleURL != "" && !c.NoBundle:
+		if err := applyBundle(ctx, api, bundleURL, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to apply delta bundle for submodule %s: %v\n", sm.path, err) //nolint:forbidigo
+		}
+	}
+
+	if !localHasSHA(ctx, dir, sm.commit) {
+		if err := child.fetchCommitThroughProxy(ctx, api, sm.commit); err != nil {
+			return err
+		}
+	}
+	if output, err := exec.CommandContext(ctx, "git", "-C", dir, "checkout", "--quiet", "--detach", sm.commit).CombinedOutput(); err != nil { //nolint:gosec
+		return errors.Wrapf(err, "git checkout %s: %s", sm.commit, string(output))
+	}
+	return child.restoreSubmodules(ctx, api, dir, sm.url)
+}
+
+// cloneThroughProxy clones the repository through the cachew git proxy and
+// points origin back at the upstream URL, matching a snapshot restore.
+func (c *GitRestoreCmd) cloneThroughProxy(ctx context.Context, api *client.Client) error {
+	proxyURL, err := api.GitProxyURL(c.RepoURL)
+	if err != nil {
+		return errors.Wrap(err, "resolve proxy URL")
+	}
+	if output, err := c.proxyGitCommand(ctx, "clone", "--quiet", "--no-checkout", proxyURL, c.Directory).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "git clone: %s", string(output))
+	}
+	if output, err := exec.CommandContext(ctx, "git", "-C", c.Directory, "remote", "set-url", "origin", c.RepoURL).CombinedOutput(); err != nil { //nolint:gosec
+		return errors.Wrapf(err, "set origin URL: %s", string(output))
+	}
+	return nil
+}
+
+// fetchCommitThroughProxy fetches a pinned commit the restored clone lacks,
+// asking the server to make sure its mirror has it first.
+func (c *GitRestoreCmd) fetchCommitThroughProxy(ctx context.Context, api *client.Client, commit string) error {
+	resp, err := api.EnsureGitRefs(ctx, c.RepoURL, client.EnsureGitRefsRequest{Commits: []string{commit}})
+	if err != nil {
+		return errors.Wrap(err, "ensure commit")
+	}
+	if len(resp.MissingCommits) > 0 {
+		return errors.Errorf("server is missing pinned commit %s", commit)
+	}
+	proxyURL, err := api.GitProxyURL(c.RepoURL)
+	if err != nil {
+		return errors.Wrap(err, "resolve proxy URL")
+	}
+	if output, err := c.proxyGitCommand(ctx, "-C", c.Directory, "fetch", "--quiet", proxyURL, commit).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "git fetch %s: %s", commit, string(output))
+	}
+	return nil
+}
+
+// proxyGitCommand returns a git command that presents the CLI's cachew
+// authorization to the git proxy.
+func (c *GitRestoreCmd) proxyGitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	if c.authorization != "" {
+		args = append([]string{"-c", "http.extraHeader=Authorization: " + c.authorization}, args...)
+	}
+	return exec.CommandContext(ctx, "git", args...) //nolint:gosec
+}
+
+// listSubmodules reads .gitmodules at HEAD and returns each submodule with
+// the commit its gitlink pins. Relative submodule URLs are resolved against
+// repoURL.
+func listSubmodules(ctx context.Context, directory, repoURL string) ([]submodule, error) {
+	if exec.CommandContext(ctx, "git", "-C", directory, "cat-file", "-e", "HEAD:.gitmodules").Run() != nil { //nolint:gosec
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", directory, "config", "--blob", "HEAD:.gitmodules", "--get-regexp", `^submodule\..*\.(path|url)$`) //nolint:gosec
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit status 1 means .gitmodules declares no submodules.
+		if exitErr, ok := errors.AsType[*exec.ExitError](err); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read .gitmodules")
+	}
+
+	byName := map[string]*submodule{}
+	var names []string
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		key = strings.TrimPrefix(key, "submodule.")
+		dot := strings.LastIndex(key, ".")
+		name, field := key[:dot], key[dot+1:]
+		sm, ok := byName[name]
+		if !ok {
+			sm = &submodule{name: name}
+			byName[name] = sm
+			names = append(names, name)
+		}
+		switch field {
+		case "path":
+			sm.path = value
+		case "url":
+			sm.url = value
+		}
+	}
+
+	var submodules []submodule
+	for _, name := range names {
+		sm := byName[name]
+		if sm.path == "" || sm.url == "" {
+			continue
+		}
+		resolved, err := resolveSubmoduleURL(repoURL, sm.url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "submodule %s", name)
+		}
+		sm.url = resolved
+		commit, err := gitlinkCommit(ctx, directory, sm.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "submodule %s", name)
+		}
+		if commit == "" {
+			// Declared in .gitmodules but not recorded in the tree.
+			continue
+		}
+		sm.commit = commit
+		submodules = append(submodules, *sm)
+	}
+	return submodules, nil
+}
+
+// gitlinkCommit returns the commit the gitlink at path pins in HEAD, or ""
+// if there is no gitlink there.
+func gitlinkCommit(ctx context.Context, directory, path string) (string, error) {
+	output, err := exec.CommandContext(ctx, "git", "-C", directory, "ls-tree", "-z", "HEAD", "--", path).Output() //nolint:gosec
+	if err != nil {
+		return "", errors.Wrap(err, "git ls-tree")
+	}
+	// Format: "<mode> <type> <object>\t<path>\0".
+	entry, _, _ := bytes.Cut(output, []byte{0})
+	fields := strings.Fields(string(entry))
+	if len(fields) < 3 || fields[1] != "commit" {
+		return "", nil
+	}
+	return fields[2], nil
+}
+
+// resolveSubmoduleURL resolves a ./ or ../ submodule URL against the parent
+// repository's URL, as git does. SSH URLs, including scp-style ones such as
+// git@github.com:org/repo.git, map to the https URL of the same host and
+// path, since the proxy only serves https upstreams.
+func resolveSubmoduleURL(repoURL, submoduleURL string) (string, error) {
+	if !strings.HasPrefix(submoduleURL, "./") && !strings.HasPrefix(submoduleURL, "../") {
+		return sshToHTTPS(submoduleURL)
+	}
+	base, err := url.Parse(strings.TrimSuffix(repoURL, "/") + "/")
+	if err != nil {
+		return "", errors.Wrap(err, "parse repository URL")
+	}
+	ref, err := url.Parse(submoduleURL)
+	if err != nil {
+		return "", errors.Wrap(err, "parse submodule URL")
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// sshToHTTPS maps an ssh:// or scp-style URL to https, dropping the user and
+// port. Other URLs are returned unchanged.
+func sshToHTTPS(rawURL string) (string, error) {
+	if strings.HasPrefix(rawURL, "ssh://") {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return "", errors.Wrap(err, "parse submodule URL")
+		}
+		return "https://" + parsed.Hostname() + "/" + strings.TrimPrefix(parsed.Path, "/"), nil
+	}
+	// scp-style: [user@]host:path, where no / precedes the first colon.
+	if strings.Contains(rawURL, "://") {
+		return rawURL, nil
+	}
+	hostPart, repoPath, ok := strings.Cut(rawURL, ":")
+	if !ok || strings.Contains(hostPart, "/") {
+		return rawURL, nil
+	}
+	if _, host, found := strings.Cut(hostPart, "@"); found {
+		hostPart = host
+	}
+	if hostPart == "" || repoPath == "" {
+		return "", errors.Errorf("invalid submodule URL %q", rawURL)
+	}
+	return "https://" + hostPart + "/" + strings.TrimPrefix(repoPath, "/"), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cgi" //nolint:gosec // CVE-2016-5386 only affects Go < 1.6.3
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/client"
+)
+
+func gitIn(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "protocol.file.allow=always"}, args...)...) //nolint:gosec
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test.com",
+	)
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// addSubmodule adds repo as a submodule at path, pinned at commit, and
+// rewrites its URL to the relative form a hosted repository would use.
+func addSubmodule(t *testing.T, dir, repo, path, commit, relativeURL string) {
+	t.Helper()
+	gitIn(t, dir, "submodule", "add", "--quiet", repo, path)
+	gitIn(t, filepath.Join(dir, path), "checkout", "--quiet", commit)
+	gitIn(t, dir, "config", "-f", ".gitmodules", "submodule."+path+".url", relativeURL)
+	gitIn(t, dir, "add", ".gitmodules", path)
+	gitIn(t, dir, "commit", "--quiet", "-m", "add "+path)
+}
+
+// snapshotOf returns a snapshot of a fresh clone of repo, whose submodule
+// directories are empty as they are in a server-side snapshot.
+func snapshotOf(t *testing.T, repo string) []byte {
+	t.Helper()
+	clone := filepath.Join(t.TempDir(), "clone")
+	gitIn(t, repo, "clone", "--quiet", repo, clone)
+	return createTarZst(t, clone)
+}
+
+func TestGitRestoreRecurseSubmodules(t *testing.T) {
+	tmp := t.TempDir()
+
+	// nested has no snapshot, so it is cloned through the git proxy.
+	nested := filepath.Join(tmp, "nested")
+	assert.NoError(t, os.MkdirAll(nested, 0o755))
+	initGitRepo(t, nested, map[string]string{"nested.txt": "nested"})
+	projectRoot := filepath.Join(tmp, "served")
+	gitIn(t, tmp, "clone", "--quiet", "--bare", nested, filepath.Join(projectRoot, "github.com", "test", "nested"))
+
+	// sub moves on after the commit the parent pins.
+	sub := filepath.Join(tmp, "sub")
+	assert.NoError(t, os.MkdirAll(sub, 0o755))
+	initGitRepo(t, sub, map[string]string{"sub.txt": "v1"})
+	addSubmodule(t, sub, nested, "deps/nested", gitIn(t, nested, "rev-parse", "HEAD"), "../nested")
+	pinned := gitIn(t, sub, "rev-parse", "HEAD")
+	assert.NoError(t, os.WriteFile(filepath.Join(sub, "sub.txt"), []byte("v2"), 0o644))
+	gitIn(t, sub, "commit", "--quiet", "-am", "v2")
+
+	parent := filepath.Join(tmp, "parent")
+	assert.NoError(t, os.MkdirAll(parent, 0o755))
+	initGitRepo(t, parent, map[string]string{"parent.txt": "parent"})
+	addSubmodule(t, parent, sub, "libs/sub", pinned, "../sub")
+
+	snapshots := map[string][]byte{
+		"/git/github.com/test/repo/snapshot.tar.zst": snapshotOf(t, parent),
+		"/git/github.com/test/sub/snapshot.tar.zst":  snapshotOf(t, sub),
+	}
+	backend := &cgi.Handler{
+		Path: "git",
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + projectRoot, "GIT_HTTP_EXPORT_ALL=1", "PATH=" + os.Getenv("PATH")},
+	}
+	if gitPath, err := exec.LookPath("git"); err == nil {
+		backend.Path = gitPath
+	}
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/snapshot.tar.zst"):
+			data, ok := snapshots[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/zstd")
+			w.Write(data) //nolint:errcheck
+		case strings.HasSuffix(r.URL.Path, "/ensure-refs"):
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"fetched": false}) //nolint:errcheck
+		case strings.HasPrefix(r.URL.Path, "/git/github.com/test/nested"):
+			authorization = r.Header.Get("Authorization")
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/git")
+			backend.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dstDir := filepath.Join(t.TempDir(), "restored")
+	restoreCmd := &GitRestoreCmd{
+		RepoURL:              "https://github.com/test/repo",
+		Directory:            dstDir,
+		NoBundle:             true,
+		RecurseSubmodules:    true,
+		SubmoduleConcurrency: 2,
+		authorization:        "Bearer test-token",
+	}
+	api := client.NewWithHTTPClient(srv.URL, srv.Client())
+	assert.NoError(t, restoreCmd.Run(context.Background(), api))
+
+	// The submodule is checked out at the pinned commit, not its snapshot's HEAD.
+	content, err := os.ReadFile(filepath.Join(dstDir, "libs", "sub", "sub.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(content))
+	content, err = os.ReadFile(filepath.Join(dstDir, "libs", "sub", "deps", "nested", "nested.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "nested", string(content))
+	assert.Equal(t, "Bearer test-token", authorization)
+
+	status := gitIn(t, dstDir, "submodule", "status", "--recursive")
+	assert.Contains(t, status, pinned+" libs/sub")
+	assert.NotContains(t, status, "-", "all submodules should be initialised and at their pinned commits")
+	assert.Equal(t, "https://github.com/test/nested", gitIn(t, filepath.Join(dstDir, "libs", "sub", "deps", "nested"), "remote", "get-url", "origin"))
+}
+
+func TestResolveSubmoduleURL(t *testing.T) {
+	for _, tt := range []struct{ url, want string }{
+		{"../other", "https://github.com/org/other"},
+		{"../other.git", "https://github.com/org/other.git"},
+		{"./child", "https://github.com/org/repo/child"},
+		{"../../team/lib", "https://github.com/team/lib"},
+		{"https://gitlab.example.com/x/y.git", "https://gitlab.example.com/x/y.git"},
+		{"git@github.com:org/lib.git", "https://github.com/org/lib.git"},
+		{"ssh://git@github.com:22/org/lib.git", "https://github.com/org/lib.git"},
+		{"github.com:org/lib", "https://github.com/org/lib"},
+	} {
+		got, err := resolveSubmoduleURL("https://github.com/org/repo", tt.url)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.url)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/block/cachew/internal/config"
 	"github.com/block/cachew/internal/gitclone"
 	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/jobscheduler"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/metadatadb"
@@ -54,6 +55,7 @@ type GlobalConfig struct {
 	MetricsConfig    metrics.Config      `hcl:"metrics,block"`
 	GitCloneConfig   gitclone.Config     `hcl:"git-clone,block"`
 	S3Config         s3client.Config     `hcl:"s3,block,optional"`
+	UpstreamConfig   httpclient.Config   `hcl:"upstream,block"`
 	GithubAppConfigs []githubapp.Config  `hcl:"github-app,block,optional"`
 	OPAConfig        opa.Config          `hcl:"opa,block"`
 }
@@ -122,6 +124,7 @@ func main() {
 		return tokenManagerProvider()
 	})
 	s3ClientProvider := s3client.NewClientProvider(ctx, globalConfig.S3Config)
+	transportProvider := httpclient.NewTransportProvider(globalConfig.UpstreamConfig)
 
 	// The scheduler gets its own context so workers keep running during
 	// graceful shutdown while in-flight HTTP handlers drain. We cancel it
@@ -129,7 +132,7 @@ func main() {
 	schedulerCtx, cancelScheduler := context.WithCancel(context.WithoutCancel(ctx))
 	schedulerProvider := jobscheduler.NewProvider(schedulerCtx, globalConfig.SchedulerConfig)
 
-	cr, mr, sr := newRegistries(schedulerProvider, gitManagerProvider, tokenManagerProvider, s3ClientProvider)
+	cr, mr, sr := newRegistries(schedulerProvider, gitManagerProvider, tokenManagerProvider, s3ClientProvider, transportProvider)
 
 	// Commands
 	switch { //nolint:gocritic
@@ -249,6 +252,7 @@ func newRegistries(
 	cloneManagerProvider gitclone.ManagerProvider,
 	tokenManagerProvider githubapp.TokenManagerProvider,
 	s3ClientProvider s3client.ClientProvider,
+	transportProvider httpclient.TransportProvider,
 ) (
 	*cache.Registry,
 	*metadatadb.Registry,
@@ -265,12 +269,12 @@ func newRegistries(
 
 	sr := strategy.NewRegistry()
 	strategy.RegisterAPIV1(sr)
-	strategy.RegisterArtifactory(sr)
-	strategy.RegisterGitHubReleases(sr, tokenManagerProvider)
-	strategy.RegisterHermit(sr)
-	strategy.RegisterHost(sr)
-	strategy.RegisterHTTPProxy(sr)
-	git.Register(sr, scheduler, cloneManagerProvider, tokenManagerProvider)
+	strategy.RegisterArtifactory(sr, transportProvider)
+	strategy.RegisterGitHubReleases(sr, tokenManagerProvider, transportProvider)
+	strategy.RegisterHermit(sr, transportProvider)
+	strategy.RegisterHost(sr, transportProvider)
+	strategy.RegisterHTTPProxy(sr, transportProvider)
+	git.Register(sr, scheduler, cloneManagerProvider, tokenManagerProvider, transportProvider)
 	gomod.Register(sr, cloneManagerProvider)
 
 	return cr, mr, sr
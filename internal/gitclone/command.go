@@ -9,21 +9,15 @@ import (
 	"strings"
 
 	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/logging"
 )
 
 // GitCommand returns a git subprocess configured with repository-scoped
 // authentication and any per-URL git config overrides disabled.
 func (r *Repository) GitCommand(ctx context.Context, args ...string) (*exec.Cmd, error) {
 	repoURL := r.upstreamURL
-	var token string
-	if r.credentialProvider != nil && strings.Contains(repoURL, "github.com") {
-		var err error
-		token, err = r.credentialProvider.GetTokenForURL(ctx, repoURL)
-		// If error getting token, fall back to original URL (system credentials)
-		if err != nil {
-			token = ""
-		}
-	}
+	credentials := credentialsForURL(ctx, r.credentialProvider, repoURL)
 
 	configArgs, err := getInsteadOfDisableArgsForURL(ctx, repoURL)
 	if err != nil {
@@ -35,12 +29,15 @@ func (r *Repository) GitCommand(ctx context.Context, args ...string) (*exec.Cmd,
 		allArgs = append(allArgs, configArgs...)
 	}
 
-	// Add credential helper configuration if we have a token
-	// This ensures git uses the GitHub App token for authentication
-	// for all operations (clone, fetch, remote update, etc.)
-	if token != "" {
-		escapedToken := strings.ReplaceAll(token, "'", "'\\''")
-		credHelper := "!f() { test \"$1\" = get && echo username=x-access-token && printf 'password=%s\\n' '" + escapedToken + "'; }; f"
+	// Add credential configuration if we have credentials. This ensures git
+	// uses them for all operations (clone, fetch, remote update, etc.)
+	switch {
+	case credentials.BearerToken != "":
+		allArgs = append(allArgs, "-c", "http.extraHeader=Authorization: Bearer "+credentials.BearerToken)
+	case credentials.Password != "":
+		escapedUsername := strings.ReplaceAll(credentials.Username, "'", "'\\''")
+		escapedPassword := strings.ReplaceAll(credentials.Password, "'", "'\\''")
+		credHelper := "!f() { test \"$1\" = get && echo 'username=" + escapedUsername + "' && printf 'password=%s\\n' '" + escapedPassword + "'; }; f"
 		allArgs = append(allArgs, "-c", "credential.helper="+credHelper)
 	}
 
@@ -49,6 +46,28 @@ func (r *Repository) GitCommand(ctx context.Context, args ...string) (*exec.Cmd,
 	return exec.CommandContext(ctx, "git", allArgs...), nil
 }
 
+// credentialsForURL asks provider for url's credentials. Plain
+// CredentialProviders supply GitHub tokens, presented as x-access-token.
+// Errors fall back to no credentials, so git uses system credentials.
+func credentialsForURL(ctx context.Context, provider CredentialProvider, url string) Credentials {
+	if provider == nil {
+		return Credentials{}
+	}
+	if hostProvider, ok := provider.(HostCredentialProvider); ok {
+		credentials, err := hostProvider.GetCredentialsForURL(ctx, url)
+		if err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to get git host credentials, using system credentials", "upstream", url, "error", err)
+			return Credentials{}
+		}
+		return credentials
+	}
+	token, err := provider.GetTokenForURL(ctx, url)
+	if err != nil || token == "" {
+		return Credentials{}
+	}
+	return Credentials{Username: "x-access-token", Password: token}
+}
+
 func getInsteadOfDisableArgsForURL(ctx context.Context, targetURL string) ([]string, error) {
 	if targetURL == "" {
 		return nil, nil
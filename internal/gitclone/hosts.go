@@ -0,0 +1,251 @@
+package gitclone
+
+import (
+	"bufio"
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/errors"
+)
+
+// Git host types. The type picks how a token is presented and the host's
+// path rules.
+const (
+	HostTypeGeneric         = "generic"
+	HostTypeGitLab          = "gitlab"
+	HostTypeBitbucketServer = "bitbucket-server"
+	HostTypeGitea           = "gitea"
+)
+
+// HostConfig configures a git host other than github.com: where its
+// upstream lives and how to authenticate to it.
+type HostConfig struct {
+	Host        string  `hcl:"host,label" help:"Host name clients use in cachew URLs, e.g. gitlab.example.com."`
+	URL         string  `hcl:"url,optional" help:"Upstream base URL, including scheme, port and any path the host is served under, e.g. http://gitlab.internal:8080. Defaults to https://<host>." default:""`
+	Type        string  `hcl:"type,optional" help:"Host type: generic, gitlab, bitbucket-server or gitea. Selects how tokens are presented and the default path rules." default:"generic"`
+	Credentials string  `hcl:"credentials,optional" help:"Credential type: none, token, netrc or basic." default:"none"`
+	Token       string  `hcl:"token,optional" help:"Static token for token credentials." default:""`
+	Username    string  `hcl:"username,optional" help:"Username for basic credentials, or to present a generic host's token with." default:""`
+	PasswordEnv string  `hcl:"password-env,optional" help:"Environment variable holding the password for basic credentials." default:""`
+	NetrcFile   string  `hcl:"netrc-file,optional" help:"netrc file to read this host's credentials from for netrc credentials. Defaults to ~/.netrc." default:""`
+	PathPrefix  *string `hcl:"path-prefix,optional" help:"Path segment inserted before repository paths that don't already start with it. Bitbucket Server defaults to \"scm\"."`
+}
+
+// Credentials authenticate git HTTP requests to an upstream, either as
+// basic auth or as a bearer token.
+type Credentials struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// IsZero reports whether c carries no credentials.
+func (c Credentials) IsZero() bool {
+	return c.Password == "" && c.BearerToken == ""
+}
+
+// HostCredentialProvider is a CredentialProvider for a non-GitHub host,
+// which also chooses how its token is presented.
+type HostCredentialProvider interface {
+	CredentialProvider
+	GetCredentialsForURL(ctx context.Context, url string) (Credentials, error)
+}
+
+// gitHost is a validated HostConfig.
+type gitHost struct {
+	base        *url.URL
+	pathPrefix  string
+	credentials HostCredentialProvider // nil for none
+}
+
+func newGitHost(config HostConfig) (*gitHost, error) {
+	base := config.URL
+	if base == "" {
+		base = "https://" + config.Host
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return nil, errors.Wrap(err, "url")
+	}
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, errors.Errorf("url %q: expected an http or https base URL", base)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+
+	host := &gitHost{base: parsed}
+	switch config.Type {
+	case "", HostTypeGeneric, HostTypeGitLab, HostTypeGitea:
+	case HostTypeBitbucketServer:
+		// Bitbucket Server serves git under /scm/PROJECT/repo.git.
+		host.pathPrefix = "scm"
+	default:
+		return nil, errors.Errorf("unknown type %q", config.Type)
+	}
+	if config.PathPrefix != nil {
+		host.pathPrefix = strings.Trim(*config.PathPrefix, "/")
+	}
+
+	switch config.Credentials {
+	case "", "none":
+	case "token":
+		if config.Token == "" {
+			return nil, errors.New("token credentials require token")
+		}
+		host.credentials = tokenCredentials(config.Type, config.Username, config.Token)
+	case "basic":
+		if config.Username == "" || config.PasswordEnv == "" {
+			return nil, errors.New("basic credentials require username and password-env")
+		}
+		host.credentials = envCredentials{username: config.Username, passwordEnv: config.PasswordEnv}
+	case "netrc":
+		path := config.NetrcFile
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, errors.Wrap(err, "locate ~/.netrc")
+			}
+			path = filepath.Join(home, ".netrc")
+		}
+		host.credentials = netrcCredentials{path: path, machine: parsed.Hostname()}
+	default:
+		return nil, errors.Errorf("unknown credentials %q", config.Credentials)
+	}
+	return host, nil
+}
+
+// upstreamURL joins repoPath onto the host's base URL, applying its path
+// rules.
+func (h *gitHost) upstreamURL(repoPath string) string {
+	repoPath = strings.TrimPrefix(repoPath, "/")
+	if h.pathPrefix != "" && repoPath != h.pathPrefix && !strings.HasPrefix(repoPath, h.pathPrefix+"/") {
+		repoPath = h.pathPrefix + "/" + repoPath
+	}
+	return h.base.String() + "/" + repoPath
+}
+
+// tokenCredentials presents a static token the way hostType expects it.
+func tokenCredentials(hostType, username, token string) staticCredentials {
+	switch hostType {
+	case HostTypeGitLab:
+		// GitLab accepts personal, project and group access tokens as the
+		// password for any username; oauth2 also covers OAuth tokens.
+		return staticCredentials{Credentials{Username: "oauth2", Password: token}}
+	case HostTypeBitbucketServer:
+		// Bitbucket Server HTTP access tokens are not tied to a username
+		// when sent as a bearer token.
+		return staticCredentials{Credentials{BearerToken: token}}
+	case HostTypeGitea:
+		// Gitea authenticates a token sent as the username.
+		return staticCredentials{Credentials{Username: token, Password: "x-oauth-basic"}}
+	default:
+		if username == "" {
+			username = "x-access-token"
+		}
+		return staticCredentials{Credentials{Username: username, Password: token}}
+	}
+}
+
+type staticCredentials struct{ credentials Credentials }
+
+func (s staticCredentials) GetCredentialsForURL(context.Context, string) (Credentials, error) {
+	return s.credentials, nil
+}
+
+func (s staticCredentials) GetTokenForURL(context.Context, string) (string, error) {
+	return s.credentials.Password, nil
+}
+
+// envCredentials reads the password from the environment on every use, so
+// a rotated secret is picked up without a restart.
+type envCredentials struct {
+	username    string
+	passwordEnv string
+}
+
+func (e envCredentials) GetCredentialsForURL(context.Context, string) (Credentials, error) {
+	password, ok := os.LookupEnv(e.passwordEnv)
+	if !ok {
+		return Credentials{}, errors.Errorf("%s is not set", e.passwordEnv)
+	}
+	return Credentials{Username: e.username, Password: password}, nil
+}
+
+func (e envCredentials) GetTokenForURL(ctx context.Context, url string) (string, error) {
+	credentials, err := e.GetCredentialsForURL(ctx, url)
+	return credentials.Password, err
+}
+
+// netrcCredentials reads the machine's login and password from a netrc
+// file on every use.
+type netrcCredentials struct {
+	path    string
+	machine string
+}
+
+func (n netrcCredentials) GetCredentialsForURL(context.Context, string) (Credentials, error) {
+	f, err := os.Open(n.path)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "open netrc")
+	}
+	defer f.Close()
+	credentials, err := parseNetrc(bufio.NewScanner(f), n.machine)
+	if err != nil {
+		return Credentials{}, errors.Wrapf(err, "read %s", n.path)
+	}
+	if credentials.IsZero() {
+		return Credentials{}, errors.Errorf("no credentials for %s in %s", n.machine, n.path)
+	}
+	return credentials, nil
+}
+
+func (n netrcCredentials) GetTokenForURL(ctx context.Context, url string) (string, error) {
+	credentials, err := n.GetCredentialsForURL(ctx, url)
+	return credentials.Password, err
+}
+
+// parseNetrc returns the login and password for machine, falling back to the
+// default entry. Macros are not supported.
+func parseNetrc(scanner *bufio.Scanner, machine string) (Credentials, error) {
+	scanner.Split(bufio.ScanWords)
+	var (
+		found, fallback Credentials
+		current         *Credentials
+	)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			if !scanner.Scan() {
+				return Credentials{}, errors.New("machine without a name")
+			}
+			current = nil
+			if scanner.Text() == machine && found.IsZero() {
+				current = &found
+			}
+		case "default":
+			current = &fallback
+		case "login", "password":
+			field := scanner.Text()
+			if !scanner.Scan() {
+				return Credentials{}, errors.Errorf("%s without a value", field)
+			}
+			if current == nil {
+				continue
+			}
+			if field == "login" {
+				current.Username = scanner.Text()
+			} else {
+				current.Password = scanner.Text()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, errors.WithStack(err)
+	}
+	if !found.IsZero() {
+		return found, nil
+	}
+	return fallback, nil
+}
@@ -0,0 +1,162 @@
+package gitclone //nolint:testpackage // white-box testing required for unexported fields
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestNewGitHost_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config HostConfig
+		err    string
+	}{
+		{"BadScheme", HostConfig{Host: "git.example.com", URL: "ssh://git.example.com"}, "expected an http or https base URL"},
+		{"UnknownType", HostConfig{Host: "git.example.com", Type: "gerrit"}, `unknown type "gerrit"`},
+		{"UnknownCredentials", HostConfig{Host: "git.example.com", Credentials: "ssh-key"}, `unknown credentials "ssh-key"`},
+		{"TokenWithoutToken", HostConfig{Host: "git.example.com", Credentials: "token"}, "require token"},
+		{"BasicWithoutEnv", HostConfig{Host: "git.example.com", Credentials: "basic", Username: "ci"}, "require username and password-env"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+			_, err := NewManager(ctx, Config{MirrorRoot: t.TempDir(), Hosts: []HostConfig{tt.config}}, nil)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestManager_UpstreamURL(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	manager, err := NewManager(ctx, Config{
+		MirrorRoot: t.TempDir(),
+		Hosts: []HostConfig{
+			{Host: "gitlab.example.com", URL: "http://gitlab.internal:8080/", Type: HostTypeGitLab},
+			{Host: "bitbucket.example.com", Type: HostTypeBitbucketServer},
+			{Host: "gitea.example.com", URL: "https://gitea.internal/gitea"},
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		host, repoPath, want string
+	}{
+		{"github.com", "org/repo", "https://github.com/org/repo"},
+		{"gitlab.example.com", "group/subgroup/repo", "http://gitlab.internal:8080/group/subgroup/repo"},
+		{"gitlab.internal:8080", "group/subgroup/repo", "http://gitlab.internal:8080/group/subgroup/repo"},
+		{"bitbucket.example.com", "proj/repo", "https://bitbucket.example.com/scm/proj/repo"},
+		{"bitbucket.example.com", "scm/proj/repo", "https://bitbucket.example.com/scm/proj/repo"},
+		{"gitea.example.com", "owner/repo", "https://gitea.internal/gitea/owner/repo"},
+	} {
+		assert.Equal(t, tt.want, manager.UpstreamURL(tt.host, tt.repoPath), "%s/%s", tt.host, tt.repoPath)
+	}
+}
+
+func TestManager_MirrorUpstreamURL(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	mirrorRoot := t.TempDir()
+	manager, err := NewManager(ctx, Config{
+		MirrorRoot: mirrorRoot,
+		Hosts: []HostConfig{
+			// The label is also the upstream host, which serves git under a path.
+			{Host: "gitlab.example.com", URL: "https://gitlab.example.com/gitlab", Type: HostTypeGitLab},
+			{Host: "gitea.example.com", URL: "http://gitea.internal/gitea"},
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	const want = "https://gitlab.example.com/gitlab/org/repo"
+	assert.Equal(t, want, manager.UpstreamURL("gitlab.example.com", "org/repo"))
+	assert.Equal(t, want, manager.MirrorUpstreamURL("gitlab.example.com", "gitlab/org/repo"))
+	assert.Equal(t, "http://gitea.internal/gitea/owner/repo", manager.MirrorUpstreamURL("gitea.internal", "gitea/owner/repo"))
+	assert.Equal(t, "https://github.com/org/repo", manager.MirrorUpstreamURL("github.com", "org/repo"))
+
+	mirror := filepath.Join(mirrorRoot, "gitlab.example.com", "gitlab", "org", "repo")
+	assert.NoError(t, exec.Command("git", "init", "--bare", "--quiet", mirror).Run())
+	discovered, err := manager.DiscoverExisting(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(discovered))
+	assert.Equal(t, want, discovered[0].UpstreamURL())
+}
+
+func TestManager_CredentialsForURL(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	assert.NoError(t, os.WriteFile(netrc, []byte("machine other.example.com login nope password nope\n"+
+		"machine gitea.internal\n  login ci\n  password s3cret\n"), 0o600))
+	t.Setenv("BB_PASSWORD", "hunter2")
+
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	manager, err := NewManager(ctx, Config{
+		MirrorRoot: t.TempDir(),
+		Hosts: []HostConfig{
+			{Host: "gitlab.example.com", Type: HostTypeGitLab, Credentials: "token", Token: "glpat"},
+			{Host: "bitbucket.example.com", Type: HostTypeBitbucketServer, Credentials: "token", Token: "bbtoken"},
+			{Host: "bitbucket-basic.example.com", Type: HostTypeBitbucketServer, Credentials: "basic", Username: "ci", PasswordEnv: "BB_PASSWORD"},
+			{Host: "gitea.example.com", URL: "https://gitea.internal", Type: HostTypeGitea, Credentials: "netrc", NetrcFile: netrc},
+			{Host: "git.example.com"},
+		},
+	}, &mockCredentialProvider{token: "ghs_token"})
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		upstreamURL string
+		want        Credentials
+	}{
+		{"https://gitlab.example.com/group/repo", Credentials{Username: "oauth2", Password: "glpat"}},
+		{"https://bitbucket.example.com/scm/proj/repo", Credentials{BearerToken: "bbtoken"}},
+		{"https://bitbucket-basic.example.com/scm/proj/repo", Credentials{Username: "ci", Password: "hunter2"}},
+		{"https://gitea.internal/owner/repo", Credentials{Username: "ci", Password: "s3cret"}},
+		{"https://git.example.com/owner/repo", Credentials{}},
+		{"https://github.com/org/repo", Credentials{Username: "x-access-token", Password: "ghs_token"}},
+	} {
+		assert.Equal(t, tt.want, manager.CredentialsForURL(ctx, tt.upstreamURL), tt.upstreamURL)
+	}
+}
+
+func TestGitCommandWithHostCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	repo := &Repository{
+		upstreamURL:        "https://bitbucket.example.com/scm/proj/repo",
+		credentialProvider: tokenCredentials(HostTypeBitbucketServer, "", "bbtoken"),
+	}
+	cmd, err := repo.GitCommand(ctx, "version")
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Join(cmd.Args, " "), "http.extraHeader=Authorization: Bearer bbtoken")
+
+	repo = &Repository{
+		upstreamURL:        "https://gitlab.example.com/group/repo",
+		credentialProvider: tokenCredentials(HostTypeGitLab, "", "glpat"),
+	}
+	cmd, err = repo.GitCommand(ctx, "version")
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Join(cmd.Args, " "), "username=oauth2")
+}
+
+func TestParseNetrc(t *testing.T) {
+	const netrc = `machine a.example.com login alice password apass
+default login anon password anonpass
+machine b.example.com
+	login bob
+	password bpass
+`
+	for machine, want := range map[string]Credentials{
+		"a.example.com": {Username: "alice", Password: "apass"},
+		"b.example.com": {Username: "bob", Password: "bpass"},
+		"c.example.com": {Username: "anon", Password: "anonpass"},
+	} {
+		got, err := parseNetrc(bufio.NewScanner(strings.NewReader(netrc)), machine)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, machine)
+	}
+}
@@ -3,14 +3,17 @@ package gitclone
 import (
 	"context"
 	"io/fs"
+	"maps"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -33,6 +36,15 @@ const (
 // DiscoverExisting on startup.
 const cloneTempPrefix = ".clone-"
 
+// removeTempPrefix is the prefix a mirror directory is renamed to by Remove
+// before it is deleted. Like cloneTempPrefix, leftovers are removed by
+// DiscoverExisting on startup.
+const removeTempPrefix = ".remove-"
+
+// verifyTempPrefix is the prefix for the ref snapshot Verify checks
+// connectivity from. Leftovers are removed by DiscoverExisting on startup.
+const verifyTempPrefix = ".verify-"
+
 func (s State) String() string {
 	switch s {
 	case StateEmpty:
@@ -71,6 +83,10 @@ type Config struct {
 	LsRemoteTimeout  time.Duration `hcl:"ls-remote-timeout,optional" help:"Upper bound for 'git ls-remote' so a slow upstream cannot block the request path indefinitely." default:"1m"`
 	RepackTimeout    time.Duration `hcl:"repack-timeout,optional" help:"Upper bound for 'git repack' so a slow repack on a large repository cannot block the scheduler queue indefinitely." default:"10m"`
 	RepackThreads    int           `hcl:"repack-threads,optional" help:"Threads for git repack operations. Limits memory since windowMemory and deltaCacheSize are per-thread. 0 = pack-threads." default:"4"`
+	ScrubTimeout     time.Duration `hcl:"scrub-timeout,optional" help:"Upper bound for mirror integrity checks (fsck, commit-graph and multi-pack-index verification)." default:"30m"`
+	RefInclude       []string      `hcl:"ref-include,optional" help:"Refs to mirror, as exact names or prefixes ending in /* (e.g. refs/heads/*). Empty mirrors every ref."`
+	RefExclude       []string      `hcl:"ref-exclude,optional" help:"Refs to leave out of mirrors and hide from clients, as exact names or prefixes ending in /* (e.g. refs/pull/*). Excluded refs can still be fetched on demand through ensure-refs."`
+	Hosts            []HostConfig  `hcl:"git-host,block,optional" help:"Upstream and credential configuration for git hosts other than github.com."`
 }
 
 // CredentialProvider provides credentials for git operations.
@@ -89,6 +105,7 @@ type Repository struct {
 	lastFetch          time.Time
 	lastRefCheck       time.Time
 	refCheckValid      bool
+	lastAccess         atomic.Int64 // unix nanos; atomic so lookups don't contend with r.mu
 	fetchSem           chan struct{}
 	credentialProvider CredentialProvider
 }
@@ -99,6 +116,11 @@ type Manager struct {
 	clones             map[string]*Repository
 	clonesMu           sync.RWMutex
 	credentialProvider CredentialProvider
+	hosts              map[string]*gitHost // keyed by the host clients use
+	upstreamHosts      map[string]*gitHost // keyed by the upstream base URL's host
+	// repoConfigFor applies per-upstream overrides to Config. Guarded by
+	// clonesMu.
+	repoConfigFor func(upstreamURL string, config Config) Config
 }
 
 // ManagerProvider is a function that lazily creates a singleton Manager.
@@ -122,6 +144,23 @@ func NewManager(ctx context.Context, config Config, credentialProvider Credentia
 	if config.MirrorRoot == "" {
 		return nil, errors.New("mirror-root is required")
 	}
+	if err := ValidateRefPatterns(config.RefInclude); err != nil {
+		return nil, errors.Wrap(err, "ref-include")
+	}
+	if err := ValidateRefPatterns(config.RefExclude); err != nil {
+		return nil, errors.Wrap(err, "ref-exclude")
+	}
+
+	hosts := make(map[string]*gitHost, len(config.Hosts))
+	upstreamHosts := make(map[string]*gitHost, len(config.Hosts))
+	for _, hostConfig := range config.Hosts {
+		host, err := newGitHost(hostConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "git-host %q", hostConfig.Host)
+		}
+		hosts[hostConfig.Host] = host
+		upstreamHosts[host.base.Host] = host
+	}
 
 	if config.FetchInterval == 0 {
 		config.FetchInterval = 15 * time.Minute
@@ -150,6 +189,9 @@ func NewManager(ctx context.Context, config Config, credentialProvider Credentia
 	if config.RepackTimeout == 0 {
 		config.RepackTimeout = 10 * time.Minute
 	}
+	if config.ScrubTimeout == 0 {
+		config.ScrubTimeout = 30 * time.Minute
+	}
 
 	if err := os.MkdirAll(config.MirrorRoot, 0o750); err != nil {
 		return nil, errors.Wrap(err, "create root directory")
@@ -169,6 +211,8 @@ func NewManager(ctx context.Context, config Config, credentialProvider Credentia
 		gitTuningConfig:    DefaultGitTuningConfig(),
 		clones:             make(map[string]*Repository),
 		credentialProvider: credentialProvider,
+		hosts:              hosts,
+		upstreamHosts:      upstreamHosts,
 	}, nil
 }
 
@@ -176,12 +220,59 @@ func (m *Manager) Config() Config {
 	return m.config
 }
 
+// UpstreamURL returns the upstream URL of repoPath on host, the host name
+// clients use in cachew URLs. Hosts without a git-host block are reached
+// over HTTPS at the same name. A host that is only a git-host's upstream
+// host resolves as in MirrorUpstreamURL.
+func (m *Manager) UpstreamURL(host, repoPath string) string {
+	if h, ok := m.hosts[host]; ok {
+		return h.upstreamURL(repoPath)
+	}
+	return m.MirrorUpstreamURL(host, repoPath)
+}
+
+// MirrorUpstreamURL returns the upstream URL of repoPath on host, where both
+// are as they appear in an upstream URL rather than a client's cachew URL:
+// in mirror paths, peer replica requests and webhook payloads. A git-host's
+// upstream host maps back to its base URL's scheme with repoPath, which
+// already includes any base path, taken as-is.
+func (m *Manager) MirrorUpstreamURL(host, repoPath string) string {
+	if h, ok := m.upstreamHosts[host]; ok {
+		return h.base.Scheme + "://" + host + "/" + repoPath
+	}
+	return "https://" + host + "/" + repoPath
+}
+
+// CredentialsForURL returns the credentials for requests to upstreamURL, or
+// zero Credentials if there are none and system credentials apply.
+func (m *Manager) CredentialsForURL(ctx context.Context, upstreamURL string) Credentials {
+	return credentialsForURL(ctx, m.credentialProviderFor(upstreamURL), upstreamURL)
+}
+
+// credentialProviderFor picks the credential provider for upstreamURL: the
+// matching git-host's, else the default provider for github.com.
+func (m *Manager) credentialProviderFor(upstreamURL string) CredentialProvider {
+	if parsed, err := url.Parse(upstreamURL); err == nil {
+		if h, ok := m.upstreamHosts[parsed.Host]; ok {
+			if h.credentials == nil {
+				return nil
+			}
+			return h.credentials
+		}
+	}
+	if m.credentialProvider != nil && strings.Contains(upstreamURL, "github.com") {
+		return m.credentialProvider
+	}
+	return nil
+}
+
 func (m *Manager) GetOrCreate(_ context.Context, upstreamURL string) (*Repository, error) {
 	m.clonesMu.RLock()
 	repo, exists := m.clones[upstreamURL]
 	m.clonesMu.RUnlock()
 
 	if exists {
+		repo.touch()
 		return repo, nil
 	}
 
@@ -189,6 +280,7 @@ func (m *Manager) GetOrCreate(_ context.Context, upstreamURL string) (*Repositor
 	defer m.clonesMu.Unlock()
 
 	if repo, exists = m.clones[upstreamURL]; exists {
+		repo.touch()
 		return repo, nil
 	}
 
@@ -199,17 +291,18 @@ func (m *Manager) GetOrCreate(_ context.Context, upstreamURL string) (*Repositor
 
 	repo = &Repository{
 		state:              StateEmpty,
-		config:             m.config,
+		config:             m.repoConfigLocked(upstreamURL),
 		path:               clonePath,
 		upstreamURL:        upstreamURL,
 		fetchSem:           make(chan struct{}, 1),
-		credentialProvider: m.credentialProvider,
+		credentialProvider: m.credentialProviderFor(upstreamURL),
 	}
 
 	headFile := filepath.Join(clonePath, "HEAD")
 	if _, err := os.Stat(headFile); err == nil {
 		repo.state = StateReady
 	}
+	repo.touch()
 
 	repo.fetchSem <- struct{}{}
 
@@ -217,12 +310,51 @@ func (m *Manager) GetOrCreate(_ context.Context, upstreamURL string) (*Repositor
 	return repo, nil
 }
 
+// SetRepoConfigFunc installs per-upstream overrides of the ref-check
+// interval and ref filter. fn receives the manager's Config and returns the
+// Config for upstreamURL. It applies to known repositories and to any
+// created later; ref filter changes reach a mirror's git config the next
+// time it is configured (on clone or discovery).
+func (m *Manager) SetRepoConfigFunc(fn func(upstreamURL string, config Config) Config) {
+	m.clonesMu.Lock()
+	defer m.clonesMu.Unlock()
+	m.repoConfigFor = fn
+	for upstreamURL, repo := range m.clones {
+		config := m.repoConfigLocked(upstreamURL)
+		// Only the overridable fields are written: the rest of repo.config
+		// is read without the lock.
+		repo.mu.Lock()
+		repo.config.RefCheckInterval = config.RefCheckInterval
+		repo.config.RefInclude = config.RefInclude
+		repo.config.RefExclude = config.RefExclude
+		repo.mu.Unlock()
+	}
+}
+
+// repoConfigLocked returns the Config for a repository with any per-upstream
+// overrides applied. The caller must hold clonesMu.
+func (m *Manager) repoConfigLocked(upstreamURL string) Config {
+	if m.repoConfigFor == nil {
+		return m.config
+	}
+	return m.repoConfigFor(upstreamURL, m.config)
+}
+
 func (m *Manager) Get(upstreamURL string) *Repository {
 	m.clonesMu.RLock()
 	defer m.clonesMu.RUnlock()
 	return m.clones[upstreamURL]
 }
 
+// List returns every repository the manager knows about, sorted by upstream URL.
+func (m *Manager) List() []*Repository {
+	m.clonesMu.RLock()
+	repos := slices.Collect(maps.Values(m.clones))
+	m.clonesMu.RUnlock()
+	slices.SortFunc(repos, func(a, b *Repository) int { return strings.Compare(a.upstreamURL, b.upstreamURL) })
+	return repos
+}
+
 func (m *Manager) DiscoverExisting(ctx context.Context) ([]*Repository, error) {
 	var discovered []*Repository
 	err := filepath.Walk(m.config.MirrorRoot, func(path string, info os.FileInfo, err error) error {
@@ -234,7 +366,8 @@ func (m *Manager) DiscoverExisting(ctx context.Context) ([]*Repository, error) {
 			return nil
 		}
 
-		if strings.HasPrefix(info.Name(), cloneTempPrefix) {
+		if strings.HasPrefix(info.Name(), cloneTempPrefix) || strings.HasPrefix(info.Name(), removeTempPrefix) ||
+			strings.HasPrefix(info.Name(), verifyTempPrefix) {
 			logging.FromContext(ctx).InfoContext(ctx, "Removing leftover clone temp dir", "path", path)
 			if rmErr := os.RemoveAll(path); rmErr != nil {
 				return errors.Wrapf(rmErr, "remove leftover clone temp dir %s", path)
@@ -261,28 +394,28 @@ func (m *Manager) DiscoverExisting(ctx context.Context) ([]*Repository, error) {
 		if !found {
 			return nil
 		}
-		upstreamURL := "https://" + host + "/" + repoPath
+		upstreamURL := m.MirrorUpstreamURL(host, repoPath)
 
+		m.clonesMu.RLock()
+		repoConfig := m.repoConfigLocked(upstreamURL)
+		m.clonesMu.RUnlock()
 		repo := &Repository{
 			state:              StateReady,
-			config:             m.config,
+			config:             repoConfig,
 			path:               path,
 			upstreamURL:        upstreamURL,
 			fetchSem:           make(chan struct{}, 1),
-			credentialProvider: m.credentialProvider,
+			credentialProvider: m.credentialProviderFor(upstreamURL),
 		}
+		// Count discovery as an access so idle-based eviction gives mirrors
+		// found on disk a full grace period after a restart.
+		repo.touch()
 		repo.fetchSem <- struct{}{}
 
-		if err := configureMirror(ctx, path, m.config.PackThreads); err != nil {
+		if err := repo.ConfigureMirror(ctx, path); err != nil {
 			return errors.Wrapf(err, "configure mirror for %s", upstreamURL)
 		}
 
-		if m.config.Maintenance {
-			if err := registerMaintenance(ctx, path); err != nil {
-				return errors.Wrapf(err, "register maintenance for %s", upstreamURL)
-			}
-		}
-
 		m.clonesMu.Lock()
 		m.clones[upstreamURL] = repo
 		m.clonesMu.Unlock()
@@ -337,6 +470,32 @@ func (r *Repository) LastFetch() time.Time {
 	return r.lastFetch
 }
 
+// LastAccess returns when the repository was last looked up via
+// Manager.GetOrCreate, or when it was discovered on disk if it hasn't been
+// looked up since.
+func (r *Repository) LastAccess() time.Time {
+	return time.Unix(0, r.lastAccess.Load())
+}
+
+func (r *Repository) touch() {
+	r.lastAccess.Store(time.Now().UnixNano())
+}
+
+// refFilter returns the repository's current ref filter.
+func (r *Repository) refFilter() refFilter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return refFilter{include: r.config.RefInclude, exclude: r.config.RefExclude}
+}
+
+// RefCheckInterval returns how long this repository's upstream ref check is
+// cached, which bounds how often it is fetched.
+func (r *Repository) RefCheckInterval() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config.RefCheckInterval
+}
+
 func (r *Repository) NeedsFetch(fetchInterval time.Duration) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -366,6 +525,52 @@ func (r *Repository) ResetToEmpty() {
 	r.lastFetch = time.Time{}
 }
 
+// ErrCloneInProgress is returned by Remove when the repository is being
+// cloned or restored and cannot safely be deleted.
+var ErrCloneInProgress = errors.New("clone in progress")
+
+// Remove deletes the mirror from disk and returns the repository to
+// StateEmpty, so the next request clones it again. It waits for any in-flight
+// fetch and holds the write lock while the directory is moved aside, so
+// readers never observe a half-deleted mirror. Background work that doesn't
+// take the lock (e.g. a repack) may still be writing into the old directory,
+// so deleting it is best-effort; DiscoverExisting sweeps anything left over.
+// Returns ErrCloneInProgress if a clone or restore currently owns the
+// directory.
+func (r *Repository) Remove(ctx context.Context) error {
+	var removed string
+	if err := r.WithFetchExclusion(ctx, func() error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.state == StateCloning {
+			return errors.Wrap(ErrCloneInProgress, r.upstreamURL)
+		}
+		if _, err := os.Stat(r.path); err == nil {
+			tmpDir, err := os.MkdirTemp(filepath.Dir(r.path), removeTempPrefix+"*")
+			if err != nil {
+				return errors.Wrap(err, "create temp remove directory")
+			}
+			removed = tmpDir
+			if err := os.Rename(r.path, filepath.Join(tmpDir, "repo")); err != nil {
+				return errors.Wrapf(err, "move mirror for %s aside", r.upstreamURL)
+			}
+		}
+		r.state = StateEmpty
+		r.lastFetch = time.Time{}
+		r.refCheckValid = false
+		return nil
+	}); err != nil {
+		return err
+	}
+	if removed != "" {
+		if err := os.RemoveAll(removed); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to delete removed mirror, leaving for startup cleanup",
+				"upstream", r.upstreamURL, "path", removed, "error", err)
+		}
+	}
+	return nil
+}
+
 // TryStartCloning atomically transitions the repository from StateEmpty to
 // StateCloning. Returns true if this goroutine won the transition and should
 // proceed with the clone/restore; false if another goroutine already claimed it.
@@ -393,11 +598,15 @@ func (r *Repository) WithFetchExclusion(ctx context.Context, fn func() error) er
 }
 
 // ConfigureMirror configures a git directory at repoPath as a mirror with
-// the repository's pack threads and optional maintenance settings.
+// the repository's pack threads, ref filter and optional maintenance
+// settings.
 func (r *Repository) ConfigureMirror(ctx context.Context, repoPath string) error {
 	if err := configureMirror(ctx, repoPath, r.config.PackThreads); err != nil {
 		return errors.Wrap(err, "configure mirror")
 	}
+	if err := configureRefFilter(ctx, repoPath, r.refFilter()); err != nil {
+		return errors.Wrap(err, "configure ref filter")
+	}
 	if r.config.Maintenance {
 		if err := registerMaintenance(ctx, repoPath); err != nil {
 			return errors.Wrap(err, "register maintenance")
@@ -508,6 +717,62 @@ func configureMirror(ctx context.Context, repoPath string, packThreads int) erro
 }
 
 func (r *Repository) executeClone(ctx context.Context) error {
+	return r.cloneIntoPlace(ctx, func(ctx context.Context, dest string) error {
+		config := DefaultGitTuningConfig()
+		// lowSpeedLimit is intentionally omitted: during initial clone of large
+		// repos the server-side pack computation can take minutes at near-zero
+		// transfer rate, which would trip the speed check. The cloneTimeout
+		// provides the overall safety net instead.
+		if filter := r.refFilter(); filter.active() {
+			return r.filteredClone(ctx, dest, filter, []string{"-c", "http.postBuffer=" + strconv.Itoa(config.PostBuffer)})
+		}
+		// #nosec G204 - r.upstreamURL and dest are controlled by us
+		args := []string{
+			"clone", "--mirror",
+			"-c", "http.postBuffer=" + strconv.Itoa(config.PostBuffer),
+			r.upstreamURL, dest,
+		}
+
+		cmd, err := r.GitCommand(ctx, args...)
+		if err != nil {
+			return errors.Wrap(err, "create git command")
+		}
+		output, err := runClone(cmd)
+		if err != nil {
+			return errors.Wrapf(err, "git clone --mirror: %s", string(output))
+		}
+		return nil
+	})
+}
+
+// CloneFromPeer populates the repository by cloning sourceURL, another
+// cachew replica's mirror of the same upstream, sending header (a
+// "Name: value" pair) with every request. Upstream credentials are never
+// offered to the peer. The clone's origin is pointed back at the upstream so
+// later fetches go there. The caller must have claimed the clone with
+// TryStartCloning, and is responsible for fetching the delta from upstream
+// and calling MarkReady.
+func (r *Repository) CloneFromPeer(ctx context.Context, sourceURL, header string) error {
+	return r.cloneIntoPlace(ctx, func(ctx context.Context, dest string) error {
+		// #nosec G204 - sourceURL, header and dest are controlled by us
+		cmd := exec.CommandContext(ctx, "git", "-c", "http.extraHeader="+header, "clone", "--mirror", sourceURL, dest)
+		output, err := runClone(cmd)
+		if err != nil {
+			return errors.Wrapf(err, "git clone --mirror from peer: %s", string(output))
+		}
+		// #nosec G204 - dest and r.upstreamURL are controlled by us
+		cmd = exec.CommandContext(ctx, "git", "-C", dest, "remote", "set-url", "origin", r.upstreamURL)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "point origin at upstream: %s", string(output))
+		}
+		return nil
+	})
+}
+
+// cloneIntoPlace runs clone into a temporary directory next to the mirror,
+// configures the result and renames it into place, so a failed clone never
+// leaves a partial mirror behind.
+func (r *Repository) cloneIntoPlace(ctx context.Context, clone func(ctx context.Context, dest string) error) error {
 	parentDir := filepath.Dir(r.path)
 	if err := os.MkdirAll(parentDir, 0o750); err != nil {
 		return errors.Wrap(err, "create clone directory")
@@ -526,29 +791,8 @@ func (r *Repository) executeClone(ctx context.Context) error {
 	cloneCtx, cancel := context.WithTimeout(ctx, r.config.CloneTimeout)
 	defer cancel()
 
-	config := DefaultGitTuningConfig()
-	// lowSpeedLimit is intentionally omitted: during initial clone of large
-	// repos the server-side pack computation can take minutes at near-zero
-	// transfer rate, which would trip the speed check. The cloneTimeout
-	// provides the overall safety net instead.
-	// #nosec G204 - r.upstreamURL and cloneDest are controlled by us
-	args := []string{
-		"clone", "--mirror",
-		"-c", "http.postBuffer=" + strconv.Itoa(config.PostBuffer),
-		r.upstreamURL, cloneDest,
-	}
-
-	cmd, err := r.GitCommand(cloneCtx, args...)
-	if err != nil {
-		return errors.Wrap(err, "create git command")
-	}
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Cancel = func() error {
-		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-	}
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return errors.Wrapf(err, "git clone --mirror: %s", string(output))
+	if err := clone(cloneCtx, cloneDest); err != nil {
+		return err
 	}
 
 	if err := r.ConfigureMirror(ctx, cloneDest); err != nil {
@@ -561,6 +805,17 @@ func (r *Repository) executeClone(ctx context.Context) error {
 	return nil
 }
 
+// runClone runs a git clone in its own process group so that cancellation
+// also kills the helper processes git spawns.
+func runClone(cmd *exec.Cmd) ([]byte, error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	output, err := cmd.CombinedOutput()
+	return output, errors.WithStack(err)
+}
+
 func (r *Repository) Fetch(ctx context.Context) error {
 	return r.FetchWithTimeout(ctx, r.config.FetchTimeout)
 }
@@ -590,7 +845,10 @@ func (r *Repository) FetchVerified(ctx context.Context) error {
 	return r.fetchInternal(ctx, r.config.FetchTimeout, true, false)
 }
 
-func (r *Repository) fetchInternal(ctx context.Context, timeout time.Duration, enforceSpeedLimit, coalesce bool) error {
+// fetchInternal fetches the mirror's configured refspecs, or only refspecs
+// when given. Explicit refspecs must not coalesce: a concurrent fetch of
+// the configured refspecs would not fetch them.
+func (r *Repository) fetchInternal(ctx context.Context, timeout time.Duration, enforceSpeedLimit, coalesce bool, refspecs ...string) error {
 	select {
 	case <-r.fetchSem:
 		defer func() {
@@ -635,7 +893,12 @@ func (r *Repository) fetchInternal(ctx context.Context, timeout time.Duration, e
 			"-c", "http.lowSpeedTime="+strconv.Itoa(int(config.LowSpeedTime.Seconds())),
 		)
 	}
-	args = append(args, "fetch", "--prune", "--prune-tags")
+	if len(refspecs) > 0 {
+		args = append(args, "fetch", "origin")
+		args = append(args, refspecs...)
+	} else {
+		args = append(args, "fetch", "--prune", "--prune-tags")
+	}
 
 	cmd, err := r.GitCommand(fetchCtx, args...)
 	if err != nil {
@@ -655,6 +918,12 @@ func (r *Repository) fetchInternal(ctx context.Context, timeout time.Duration, e
 
 	r.mu.Lock()
 	r.lastFetch = time.Now()
+	if len(refspecs) == 0 {
+		// The mirror now matches upstream, which is all a ref check would
+		// establish.
+		r.lastRefCheck = r.lastFetch
+		r.refCheckValid = true
+	}
 	r.mu.Unlock()
 	return nil
 }
@@ -689,11 +958,12 @@ func (r *Repository) EnsureRefsUpToDate(ctx context.Context) (needsFetch bool, e
 		return false, errors.Wrap(err, "get upstream refs")
 	}
 
+	filter := r.refFilter()
 	for ref, upstreamSHA := range upstreamRefs {
 		if strings.HasSuffix(ref, "^{}") {
 			continue
 		}
-		if !strings.HasPrefix(ref, "refs/heads/") {
+		if !strings.HasPrefix(ref, "refs/heads/") || !filter.wants(ref) {
 			continue
 		}
 		localSHA, exists := localRefs[ref]
@@ -739,6 +1009,23 @@ func (r *Repository) EnsureRefs(
 	if err := r.FetchWithTimeout(ctx, r.config.FetchTimeout); err != nil {
 		return nil, nil, false, errors.Wrap(err, "fetch upstream")
 	}
+	// Refs left out by the ref filter are never fetched by a plain fetch,
+	// so fetch the requested ones explicitly.
+	filter := r.refFilter()
+	var refspecs []string
+	for ref := range refs {
+		if !filter.wants(ref) {
+			refspecs = append(refspecs, "+"+ref+":"+ref)
+		}
+	}
+	if len(refspecs) > 0 {
+		slices.Sort(refspecs)
+		// A ref missing upstream fails the whole fetch; report it as
+		// unresolved, as for any other missing ref, rather than failing.
+		if err := r.fetchInternal(ctx, r.config.FetchTimeout, true, false, refspecs...); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Fetching filtered refs failed", "upstream", r.upstreamURL, "refs", refspecs, "error", err)
+		}
+	}
 
 	// Invalidate the cached ref-check so the normal transparent path also
 	// re-evaluates after our forced fetch.
@@ -840,8 +1127,20 @@ func (r *Repository) GetUpstreamRefs(ctx context.Context) (map[string]string, er
 // flags maintain the multi-pack index and reachability bitmaps for efficient
 // serving via git http-backend.
 func (r *Repository) Repack(ctx context.Context) error {
+	return r.repack(ctx, "Geometric", "--geometric=2")
+}
+
+// FullRepack rewrites every object into a single pack. It is far more
+// expensive than Repack in time and memory, so it is only worth running
+// rarely, to recover delta compression that geometric repacking never
+// revisits.
+func (r *Repository) FullRepack(ctx context.Context) error {
+	return r.repack(ctx, "Full", "-a")
+}
+
+func (r *Repository) repack(ctx context.Context, kind, mode string) error {
 	logger := logging.FromContext(ctx)
-	logger.InfoContext(ctx, "Geometric repack started", "upstream", r.upstreamURL)
+	logger.InfoContext(ctx, kind+" repack started", "upstream", r.upstreamURL)
 
 	repackCtx, cancel := context.WithTimeout(ctx, r.config.RepackTimeout)
 	defer cancel()
@@ -860,7 +1159,7 @@ func (r *Repository) Repack(ctx context.Context) error {
 		"-c", "pack.threads="+strconv.Itoa(threads),
 		"-c", "pack.windowMemory=256m",
 		"-c", "pack.deltaCacheSize=128m",
-		"repack", "-d", "--geometric=2", "--write-midx", "--write-bitmap-index")
+		"repack", "-d", mode, "--write-midx", "--write-bitmap-index")
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Cancel = func() error {
 		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
@@ -878,10 +1177,95 @@ func (r *Repository) Repack(ctx context.Context) error {
 		return errors.Wrapf(err, "git repack: %s", string(output))
 	}
 
-	logger.InfoContext(ctx, "Geometric repack completed", "upstream", r.upstreamURL)
+	logger.InfoContext(ctx, kind+" repack completed", "upstream", r.upstreamURL)
 	return nil
 }
 
+// maxVerifyOutput bounds the git output kept in a CorruptionError; a badly
+// damaged pack produces an error line per object.
+const maxVerifyOutput = 4096
+
+// CorruptionError reports a mirror that failed an integrity check.
+type CorruptionError struct {
+	Check  string // "fsck", "commit-graph" or "multi-pack-index"
+	Output string
+}
+
+func (e *CorruptionError) Error() string {
+	return e.Check + " verification failed: " + e.Output
+}
+
+// Verify checks the mirror's integrity: object connectivity from every ref,
+// and the commit-graph and multi-pack-index if present. It returns a
+// *CorruptionError if a check fails, or another error if a check could not
+// be run at all. Fetches are excluded only while the refs are snapshotted and
+// the commit-graph and multi-pack-index verified, so a half-written fetch
+// can't be mistaken for corruption. The connectivity check, by far the
+// slowest, then runs from the snapshot while fetches proceed: they only add
+// objects, and the snapshot's refs never point at unwritten ones.
+func (r *Repository) Verify(ctx context.Context) error {
+	verifyCtx, cancel := context.WithTimeout(ctx, r.config.ScrubTimeout)
+	defer cancel()
+	snapshot, err := os.MkdirTemp(filepath.Dir(r.path), verifyTempPrefix+"*")
+	if err != nil {
+		return errors.Wrap(err, "create ref snapshot directory")
+	}
+	defer os.RemoveAll(snapshot) //nolint:errcheck
+	if err := r.WithFetchExclusion(ctx, func() error {
+		if err := r.snapshotRefs(verifyCtx, snapshot); err != nil {
+			return err
+		}
+		// Both verify subcommands succeed when the file doesn't exist.
+		if err := verifyCheck(verifyCtx, r.path, "commit-graph", "commit-graph", "verify", "--no-progress"); err != nil {
+			return err
+		}
+		return verifyCheck(verifyCtx, r.path, "multi-pack-index", "multi-pack-index", "verify", "--no-progress")
+	}); err != nil {
+		return err
+	}
+	return verifyCheck(verifyCtx, snapshot, "fsck", "fsck", "--connectivity-only", "--no-dangling", "--no-progress")
+}
+
+// snapshotRefs initialises a bare repository at dir holding the mirror's
+// current refs and borrowing its objects through alternates.
+func (r *Repository) snapshotRefs(ctx context.Context, dir string) error {
+	// #nosec G204 - dir is controlled by us
+	if output, err := exec.CommandContext(ctx, "git", "init", "--quiet", "--bare", dir).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "git init ref snapshot: %s", output)
+	}
+	objects, err := filepath.Abs(filepath.Join(r.path, "objects"))
+	if err != nil {
+		return errors.Wrap(err, "resolve objects directory")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "objects", "info", "alternates"), []byte(objects+"\n"), 0o644); err != nil { //nolint:gosec
+		return errors.Wrap(err, "write ref snapshot alternates")
+	}
+	// #nosec G204 - r.path is controlled by us
+	refs, err := exec.CommandContext(ctx, "git", "-C", r.path, "for-each-ref", "--format=%(objectname) %(refname)").Output()
+	if err != nil {
+		return errors.Wrap(err, "git for-each-ref")
+	}
+	return errors.Wrap(os.WriteFile(filepath.Join(dir, "packed-refs"), refs, 0o644), "write ref snapshot") //nolint:gosec
+}
+
+// verifyCheck runs a git integrity check in dir, returning a
+// *CorruptionError named check if git reports a problem.
+func verifyCheck(ctx context.Context, dir, check string, args ...string) error {
+	// #nosec G204 - dir and args are controlled by us
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if _, ok := errors.AsType[*exec.ExitError](err); ok && ctx.Err() == nil {
+		if len(output) > maxVerifyOutput {
+			output = output[:maxVerifyOutput]
+		}
+		return errors.WithStack(&CorruptionError{Check: check, Output: strings.TrimSpace(string(output))})
+	}
+	return errors.Wrapf(err, "git %s verify", check)
+}
+
 func (r *Repository) HasCommit(ctx context.Context, ref string) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
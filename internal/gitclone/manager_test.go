@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/logging"
 )
@@ -36,6 +37,7 @@ func testRepoConfig() Config {
 		FetchTimeout:    5 * time.Minute,
 		LsRemoteTimeout: 60 * time.Second,
 		RepackTimeout:   10 * time.Minute,
+		ScrubTimeout:    10 * time.Minute,
 	}
 }
 
@@ -168,6 +170,71 @@ func TestManager_Get(t *testing.T) {
 	assert.Equal(t, upstreamURL, repo.UpstreamURL())
 }
 
+func TestManager_SetRepoConfigFunc(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	manager, err := NewManager(ctx, Config{MirrorRoot: t.TempDir(), RefCheckInterval: 10 * time.Second}, nil)
+	assert.NoError(t, err)
+
+	before, err := manager.GetOrCreate(ctx, "https://github.com/org/mono")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, before.RefCheckInterval())
+
+	manager.SetRepoConfigFunc(func(upstreamURL string, config Config) Config {
+		if upstreamURL == "https://github.com/org/mono" {
+			config.RefCheckInterval = time.Second
+			config.RefExclude = []string{"refs/pull/*"}
+		}
+		return config
+	})
+	assert.Equal(t, time.Second, before.RefCheckInterval())
+	assert.Equal(t, refFilter{exclude: []string{"refs/pull/*"}}, before.refFilter())
+
+	other, err := manager.GetOrCreate(ctx, "https://github.com/org/other")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, other.RefCheckInterval())
+	assert.False(t, other.refFilter().active())
+}
+
+func TestManager_List(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	manager, err := NewManager(ctx, Config{MirrorRoot: t.TempDir()}, nil)
+	assert.NoError(t, err)
+
+	for _, u := range []string{"https://github.com/b/repo", "https://github.com/a/repo"} {
+		_, err := manager.GetOrCreate(ctx, u)
+		assert.NoError(t, err)
+	}
+
+	var urls []string
+	for _, repo := range manager.List() {
+		urls = append(urls, repo.UpstreamURL())
+	}
+	assert.Equal(t, []string{"https://github.com/a/repo", "https://github.com/b/repo"}, urls)
+}
+
+func TestRepository_Remove(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+	manager, err := NewManager(ctx, Config{MirrorRoot: tmpDir}, nil)
+	assert.NoError(t, err)
+
+	repoPath := filepath.Join(tmpDir, "github.com", "user", "repo")
+	assert.NoError(t, os.MkdirAll(repoPath, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, "HEAD"), []byte("ref: refs/heads/main\n"), 0o644))
+
+	repo, err := manager.GetOrCreate(ctx, "https://github.com/user/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, StateReady, repo.State())
+
+	assert.NoError(t, repo.Remove(ctx))
+	assert.Equal(t, StateEmpty, repo.State())
+	_, err = os.Stat(repoPath)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.True(t, repo.TryStartCloning())
+	assert.IsError(t, repo.Remove(ctx), ErrCloneInProgress)
+}
+
 func TestManager_DiscoverExisting(t *testing.T) {
 	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
 	tmpDir := t.TempDir()
@@ -443,6 +510,47 @@ func TestRepository_CloneSetsMirrorConfig(t *testing.T) {
 	}
 }
 
+func TestRepository_CloneFromPeer(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	upstreamPath := createBareRepo(t, filepath.Join(tmpDir, "upstream"))
+	peerPath := filepath.Join(tmpDir, "peer.git")
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", upstreamPath, peerPath).Run())
+
+	clonePath := filepath.Join(tmpDir, "clone")
+	repo := &Repository{
+		state:       StateEmpty,
+		config:      testRepoConfig(),
+		path:        clonePath,
+		upstreamURL: upstreamPath,
+		fetchSem:    make(chan struct{}, 1),
+	}
+	repo.fetchSem <- struct{}{}
+
+	assert.True(t, repo.TryStartCloning())
+	assert.NoError(t, repo.CloneFromPeer(ctx, peerPath, "X-Test: 1"))
+	// The caller owns the state transition.
+	assert.Equal(t, StateCloning, repo.State())
+
+	output, err := exec.Command("git", "-C", clonePath, "remote", "get-url", "origin").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, upstreamPath, strings.TrimSpace(string(output)))
+	output, err = exec.Command("git", "-C", clonePath, "config", "--get", "uploadpack.allowFilter").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "true", strings.TrimSpace(string(output)))
+
+	// A missing peer fails without leaving debris.
+	failed := &Repository{config: testRepoConfig(), path: filepath.Join(tmpDir, "failed"), upstreamURL: upstreamPath}
+	assert.Error(t, failed.CloneFromPeer(ctx, filepath.Join(tmpDir, "missing.git"), "X-Test: 1"))
+	entries, err := os.ReadDir(tmpDir)
+	assert.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.HasPrefix(e.Name(), cloneTempPrefix), "leftover temp dir %s", e.Name())
+	}
+	_, err = os.Stat(failed.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
 func TestRepository_CloneFailedLeavesNoDebris(t *testing.T) {
 	ctx := context.Background()
 	tmpDir := t.TempDir()
@@ -521,6 +629,47 @@ func TestRepository_Repack(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRepository_FullRepack(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+	upstreamPath := createBareRepo(t, tmpDir)
+	workPath := filepath.Join(tmpDir, "work")
+
+	clonePath := filepath.Join(tmpDir, "mirror")
+	assert.NoError(t, exec.Command("git", "clone", "--mirror", upstreamPath, clonePath).Run())
+
+	// Each fetch of a new commit lands as its own pack.
+	for i := range 3 {
+		assert.NoError(t, os.WriteFile(filepath.Join(workPath, "f.txt"), []byte(fmt.Sprint(i)), 0o644))
+		for _, args := range [][]string{
+			{"git", "-C", workPath, "commit", "-am", "update"},
+			{"git", "-C", clonePath, "-c", "fetch.unpackLimit=1", "fetch", workPath, "+HEAD:refs/heads/work"},
+		} {
+			assert.NoError(t, exec.Command(args[0], args[1:]...).Run())
+		}
+	}
+	packs, err := filepath.Glob(filepath.Join(clonePath, "objects", "pack", "*.pack"))
+	assert.NoError(t, err)
+	assert.True(t, len(packs) > 1, "expected several packs before full repack, got %d", len(packs))
+
+	repo := &Repository{
+		state:       StateReady,
+		config:      testRepoConfig(),
+		path:        clonePath,
+		upstreamURL: upstreamPath,
+		fetchSem:    make(chan struct{}, 1),
+	}
+	repo.fetchSem <- struct{}{}
+
+	assert.NoError(t, repo.FullRepack(ctx))
+
+	packs, err = filepath.Glob(filepath.Join(clonePath, "objects", "pack", "*.pack"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(packs))
+	_, err = os.Stat(filepath.Join(clonePath, "objects", "pack", "multi-pack-index"))
+	assert.NoError(t, err)
+}
+
 func TestRepository_Repack_CleansUpStaleLockOnFailure(t *testing.T) {
 	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
 	tmpDir := t.TempDir()
@@ -552,6 +701,38 @@ func TestRepository_Repack_CleansUpStaleLockOnFailure(t *testing.T) {
 	assert.True(t, os.IsNotExist(statErr), "expected multi-pack-index.lock to be removed after failed repack")
 }
 
+func TestRepository_Verify(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	upstreamPath := createBareRepo(t, tmpDir)
+
+	repo := &Repository{
+		state:       StateEmpty,
+		config:      testRepoConfig(),
+		path:        filepath.Join(tmpDir, "clone"),
+		upstreamURL: upstreamPath,
+		fetchSem:    make(chan struct{}, 1),
+	}
+	repo.fetchSem <- struct{}{}
+	assert.NoError(t, repo.Clone(ctx))
+	assert.NoError(t, exec.Command("git", "-C", repo.path, "repack", "-adq", "--write-midx").Run())
+	assert.NoError(t, exec.Command("git", "-C", repo.path, "commit-graph", "write", "--reachable").Run())
+	assert.NoError(t, repo.Verify(ctx))
+
+	// A ref pointing at a missing object is what a crash mid-fetch leaves behind.
+	assert.NoError(t, os.WriteFile(filepath.Join(repo.path, "refs", "heads", "broken"),
+		[]byte("1111111111111111111111111111111111111111\n"), 0o644))
+	err := repo.Verify(ctx)
+	corrupt, ok := errors.AsType[*CorruptionError](err)
+	assert.True(t, ok, "expected CorruptionError, got %v", err)
+	assert.Equal(t, "fsck", corrupt.Check)
+	assert.Contains(t, corrupt.Output, "refs/heads/broken")
+
+	snapshots, err := filepath.Glob(filepath.Join(tmpDir, verifyTempPrefix+"*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(snapshots), "ref snapshots should be removed")
+}
+
 func TestRepository_HasCommit(t *testing.T) {
 	ctx := context.Background()
 	tmpDir := t.TempDir()
@@ -0,0 +1,175 @@
+package gitclone
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/alecthomas/errors"
+)
+
+// refFilter selects which upstream refs a mirror fetches and advertises to
+// clients. Patterns are exact ref names or prefixes ending in "/*", the only
+// forms that both refspecs and uploadpack.hideRefs can express.
+type refFilter struct {
+	include []string // empty includes every ref
+	exclude []string
+}
+
+// ValidateRefPatterns checks that each pattern is a full ref name, optionally
+// ending in "/*".
+func ValidateRefPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		name := strings.TrimSuffix(pattern, "/*")
+		if !strings.HasPrefix(pattern, "refs/") || strings.ContainsAny(name, "*?[\\^: ") {
+			return errors.Errorf("invalid ref pattern %q: expected a ref name under refs/, optionally ending in /*", pattern)
+		}
+	}
+	return nil
+}
+
+func (f refFilter) active() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// wants reports whether ref is mirrored under this filter.
+func (f refFilter) wants(ref string) bool {
+	if len(f.include) > 0 && !matchesAnyRefPattern(f.include, ref) {
+		return false
+	}
+	return !matchesAnyRefPattern(f.exclude, ref)
+}
+
+// fetchRefspecs returns the remote.origin.fetch values for the filter. With
+// no filter this is the plain mirror refspec.
+func (f refFilter) fetchRefspecs() []string {
+	include := f.include
+	if len(include) == 0 {
+		include = []string{"refs/*"}
+	}
+	refspecs := make([]string, 0, len(include)+len(f.exclude))
+	for _, pattern := range include {
+		refspecs = append(refspecs, "+"+pattern+":"+pattern)
+	}
+	for _, pattern := range f.exclude {
+		refspecs = append(refspecs, "^"+pattern)
+	}
+	return refspecs
+}
+
+// hideRefs returns the uploadpack.hideRefs values for the filter. Later
+// entries override earlier ones, so everything is hidden first when there
+// are includes, then included prefixes are re-exposed, then excludes hidden.
+func (f refFilter) hideRefs() []string {
+	var hide []string
+	if len(f.include) > 0 {
+		hide = append(hide, "refs")
+		for _, pattern := range f.include {
+			hide = append(hide, "!"+strings.TrimSuffix(pattern, "/*"))
+		}
+	}
+	for _, pattern := range f.exclude {
+		hide = append(hide, strings.TrimSuffix(pattern, "/*"))
+	}
+	return hide
+}
+
+func matchesAnyRefPattern(patterns []string, ref string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(ref, prefix) {
+				return true
+			}
+		} else if ref == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// configureRefFilter writes the filter's fetch refspecs and hidden refs into
+// the mirror at repoPath, replacing any previous values so a changed or
+// removed filter takes effect on the next fetch.
+func configureRefFilter(ctx context.Context, repoPath string, filter refFilter) error {
+	for _, setting := range []struct {
+		key    string
+		values []string
+	}{
+		{"remote.origin.fetch", filter.fetchRefspecs()},
+		{"uploadpack.hideRefs", filter.hideRefs()},
+	} {
+		key := setting.key
+		// #nosec G204 - repoPath and key are controlled by us
+		cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "config", "--unset-all", key)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			// Exit status 5 means the key was not set.
+			if exitErr, ok := errors.AsType[*exec.ExitError](err); !ok || exitErr.ExitCode() != 5 {
+				return errors.Wrapf(err, "unset %s: %s", key, string(output))
+			}
+		}
+		for _, value := range setting.values {
+			// #nosec G204 - repoPath, key and value are controlled by us
+			cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "config", "--add", key, value)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return errors.Wrapf(err, "add %s: %s", key, string(output))
+			}
+		}
+	}
+	return nil
+}
+
+// filteredClone creates a mirror at dest that only fetches the refs selected
+// by filter. git clone --mirror always fetches every ref on the initial
+// fetch, so the repository is initialised and configured before fetching.
+func (r *Repository) filteredClone(ctx context.Context, dest string, filter refFilter, fetchArgs []string) error {
+	// #nosec G204 - dest is controlled by us
+	cmd := exec.CommandContext(ctx, "git", "init", "--bare", "--quiet", dest)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "git init: %s", string(output))
+	}
+	for _, kv := range [][2]string{
+		{"remote.origin.url", r.upstreamURL},
+		{"remote.origin.mirror", "true"},
+	} {
+		// #nosec G204 - dest and config values are controlled by us
+		cmd := exec.CommandContext(ctx, "git", "-C", dest, "config", kv[0], kv[1])
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "configure %s: %s", kv[0], string(output))
+		}
+	}
+	if err := configureRefFilter(ctx, dest, filter); err != nil {
+		return err
+	}
+
+	cmd, err := r.GitCommand(ctx, append([]string{"-C", dest}, append(fetchArgs, "fetch", "--quiet", "origin")...)...)
+	if err != nil {
+		return errors.Wrap(err, "create git command")
+	}
+	if output, err := runClone(cmd); err != nil {
+		return errors.Wrapf(err, "git fetch: %s", string(output))
+	}
+
+	// Point HEAD at upstream's default branch, as clone would.
+	cmd, err = r.GitCommand(ctx, "-C", dest, "ls-remote", "--symref", "origin", "HEAD")
+	if err != nil {
+		return errors.Wrap(err, "create git command")
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return errors.Wrap(err, "git ls-remote --symref")
+	}
+	for line := range strings.Lines(string(output)) {
+		head, ok := strings.CutPrefix(line, "ref: ")
+		if !ok {
+			continue
+		}
+		head, _, _ = strings.Cut(head, "\t")
+		// #nosec G204 - dest and head are controlled by us
+		cmd := exec.CommandContext(ctx, "git", "-C", dest, "symbolic-ref", "HEAD", head)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "set HEAD: %s", string(output))
+		}
+		break
+	}
+	return nil
+}
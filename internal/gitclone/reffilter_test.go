@@ -0,0 +1,78 @@
+package gitclone //nolint:testpackage // white-box testing required for unexported fields
+
+import (
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestRefFilter(t *testing.T) {
+	filter := refFilter{include: []string{"refs/heads/*", "refs/tags/*"}, exclude: []string{"refs/heads/wip/*"}}
+	assert.True(t, filter.wants("refs/heads/main"))
+	assert.True(t, filter.wants("refs/tags/v1"))
+	assert.False(t, filter.wants("refs/heads/wip/x"))
+	assert.False(t, filter.wants("refs/pull/1/head"))
+	assert.Equal(t, []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*", "^refs/heads/wip/*"}, filter.fetchRefspecs())
+	assert.Equal(t, []string{"refs", "!refs/heads", "!refs/tags", "refs/heads/wip"}, filter.hideRefs())
+
+	assert.Equal(t, []string{"+refs/*:refs/*"}, refFilter{}.fetchRefspecs())
+	assert.Equal(t, 0, len(refFilter{}.hideRefs()))
+
+	assert.NoError(t, ValidateRefPatterns([]string{"refs/pull/*", "refs/heads/main"}))
+	for _, bad := range []string{"pull/*", "refs/pull/*/merge", "refs/heads/*x", "refs/heads/a b"} {
+		assert.Error(t, ValidateRefPatterns([]string{bad}), bad)
+	}
+}
+
+func TestRepository_RefFilter(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+	upstreamPath := createBareRepo(t, tmpDir)
+	for _, ref := range []string{"refs/pull/1/head", "refs/heads/feature"} {
+		assert.NoError(t, exec.Command("git", "-C", upstreamPath, "update-ref", ref, "HEAD").Run())
+	}
+	headOutput, err := exec.Command("git", "-C", upstreamPath, "symbolic-ref", "HEAD").Output()
+	assert.NoError(t, err)
+
+	config := testRepoConfig()
+	config.RefExclude = []string{"refs/pull/*"}
+	repo := &Repository{
+		state:       StateEmpty,
+		config:      config,
+		path:        filepath.Join(tmpDir, "mirror"),
+		upstreamURL: upstreamPath,
+		fetchSem:    make(chan struct{}, 1),
+	}
+	repo.fetchSem <- struct{}{}
+	assert.NoError(t, repo.Clone(ctx))
+
+	refs, err := repo.GetLocalRefs(ctx)
+	assert.NoError(t, err)
+	assert.NotZero(t, refs["refs/heads/feature"])
+	_, hasPull := refs["refs/pull/1/head"]
+	assert.False(t, hasPull, "excluded refs should not be cloned")
+	mirrorHead, err := exec.Command("git", "-C", repo.path, "symbolic-ref", "HEAD").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, string(headOutput), string(mirrorHead))
+
+	// Excluded refs fetched on demand are still hidden from clients.
+	resolved, _, fetched, err := repo.EnsureRefs(ctx, map[string]string{"refs/pull/1/head": ""}, nil)
+	assert.NoError(t, err)
+	assert.True(t, fetched)
+	assert.Equal(t, refs["refs/heads/feature"], resolved["refs/pull/1/head"])
+	advertised, err := exec.Command("git", "ls-remote", repo.path).Output()
+	assert.NoError(t, err)
+	assert.Contains(t, string(advertised), "refs/heads/feature")
+	assert.False(t, strings.Contains(string(advertised), "refs/pull/"), "excluded refs should be hidden from clients")
+
+	// A missing excluded ref is reported as unresolved, not as an error.
+	resolved, _, _, err = repo.EnsureRefs(ctx, map[string]string{"refs/pull/2/head": ""}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resolved["refs/pull/2/head"])
+}
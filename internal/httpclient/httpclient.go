@@ -0,0 +1,208 @@
+// Package httpclient builds the HTTP transport strategies use for upstream
+// requests.
+//
+// A Config block is declared in the global configuration, and may be
+// overridden by an `upstream` block in each strategy. A TransportProvider
+// lazily constructs the global transport once, so that every strategy without
+// its own block shares its connection pool.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// Config tunes the HTTP transport used for upstream requests. It is intended
+// to be embedded as an HCL block (e.g. `hcl:"upstream,block"`).
+type Config struct {
+	DialTimeout           time.Duration `hcl:"dial-timeout,optional" help:"Timeout for establishing upstream TCP connections." default:"30s"`
+	TLSHandshakeTimeout   time.Duration `hcl:"tls-handshake-timeout,optional" help:"Timeout for upstream TLS handshakes." default:"10s"`
+	ResponseHeaderTimeout time.Duration `hcl:"response-header-timeout,optional" help:"How long to wait for upstream response headers after sending a request. 0 waits indefinitely." default:"0"`
+	IdleConnTimeout       time.Duration `hcl:"idle-conn-timeout,optional" help:"How long an idle upstream connection is kept open." default:"90s"`
+	MaxIdleConns          int           `hcl:"max-idle-conns,optional" help:"Maximum idle upstream connections across all hosts. 0 is unlimited." default:"100"`
+	MaxIdleConnsPerHost   int           `hcl:"max-idle-conns-per-host,optional" help:"Maximum idle upstream connections per host." default:"16"`
+	MaxConnsPerHost       int           `hcl:"max-conns-per-host,optional" help:"Maximum upstream connections per host, including active ones. 0 is unlimited." default:"0"`
+	Proxy                 string        `hcl:"proxy,optional" help:"Egress proxy URL for upstream requests. Empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment." default:""`
+	CABundle              string        `hcl:"ca-bundle,optional" help:"PEM file of CA certificates to trust for upstream TLS, in addition to the system's." default:""`
+	HTTP2                 bool          `hcl:"http2,optional" help:"Negotiate HTTP/2 with upstreams that support it." default:"true"`
+	HTTP2PingTimeout      time.Duration `hcl:"http2-ping-timeout,optional" help:"Send a health check ping on an HTTP/2 connection that has received no frames for this long. 0 disables pings." default:"0"`
+	Retries               int           `hcl:"retries,optional" help:"How many times to retry an idempotent upstream request that fails to connect, or gets a 429, 502, 503 or 504 response." default:"2"`
+	RetryBackoff          time.Duration `hcl:"retry-backoff,optional" help:"Delay before the first retry, doubled for each subsequent retry, with jitter." default:"100ms"`
+	RetryMaxBackoff       time.Duration `hcl:"retry-max-backoff,optional" help:"Maximum delay between retries." default:"5s"`
+}
+
+// TransportProvider is a function that lazily creates a singleton transport.
+type TransportProvider func() (http.RoundTripper, error)
+
+// NewTransportProvider returns a TransportProvider that will construct the
+// transport at most once using the supplied Config.
+func NewTransportProvider(config Config) TransportProvider {
+	return sync.OnceValues(func() (http.RoundTripper, error) {
+		return NewTransport(config)
+	})
+}
+
+// Resolve returns the transport for a strategy: built from its own config if
+// it has one, or else the global transport. Without either, it returns
+// [http.DefaultTransport].
+func Resolve(global TransportProvider, override *Config) (http.RoundTripper, error) {
+	switch {
+	case override != nil:
+		return NewTransport(*override)
+	case global != nil:
+		return global()
+	default:
+		return http.DefaultTransport, nil
+	}
+}
+
+// NewTransport constructs a transport from the given Config, which retries
+// idempotent requests that fail transiently.
+func NewTransport(config Config) (http.RoundTripper, error) {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("default transport is not an *http.Transport")
+	}
+	t = t.Clone()
+	dialer := &net.Dialer{Timeout: config.DialTimeout, KeepAlive: 30 * time.Second}
+	t.DialContext = dialer.DialContext
+	t.TLSHandshakeTimeout = config.TLSHandshakeTimeout
+	t.ResponseHeaderTimeout = config.ResponseHeaderTimeout
+	t.IdleConnTimeout = config.IdleConnTimeout
+	t.MaxIdleConns = config.MaxIdleConns
+	t.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = config.MaxConnsPerHost
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, errors.Errorf("invalid proxy URL: %w", err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CABundle != "" {
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, errors.Errorf("read CA bundle: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("%s: no certificates found", config.CABundle)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+
+	if config.HTTP2 {
+		t.HTTP2 = &http.HTTP2Config{SendPingTimeout: config.HTTP2PingTimeout}
+	} else {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(true)
+	}
+
+	if config.Retries <= 0 {
+		return t, nil
+	}
+	return &retryTransport{
+		next:       t,
+		retries:    config.Retries,
+		backoff:    config.RetryBackoff,
+		maxBackoff: config.RetryMaxBackoff,
+	}, nil
+}
+
+// retryTransport retries idempotent requests that fail to connect or get a
+// response indicating the upstream is temporarily unavailable, with
+// exponential backoff and jitter.
+type retryTransport struct {
+	next       http.RoundTripper
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+var _ http.RoundTripper = (*retryTransport)(nil)
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !isReplayable(r) {
+		return t.next.RoundTrip(r) //nolint:wrapcheck
+	}
+	ctx := r.Context()
+	for attempt := 0; ; attempt++ {
+		req := r
+		if attempt > 0 && r.Body != nil && r.Body != http.NoBody {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "rewind request body")
+			}
+			req = r.Clone(ctx)
+			req.Body = body
+		}
+		resp, err := t.next.RoundTrip(req)
+		if attempt == t.retries || ctx.Err() != nil || !isRetryable(resp, err) {
+			return resp, err //nolint:wrapcheck
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck // drain for keep-alive
+			_ = resp.Body.Close()
+		}
+		timer := time.NewTimer(t.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.WithStack(context.Cause(ctx))
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the backoff before retry attempt+1: the base backoff doubled
+// per attempt and capped, with the upper half randomised.
+func (t *retryTransport) delay(attempt int) time.Duration {
+	d := t.backoff << min(attempt, 30)
+	if d <= 0 || (t.maxBackoff > 0 && d > t.maxBackoff) {
+		d = t.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1) //nolint:gosec // jitter needs no cryptographic randomness
+}
+
+// isReplayable reports whether r is idempotent and can be sent again, as
+// net/http decides for retries on stale connections.
+func isReplayable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != "" || r.Header.Get("X-Idempotency-Key") != ""
+}
+
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package httpclient_test
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httpclient"
+)
+
+func testConfig() httpclient.Config {
+	return httpclient.Config{
+		DialTimeout:         time.Second,
+		TLSHandshakeTimeout: time.Second,
+		IdleConnTimeout:     time.Minute,
+		MaxIdleConnsPerHost: 4,
+		HTTP2:               true,
+		Retries:             2,
+		RetryBackoff:        time.Millisecond,
+		RetryMaxBackoff:     10 * time.Millisecond,
+	}
+}
+
+// flakyServer fails the first failures requests with status.
+func flakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck
+		_, _ = w.Write(append([]byte("ok"), body...))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	server, calls := flakyServer(t, 2, http.StatusServiceUnavailable)
+	transport, err := httpclient.NewTransport(testConfig())
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	server, calls := flakyServer(t, 10, http.StatusBadGateway)
+	transport, err := httpclient.NewTransport(testConfig())
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestNoRetryForNonRetryableStatus(t *testing.T) {
+	server, calls := flakyServer(t, 1, http.StatusInternalServerError)
+	transport, err := httpclient.NewTransport(testConfig())
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestNoRetryForNonIdempotentRequests(t *testing.T) {
+	server, calls := flakyServer(t, 1, http.StatusServiceUnavailable)
+	transport, err := httpclient.NewTransport(testConfig())
+	assert.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// An idempotency key makes a request with a rewindable body retryable.
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL, strings.NewReader("body"))
+	assert.NoError(t, err)
+	req.Header.Set("Idempotency-Key", "abc")
+	calls.Store(0)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "okbody", string(body))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	server, calls := flakyServer(t, 10, http.StatusServiceUnavailable)
+	config := testConfig()
+	config.Retries = 5
+	config.RetryBackoff = time.Hour
+	config.RetryMaxBackoff = time.Hour
+	transport, err := httpclient.NewTransport(config)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Do(req) //nolint:bodyclose
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestEgressProxy(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.RequestURI)
+		_, _ = w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+	config := testConfig()
+	config.Proxy = proxy.URL
+	transport, err := httpclient.NewTransport(config)
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: transport}).Get("http://upstream.example.com/file")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "via proxy", string(body))
+	assert.Equal(t, "http://upstream.example.com/file", proxied.Load())
+}
+
+func TestCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// Without the server's CA the request fails.
+	transport, err := httpclient.NewTransport(testConfig())
+	assert.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(server.URL) //nolint:bodyclose
+	assert.Error(t, err)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := server.Certificate()
+	err = os.WriteFile(bundle, pemEncode(cert.Raw), 0o600)
+	assert.NoError(t, err)
+	config := testConfig()
+	config.CABundle = bundle
+	transport, err = httpclient.NewTransport(config)
+	assert.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err = os.WriteFile(bundle, []byte("not a certificate"), 0o600)
+	assert.NoError(t, err)
+	_, err = httpclient.NewTransport(config)
+	assert.Error(t, err)
+}
+
+func TestResolve(t *testing.T) {
+	transport, err := httpclient.Resolve(nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.DefaultTransport, transport)
+
+	global := httpclient.NewTransportProvider(testConfig())
+	shared, err := global()
+	assert.NoError(t, err)
+	transport, err = httpclient.Resolve(global, nil)
+	assert.NoError(t, err)
+	assert.True(t, transport == shared, "strategies without an upstream block share the global transport")
+
+	override := testConfig()
+	transport, err = httpclient.Resolve(global, &override)
+	assert.NoError(t, err)
+	assert.True(t, transport != shared, "an upstream block gets its own transport")
+}
+
+func pemEncode(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
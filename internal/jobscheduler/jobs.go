@@ -5,6 +5,7 @@ import (
 	"context"
 	"log/slog"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -52,6 +53,10 @@ type Scheduler interface {
 	//
 	// Jobs run concurrently across queues, but never within a queue.
 	SubmitPeriodicJob(queue, id string, interval time.Duration, run func(ctx context.Context) error)
+	// CancelQueue drops pending jobs on the queue and stops periodic jobs
+	// registered on it from re-arming. A job already running is left to
+	// finish. Jobs submitted after the call run normally.
+	CancelQueue(queue string)
 }
 
 type prefixedScheduler struct {
@@ -67,6 +72,10 @@ func (p *prefixedScheduler) SubmitPeriodicJob(queue, id string, interval time.Du
 	p.scheduler.SubmitPeriodicJob(queue, p.prefix+id, interval, run)
 }
 
+func (p *prefixedScheduler) CancelQueue(queue string) {
+	p.scheduler.CancelQueue(queue)
+}
+
 func (p *prefixedScheduler) WithQueuePrefix(prefix string) Scheduler {
 	return &prefixedScheduler{
 		prefix:    p.prefix + "-" + prefix,
@@ -83,6 +92,9 @@ type RootScheduler struct {
 	active              map[string]string // queue -> job id
 	activeClones        int
 	maxCloneConcurrency int
+	// epochs counts CancelQueue calls per queue. Periodic jobs capture the
+	// epoch when registered and stop re-arming once it moves on.
+	epochs map[string]uint64
 	// ctx is cancelled when the scheduler is torn down; periodic re-arm
 	// goroutines select on it so they exit instead of submitting to a dead
 	// scheduler.
@@ -128,6 +140,7 @@ func New(ctx context.Context, config Config) (*RootScheduler, error) {
 	q := &RootScheduler{
 		active:              make(map[string]string),
 		maxCloneConcurrency: maxClones,
+		epochs:              make(map[string]uint64),
 		store:               store,
 		metrics:             m,
 		drain:               make(chan struct{}),
@@ -191,13 +204,22 @@ func (q *RootScheduler) Submit(queue, id string, run func(ctx context.Context) e
 }
 
 func (q *RootScheduler) SubmitPeriodicJob(queue, id string, interval time.Duration, run func(ctx context.Context) error) {
-	if q.ctx.Err() != nil || q.isDraining() {
+	q.submitPeriodicJob(queue, id, interval, run, q.queueEpoch(queue))
+}
+
+func (q *RootScheduler) submitPeriodicJob(queue, id string, interval time.Duration, run func(ctx context.Context) error, epoch uint64) {
+	if q.ctx.Err() != nil || q.isDraining() || q.queueEpoch(queue) != epoch {
 		return
 	}
 	key := jobKey(queue, id)
 	delay := q.periodicDelay(key, interval)
 	submit := func() {
 		q.Submit(queue, id, func(ctx context.Context) error {
+			// The queue may have been cancelled while this firing sat in a
+			// timer; a cancelled periodic job neither runs nor re-arms.
+			if q.queueEpoch(queue) != epoch {
+				return nil
+			}
 			err := run(ctx)
 			if q.store != nil {
 				if storeErr := q.store.SetLastRun(key, time.Now()); storeErr != nil {
@@ -209,7 +231,7 @@ func (q *RootScheduler) SubmitPeriodicJob(queue, id string, interval time.Durati
 			// to wake and submit to a dead scheduler. The new pod's
 			// warmExistingRepos re-registers periodic jobs on startup.
 			go q.sleepThenSubmit(interval, func() {
-				q.SubmitPeriodicJob(queue, id, interval, run)
+				q.submitPeriodicJob(queue, id, interval, run, epoch)
 			})
 			return errors.WithStack(err)
 		})
@@ -221,6 +243,20 @@ func (q *RootScheduler) SubmitPeriodicJob(queue, id string, interval time.Durati
 	go q.sleepThenSubmit(delay, submit)
 }
 
+func (q *RootScheduler) CancelQueue(queue string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.epochs[queue]++
+	q.queue = slices.DeleteFunc(q.queue, func(job queueJob) bool { return job.queue == queue })
+	q.recordGaugesLocked()
+}
+
+func (q *RootScheduler) queueEpoch(queue string) uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.epochs[queue]
+}
+
 func (q *RootScheduler) isDraining() bool {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -313,6 +313,42 @@ func TestJobSchedulerPeriodicJobStopsOnCancel(t *testing.T) {
 		"periodic job should not fire after scheduler context is cancelled")
 }
 
+func TestJobSchedulerCancelQueueStopsPeriodicJob(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scheduler := newTestScheduler(ctx, t, jobscheduler.Config{Concurrency: 2})
+
+	var cancelled, other atomic.Int32
+	scheduler.SubmitPeriodicJob("queue1", "periodic", 50*time.Millisecond, func(_ context.Context) error {
+		cancelled.Add(1)
+		return nil
+	})
+	scheduler.SubmitPeriodicJob("queue2", "periodic", 50*time.Millisecond, func(_ context.Context) error {
+		other.Add(1)
+		return nil
+	})
+
+	eventually(t, time.Second, func() bool { return cancelled.Load() >= 2 },
+		"periodic job should fire at least twice before CancelQueue")
+
+	scheduler.CancelQueue("queue1")
+	time.Sleep(100 * time.Millisecond)
+	before, otherBefore := cancelled.Load(), other.Load()
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, before, cancelled.Load(), "cancelled periodic job should not fire again")
+	assert.True(t, other.Load() > otherBefore, "periodic jobs on other queues should keep firing")
+
+	// The queue is usable again for new submissions.
+	var resubmitted atomic.Bool
+	scheduler.Submit("queue1", "after-cancel", func(_ context.Context) error {
+		resubmitted.Store(true)
+		return nil
+	})
+	eventually(t, time.Second, resubmitted.Load, "jobs submitted after CancelQueue should run")
+}
+
 // TestJobSchedulerSubmitDroppedAfterShutdown verifies that submissions made
 // after the scheduler has been shut down are silently dropped rather than
 // accumulating in the queue (which would leak the closure capture forever).
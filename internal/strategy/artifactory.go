@@ -11,12 +11,23 @@ import (
 	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
-func RegisterArtifactory(r *Registry) {
-	Register(r, "artifactory", "Caches artifacts from an Artifactory server.", NewArtifactory)
+func RegisterArtifactory(r *Registry, transportProvider httpclient.TransportProvider) {
+	Register(r, "artifactory", "Caches artifacts from an Artifactory server.", func(ctx context.Context, config ArtifactoryConfig, c cache.Cache, mux Mux) (*Artifactory, error) {
+		a, err := NewArtifactory(ctx, config, c, mux)
+		if err != nil {
+			return nil, err
+		}
+		if err := setUpstreamTransport(a, transportProvider, config.UpstreamClient); err != nil {
+			return nil, err
+		}
+		return a, nil
+	})
 }
 
 // ArtifactoryConfig represents the configuration for the Artifactory strategy.
@@ -24,15 +35,24 @@ func RegisterArtifactory(r *Registry) {
 // In HCL it looks something like this:
 //
 //	artifactory "https://example.jfrog.io" {
-//	  hosts = ["maven.example.com", "npm.example.com"]
+//	  hosts   = ["maven.example.com", "npm.example.com"]
+//	  mirrors = ["https://artifactory-eu.example.com"]
 //	}
 //
 // When hosts are configured, the strategy supports both host-based routing
 // (clients connect to maven.example.com) and path-based routing
 // (clients connect to /example.jfrog.io). Both modes share the same cache.
+// Requests fail over to the mirrors, in order, when the target is unhealthy.
 type ArtifactoryConfig struct {
-	Target string   `hcl:"target,label" help:"The target Artifactory URL to proxy requests to."`
-	Hosts  []string `hcl:"hosts,optional" help:"List of hostnames to accept for host-based routing. If empty, uses path-based routing only."`
+	Target         string                 `hcl:"target,label" help:"The target Artifactory URL to proxy requests to."`
+	Hosts          []string               `hcl:"hosts,optional" help:"List of hostnames to accept for host-based routing. If empty, uses path-based routing only."`
+	Grace          handler.GraceConfig    `hcl:",embed"`
+	Lease          handler.LeaseConfig    `hcl:",embed"`
+	Negative       handler.NegativeConfig `hcl:",embed"`
+	Rules          handler.RulesConfig    `hcl:",embed"`
+	Slices         handler.SliceConfig    `hcl:",embed"`
+	Upstreams      UpstreamsConfig        `hcl:",embed"`
+	UpstreamClient *httpclient.Config     `hcl:"upstream,block,optional" help:"HTTP client settings for upstream requests, overriding the global upstream block."`
 }
 
 // The Artifactory [Strategy] forwards all GET requests to the specified Artifactory instance,
@@ -49,37 +69,57 @@ type Artifactory struct {
 	logger       *slog.Logger
 	prefix       string   // For path-based routing
 	allowedHosts []string // For host-based routing
+	handler      *handler.Handler
+	lease        handler.LeaseConfig
+	upstreams    *upstreamPool
 }
 
-var _ Strategy = (*Artifactory)(nil)
+var (
+	_ Strategy         = (*Artifactory)(nil)
+	_ MetadataConsumer = (*Artifactory)(nil)
+)
 
 func NewArtifactory(ctx context.Context, config ArtifactoryConfig, cache cache.Cache, mux Mux) (*Artifactory, error) {
 	u, err := url.Parse(config.Target)
 	if err != nil {
 		return nil, errors.Errorf("invalid target URL: %w", err)
 	}
+	upstreams, err := newUpstreamPool(ctx, u, config.Upstreams)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := handler.NewRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
 
 	a := &Artifactory{
-		target: u,
-		cache:  cache,
-		client: &http.Client{},
-		logger: logging.FromContext(ctx),
+		target:    u,
+		cache:     cache,
+		client:    &http.Client{Transport: upstreams},
+		logger:    logging.FromContext(ctx),
+		lease:     config.Lease,
+		upstreams: upstreams,
 	}
 
-	hdlr := handler.New(a.client, cache).
+	a.handler = handler.New(a.client, cache).
 		CacheKey(func(r *http.Request) string {
 			return a.buildTargetURL(r).String()
 		}).
 		Transform(func(r *http.Request) (*http.Request, error) {
 			return a.transformRequest(r)
-		})
+		}).
+		Grace(config.Grace).
+		Negative(config.Negative).
+		Rules(rules).
+		Slices(config.Slices)
 
 	// Register path-based route (for backward compatibility)
-	a.registerPathBased(ctx, u, hdlr, mux)
+	a.registerPathBased(ctx, u, a.handler, mux)
 
 	// Register host-based routes if configured
 	if len(config.Hosts) > 0 {
-		a.registerHostBased(ctx, config.Hosts, hdlr, mux)
+		a.registerHostBased(ctx, config.Hosts, a.handler, mux)
 	}
 
 	return a, nil
@@ -108,6 +148,15 @@ func (a *Artifactory) registerHostBased(ctx context.Context, hosts []string, hdl
 
 func (a *Artifactory) String() string { return "artifactory:" + a.target.Host + a.target.Path }
 
+// SetHTTPTransport overrides the HTTP transport used for upstream requests.
+func (a *Artifactory) SetHTTPTransport(t http.RoundTripper) {
+	a.upstreams.setTransport(t)
+}
+
+func (a *Artifactory) SetMetadataStore(store *metadatadb.Store) {
+	setFillLeases(a.handler, store, a.lease)
+}
+
 // transformRequest transforms the incoming request before sending to upstream Artifactory.
 func (a *Artifactory) transformRequest(r *http.Request) (*http.Request, error) {
 	targetURL := a.buildTargetURL(r)
@@ -0,0 +1,19 @@
+package strategy
+
+import (
+	"github.com/block/cachew/internal/metadatadb"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+// fillLeaseNamespace is the metadata namespace holding the fill leases shared
+// by every strategy that caches through handler.Handler, as they share keys.
+const fillLeaseNamespace = "http"
+
+// setFillLeases enables cross-replica miss coalescing on h once the metadata
+// store is available.
+func setFillLeases(h *handler.Handler, store *metadatadb.Store, config handler.LeaseConfig) {
+	if store == nil {
+		return
+	}
+	h.FillLeases(handler.NewFillLeases(store.Namespace(fillLeaseNamespace)), config.FillLeaseWait)
+}
@@ -0,0 +1,260 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+)
+
+// AdminReposPath is the prefix of the git mirror admin API.
+//
+//	GET    /admin/git/repos                         list mirrors
+//	GET    /admin/git/repos/{host}/{repo}           inspect one mirror
+//	POST   /admin/git/repos/{host}/{repo}/{action}  submit a job (see AdminActions)
+//	DELETE /admin/git/repos/{host}/{repo}           delete the mirror and its snapshots
+const AdminReposPath = "/admin/git/repos"
+
+// AdminActions lists the job types that can be submitted through
+// POST /admin/git/repos/{host}/{repo}/{action}.
+//
+//nolint:gochecknoglobals
+var AdminActions = []string{"fetch", "repack", "full-repack", snapshotJobBase, snapshotJobMirror, snapshotJobLFS}
+
+// RepoStatus describes one mirror as reported by the admin API.
+type RepoStatus struct {
+	Upstream  string                    `json:"upstream"`
+	Path      string                    `json:"path"`
+	State     string                    `json:"state"`
+	Owner     string                    `json:"owner,omitempty"`
+	LastFetch time.Time                 `json:"last_fetch,omitzero"`
+	SizeBytes int64                     `json:"size_bytes"`
+	PackCount int                       `json:"pack_count"`
+	Snapshots map[string]SnapshotStatus `json:"snapshots,omitempty"`
+}
+
+// SnapshotStatus describes one cached snapshot artifact of a mirror. Commit
+// is only known for artifacts that record it (the workstation snapshot).
+type SnapshotStatus struct {
+	LastModified time.Time `json:"last_modified,omitzero"`
+	AgeSeconds   int64     `json:"age_seconds"`
+	SizeBytes    int64     `json:"size_bytes"`
+	Commit       string    `json:"commit,omitempty"`
+}
+
+// AdminActionResponse is the JSON body returned when an admin action is accepted.
+type AdminActionResponse struct {
+	Upstream string `json:"upstream"`
+	Action   string `json:"action"`
+}
+
+func (s *Strategy) registerAdminHandlers(mux strategy.Mux) {
+	mux.Handle("GET "+AdminReposPath, http.HandlerFunc(s.handleAdminListRepos))
+	mux.Handle("GET "+AdminReposPath+"/{repo...}", http.HandlerFunc(s.handleAdminGetRepo))
+	mux.Handle("POST "+AdminReposPath+"/{repo...}", http.HandlerFunc(s.handleAdminRepoAction))
+	mux.Handle("DELETE "+AdminReposPath+"/{repo...}", http.HandlerFunc(s.handleAdminDeleteRepo))
+}
+
+func (s *Strategy) handleAdminListRepos(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	repos := s.cloneManager.List()
+	out := make([]RepoStatus, 0, len(repos))
+	for _, repo := range repos {
+		out = append(out, s.repoStatus(ctx, repo))
+	}
+	writeAdminJSON(ctx, w, http.StatusOK, out)
+}
+
+func (s *Strategy) handleAdminGetRepo(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.adminLookupRepo(w, r.PathValue("repo"))
+	if !ok {
+		return
+	}
+	writeAdminJSON(r.Context(), w, http.StatusOK, s.repoStatus(r.Context(), repo))
+}
+
+func (s *Strategy) handleAdminRepoAction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	repoPath, action, _ := cutLast(r.PathValue("repo"), "/")
+	submit, ok := s.adminActionJob(action)
+	if !ok {
+		http.Error(w, "unknown action "+strconv.Quote(action)+", expected one of "+strings.Join(AdminActions, ", "), http.StatusNotFound)
+		return
+	}
+	repo, ok := s.adminLookupRepo(w, repoPath)
+	if !ok {
+		return
+	}
+	if repo.State() != gitclone.StateReady {
+		http.Error(w, "mirror is "+repo.State().String(), http.StatusConflict)
+		return
+	}
+	submit(repo)
+	logging.FromContext(ctx).InfoContext(ctx, "Admin action submitted", "upstream", repo.UpstreamURL(), "action", action)
+	writeAdminJSON(ctx, w, http.StatusAccepted, AdminActionResponse{Upstream: repo.UpstreamURL(), Action: action})
+}
+
+// adminActionJob returns a function that submits the named action for a
+// repository. Actions bypass cross-replica snapshot coordination: an operator
+// asking for a snapshot wants it generated here and now.
+func (s *Strategy) adminActionJob(action string) (func(repo *gitclone.Repository), bool) {
+	upload := func(generate func(ctx context.Context, repo *gitclone.Repository) (string, error)) func(repo *gitclone.Repository) {
+		return func(repo *gitclone.Repository) {
+			s.scheduler.Submit(repo.UpstreamURL(), action, func(ctx context.Context) error {
+				_, err := generate(ctx, repo)
+				return err
+			})
+		}
+	}
+	switch action {
+	case "fetch":
+		return s.submitFetch, true
+	case "repack", "full-repack":
+		mode := repackGeometric
+		if action == "full-repack" {
+			mode = repackFull
+		}
+		return func(repo *gitclone.Repository) {
+			s.scheduler.Submit(repo.UpstreamURL(), action, func(ctx context.Context) error {
+				return s.runRepack(ctx, repo, mode)
+			})
+		}, true
+	case snapshotJobBase:
+		return upload(func(ctx context.Context, repo *gitclone.Repository) (string, error) {
+			if err := s.doFetch(ctx, repo); err != nil {
+				logging.FromContext(ctx).WarnContext(ctx, "Pre-snapshot fetch failed", "upstream", repo.UpstreamURL(), "error", err)
+			}
+			return s.generateAndUploadSnapshot(ctx, repo)
+		}), true
+	case snapshotJobMirror:
+		return upload(s.generateAndUploadMirrorSnapshot), true
+	case snapshotJobLFS:
+		return upload(s.generateAndUploadLFSSnapshot), true
+	default:
+		return nil, false
+	}
+}
+
+func (s *Strategy) handleAdminDeleteRepo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	repo, ok := s.adminLookupRepo(w, r.PathValue("repo"))
+	if !ok {
+		return
+	}
+	if err := s.removeMirror(ctx, repo); err != nil {
+		if errors.Is(err, gitclone.ErrCloneInProgress) {
+			http.Error(w, "mirror is cloning", http.StatusConflict)
+			return
+		}
+		logger.ErrorContext(ctx, "Failed to delete mirror", "upstream", repo.UpstreamURL(), "error", err)
+		http.Error(w, "failed to delete mirror: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, key := range []cache.Key{
+		snapshotCacheKey(repo.UpstreamURL()),
+		mirrorSnapshotCacheKey(repo.UpstreamURL()),
+		lfsSnapshotCacheKey(repo.UpstreamURL()),
+	} {
+		if err := s.cache.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.ErrorContext(ctx, "Failed to delete cached snapshot", "upstream", repo.UpstreamURL(), "error", err)
+			http.Error(w, "failed to delete cached snapshot: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	logger.InfoContext(ctx, "Mirror deleted via admin API", "upstream", repo.UpstreamURL())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeMirror deletes a mirror from disk, cancels its scheduled jobs and
+// cleans up its spools. The repository is left in StateEmpty so the next
+// request re-clones it transparently.
+func (s *Strategy) removeMirror(ctx context.Context, repo *gitclone.Repository) error {
+	upstream := repo.UpstreamURL()
+	if err := repo.Remove(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	s.scheduler.CancelQueue(upstream)
+	s.scheduler.CancelQueue(upstream + "/fetch")
+	s.deferredRestoreOnce.Delete(upstream)
+	return errors.Wrapf(s.cleanupSpools(upstream), "clean up spools for %s", upstream)
+}
+
+// adminLookupRepo resolves a "{host}/{repo}" admin path to a known mirror,
+// writing a 404 if the manager has never seen it.
+func (s *Strategy) adminLookupRepo(w http.ResponseWriter, repoPath string) (*gitclone.Repository, bool) {
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if !strings.Contains(repoPath, "/") {
+		http.Error(w, "expected {host}/{repo}", http.StatusBadRequest)
+		return nil, false
+	}
+	host, path, _ := strings.Cut(repoPath, "/")
+	repo := s.cloneManager.Get(s.cloneManager.UpstreamURL(host, path))
+	if repo == nil {
+		http.Error(w, "mirror not found", http.StatusNotFound)
+		return nil, false
+	}
+	return repo, true
+}
+
+func (s *Strategy) repoStatus(ctx context.Context, repo *gitclone.Repository) RepoStatus {
+	status := RepoStatus{
+		Upstream:  repo.UpstreamURL(),
+		Path:      repo.Path(),
+		State:     repo.State().String(),
+		LastFetch: repo.LastFetch(),
+		Owner:     s.ownership.Owner(repo.UpstreamURL()),
+	}
+	if status.State == gitclone.StateReady.String() {
+		status.SizeBytes, _ = dirSizeBytes(repo.Path())   //nolint:errcheck // best-effort
+		status.PackCount, _ = countPackFiles(repo.Path()) //nolint:errcheck // best-effort
+	}
+	now := time.Now()
+	for job, key := range map[string]cache.Key{
+		snapshotJobBase:   snapshotCacheKey(repo.UpstreamURL()),
+		snapshotJobMirror: mirrorSnapshotCacheKey(repo.UpstreamURL()),
+		snapshotJobLFS:    lfsSnapshotCacheKey(repo.UpstreamURL()),
+	} {
+		headers, err := s.cache.Stat(ctx, key)
+		if err != nil {
+			continue
+		}
+		snap := SnapshotStatus{Commit: headers.Get("X-Cachew-Snapshot-Commit")}
+		if lm, err := http.ParseTime(headers.Get("Last-Modified")); err == nil {
+			snap.LastModified = lm
+			snap.AgeSeconds = int64(now.Sub(lm).Seconds())
+		}
+		snap.SizeBytes, _ = strconv.ParseInt(headers.Get("Content-Length"), 10, 64) //nolint:errcheck // best-effort
+		if status.Snapshots == nil {
+			status.Snapshots = make(map[string]SnapshotStatus)
+		}
+		status.Snapshots[job] = snap
+	}
+	return status
+}
+
+func writeAdminJSON(ctx context.Context, w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to encode admin response", "error", err)
+	}
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
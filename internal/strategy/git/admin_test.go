@@ -0,0 +1,112 @@
+package git_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func adminRequest(ctx context.Context, mux *http.ServeMux, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequestWithContext(ctx, method, path, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRepos(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	mirrorRoot := filepath.Join(t.TempDir(), "mirrors")
+	mirrorPath := filepath.Join(mirrorRoot, "github.com", "org", "repo")
+	createTestMirrorRepo(t, mirrorPath)
+
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	upstreamURL := "https://github.com/org/repo"
+	snapshotKey := cache.NewKey(upstreamURL + ".snapshot")
+	assert.NoError(t, cache.WriteFunc(ctx, memCache, snapshotKey, http.Header{"X-Cachew-Snapshot-Commit": {"abc123"}}, time.Hour, func(w io.Writer) error {
+		_, err := w.Write([]byte("snapshot"))
+		return err
+	}))
+
+	mux := http.NewServeMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+	s, err := git.New(ctx, git.Config{}, newTestScheduler(ctx, t), memCache, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	t.Run("List", func(t *testing.T) {
+		w := adminRequest(ctx, mux, http.MethodGet, "/admin/git/repos")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var repos []git.RepoStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &repos))
+		assert.Equal(t, 1, len(repos))
+		assert.Equal(t, upstreamURL, repos[0].Upstream)
+		assert.Equal(t, "ready", repos[0].State)
+		assert.True(t, repos[0].SizeBytes > 0)
+		assert.Equal(t, "abc123", repos[0].Snapshots["snapshot"].Commit)
+		assert.Equal(t, int64(len("snapshot")), repos[0].Snapshots["snapshot"].SizeBytes)
+	})
+
+	t.Run("GetUnknown", func(t *testing.T) {
+		w := adminRequest(ctx, mux, http.MethodGet, "/admin/git/repos/github.com/org/missing")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		w := adminRequest(ctx, mux, http.MethodGet, "/admin/git/repos/github.com/org/repo.git")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var repo git.RepoStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &repo))
+		assert.Equal(t, upstreamURL, repo.Upstream)
+	})
+
+	t.Run("UnknownAction", func(t *testing.T) {
+		w := adminRequest(ctx, mux, http.MethodPost, "/admin/git/repos/github.com/org/repo/explode")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Repack", func(t *testing.T) {
+		w := adminRequest(ctx, mux, http.MethodPost, "/admin/git/repos/github.com/org/repo/repack")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var resp git.AdminActionResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, git.AdminActionResponse{Upstream: upstreamURL, Action: "repack"}, resp)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		w := adminRequest(ctx, mux, http.MethodDelete, "/admin/git/repos/github.com/org/repo")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		_, err := os.Stat(mirrorPath)
+		assert.True(t, os.IsNotExist(err), "mirror directory should be removed")
+		_, err = memCache.Stat(ctx, snapshotKey)
+		assert.IsError(t, err, os.ErrNotExist)
+
+		w = adminRequest(ctx, mux, http.MethodGet, "/admin/git/repos/github.com/org/repo")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var repo git.RepoStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &repo))
+		assert.Equal(t, "empty", repo.State)
+
+		w = adminRequest(ctx, mux, http.MethodPost, "/admin/git/repos/github.com/org/repo/fetch")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
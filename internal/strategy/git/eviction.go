@@ -0,0 +1,147 @@
+package git
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+const (
+	defaultMirrorEvictInterval = time.Hour
+	// defaultMirrorUsageWindowDays is the clone-count window used to rank
+	// mirrors for size-based eviction when mirror-idle-days is unset.
+	defaultMirrorUsageWindowDays = 7
+)
+
+// mirrorUsage is one eviction candidate: a ready mirror with its on-disk
+// size, clone count over the usage window and last local access.
+type mirrorUsage struct {
+	upstream   string
+	sizeBytes  int64
+	clones     int64
+	lastAccess time.Time
+}
+
+// mirrorEviction is a mirror selected for eviction and why.
+type mirrorEviction struct {
+	mirrorUsage
+	reason string // "idle" or "size"
+}
+
+func (s *Strategy) mirrorEvictionEnabled() bool {
+	return s.config.MirrorMaxSizeMB > 0 || s.config.MirrorIdleDays > 0
+}
+
+func (s *Strategy) scheduleMirrorEviction() {
+	if !s.mirrorEvictionEnabled() {
+		return
+	}
+	s.scheduler.SubmitPeriodicJob("mirror-eviction", "evict-mirrors", s.config.MirrorEvictInterval, s.evictMirrors)
+}
+
+// evictMirrors applies the mirror eviction policy once. Evicted mirrors are
+// removed from disk and their jobs cancelled; the repository stays known to
+// the clone manager in StateEmpty so the next request re-clones it (restoring
+// from the mirror snapshot if one is still cached). Snapshot cache keys are
+// left to expire on their own TTL.
+func (s *Strategy) evictMirrors(ctx context.Context) error {
+	return s.evictMirrorsAt(ctx, time.Now())
+}
+
+func (s *Strategy) evictMirrorsAt(ctx context.Context, now time.Time) error {
+	logger := logging.FromContext(ctx)
+	window := s.config.MirrorIdleDays
+	if window <= 0 {
+		window = defaultMirrorUsageWindowDays
+	}
+	clones := make(map[string]int64)
+	for _, rc := range s.repoCounts.TopRepos(window, 0) {
+		clones[rc.Repo] = rc.Count
+	}
+
+	repos := make(map[string]*gitclone.Repository)
+	var usage []mirrorUsage
+	for _, repo := range s.cloneManager.List() {
+		if repo.State() != gitclone.StateReady {
+			continue
+		}
+		size, err := dirSizeBytes(repo.Path())
+		if err != nil {
+			logger.WarnContext(ctx, "Failed to size mirror for eviction", "upstream", repo.UpstreamURL(), "error", err)
+			continue
+		}
+		repos[repo.UpstreamURL()] = repo
+		usage = append(usage, mirrorUsage{
+			upstream:   repo.UpstreamURL(),
+			sizeBytes:  size,
+			clones:     clones[repo.UpstreamURL()],
+			lastAccess: repo.LastAccess(),
+		})
+	}
+
+	evictions := selectMirrorEvictions(usage, now, int64(s.config.MirrorMaxSizeMB)<<20, s.config.MirrorIdleDays, s.config.MirrorEvictInterval)
+	var errs []error
+	for _, ev := range evictions {
+		start := time.Now()
+		if err := s.removeMirror(ctx, repos[ev.upstream]); err != nil {
+			if errors.Is(err, gitclone.ErrCloneInProgress) {
+				continue
+			}
+			s.metrics.recordOperation(ctx, "evict", "error", time.Since(start))
+			errs = append(errs, errors.Wrapf(err, "evict %s", ev.upstream))
+			continue
+		}
+		s.metrics.recordOperation(ctx, "evict", "success", time.Since(start))
+		logger.InfoContext(ctx, "Evicted mirror", "upstream", ev.upstream, "reason", ev.reason,
+			"size_bytes", ev.sizeBytes, "clones", ev.clones, "last_access", ev.lastAccess)
+	}
+	return errors.Join(errs...)
+}
+
+// selectMirrorEvictions returns the mirrors that fall outside the policy.
+//
+// Mirrors not accessed for idleDays are always evicted. If the remaining
+// mirrors still exceed maxBytes, the least-cloned ones are evicted next, ties
+// broken by least-recent access, until the total fits. Mirrors accessed
+// within the last eviction interval are exempt from size-based eviction so a
+// single hot mirror larger than the budget isn't torn down and re-cloned on
+// every pass. Zero maxBytes or idleDays disables that half of the policy.
+func selectMirrorEvictions(usage []mirrorUsage, now time.Time, maxBytes int64, idleDays int, interval time.Duration) []mirrorEviction {
+	var evictions []mirrorEviction
+	var kept []mirrorUsage
+	var total int64
+	for _, u := range usage {
+		if idleDays > 0 && now.Sub(u.lastAccess) >= time.Duration(idleDays)*24*time.Hour {
+			evictions = append(evictions, mirrorEviction{mirrorUsage: u, reason: "idle"})
+			continue
+		}
+		kept = append(kept, u)
+		total += u.sizeBytes
+	}
+	if maxBytes <= 0 || total <= maxBytes {
+		return evictions
+	}
+	slices.SortStableFunc(kept, func(a, b mirrorUsage) int {
+		if c := cmp.Compare(a.clones, b.clones); c != 0 {
+			return c
+		}
+		return a.lastAccess.Compare(b.lastAccess)
+	})
+	for _, u := range kept {
+		if total <= maxBytes {
+			break
+		}
+		if now.Sub(u.lastAccess) < interval {
+			continue
+		}
+		evictions = append(evictions, mirrorEviction{mirrorUsage: u, reason: "size"})
+		total -= u.sizeBytes
+	}
+	return evictions
+}
@@ -0,0 +1,98 @@
+package git_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestSelectMirrorEvictions(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	usage := []git.MirrorUsage{
+		{Upstream: "hot", SizeBytes: 400, Clones: 50, LastAccess: now.Add(-2 * day)},
+		{Upstream: "warm", SizeBytes: 300, Clones: 5, LastAccess: now.Add(-2 * day)},
+		{Upstream: "cold-recent", SizeBytes: 300, Clones: 0, LastAccess: now.Add(-1 * day)},
+		{Upstream: "cold-old", SizeBytes: 300, Clones: 0, LastAccess: now.Add(-3 * day)},
+		{Upstream: "idle", SizeBytes: 100, Clones: 0, LastAccess: now.Add(-30 * day)},
+		{Upstream: "just-used", SizeBytes: 500, Clones: 0, LastAccess: now.Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		idleDays int
+		expected []string
+	}{
+		{name: "Disabled", expected: nil},
+		{name: "IdleOnly", idleDays: 14, expected: []string{"idle:idle"}},
+		{name: "UnderBudget", maxBytes: 2000, idleDays: 14, expected: []string{"idle:idle"}},
+		{
+			name:     "SizeEvictsLeastClonedThenOldest",
+			maxBytes: 1000,
+			expected: []string{"idle:size", "cold-old:size", "cold-recent:size", "warm:size"},
+		},
+		{
+			name:     "IdleCountsTowardsBudget",
+			maxBytes: 1500,
+			idleDays: 14,
+			expected: []string{"idle:idle", "cold-old:size"},
+		},
+		{
+			name:     "RecentlyUsedExempt",
+			maxBytes: 100,
+			expected: []string{"idle:size", "cold-old:size", "cold-recent:size", "warm:size", "hot:size"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := git.SelectMirrorEvictions(usage, now, tt.maxBytes, tt.idleDays, time.Hour)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestEvictIdleMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	mirrorRoot := filepath.Join(t.TempDir(), "mirrors")
+	mirrorPath := filepath.Join(mirrorRoot, "github.com", "org", "repo")
+	createTestMirrorRepo(t, mirrorPath)
+
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+	s, err := git.New(ctx, git.Config{MirrorIdleDays: 7}, newTestScheduler(ctx, t), nil, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	manager, err := cm()
+	assert.NoError(t, err)
+	repo := manager.Get("https://github.com/org/repo")
+	assert.NotZero(t, repo)
+
+	// Freshly discovered mirrors get a full grace period.
+	assert.NoError(t, s.EvictMirrorsAt(ctx, time.Now()))
+	assert.Equal(t, gitclone.StateReady, repo.State())
+
+	assert.NoError(t, s.EvictMirrorsAt(ctx, time.Now().Add(8*24*time.Hour)))
+	assert.Equal(t, gitclone.StateEmpty, repo.State())
+	_, err = os.Stat(mirrorPath)
+	assert.True(t, os.IsNotExist(err), "mirror directory should be removed")
+
+	// The repository stays known so the next request re-clones it.
+	again, err := manager.GetOrCreate(ctx, "https://github.com/org/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, repo, again)
+}
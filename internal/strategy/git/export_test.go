@@ -34,3 +34,70 @@ func (s *Strategy) RunCoordinatedSnapshot(ctx context.Context, repo *gitclone.Re
 func (s *Strategy) CacheBundle(ctx context.Context, key cache.Key, r io.Reader) error {
 	return s.cacheBundle(ctx, key, r)
 }
+
+// EvictMirrorsAt runs one mirror eviction pass as if the current time were now.
+func (s *Strategy) EvictMirrorsAt(ctx context.Context, now time.Time) error {
+	return s.evictMirrorsAt(ctx, now)
+}
+
+// MirrorUsage is an eviction candidate for SelectMirrorEvictions.
+type MirrorUsage struct {
+	Upstream   string
+	SizeBytes  int64
+	Clones     int64
+	LastAccess time.Time
+}
+
+// SelectMirrorEvictions exports selectMirrorEvictions for testing, returning
+// "upstream:reason" for each selected mirror.
+func SelectMirrorEvictions(usage []MirrorUsage, now time.Time, maxBytes int64, idleDays int, interval time.Duration) []string {
+	in := make([]mirrorUsage, 0, len(usage))
+	for _, u := range usage {
+		in = append(in, mirrorUsage{upstream: u.Upstream, sizeBytes: u.SizeBytes, clones: u.Clones, lastAccess: u.LastAccess})
+	}
+	var out []string
+	for _, ev := range selectMirrorEvictions(in, now, maxBytes, idleDays, interval) {
+		out = append(out, ev.upstream+":"+ev.reason)
+	}
+	return out
+}
+
+// OwnerOf returns the replica that s currently considers the owner of upstreamURL.
+func OwnerOf(s *Strategy, upstreamURL string) string {
+	return s.ownership.Owner(upstreamURL)
+}
+
+// TryCloneFromPeer exports tryCloneFromPeer for testing.
+func (s *Strategy) TryCloneFromPeer(ctx context.Context, repo *gitclone.Repository) error {
+	return s.tryCloneFromPeer(ctx, repo)
+}
+
+// RunScrub exports runScrub for testing.
+func (s *Strategy) RunScrub(ctx context.Context, repo *gitclone.Repository) error {
+	return s.runScrub(ctx, repo)
+}
+
+// RepoSettings mirrors repoSettings for testing.
+type RepoSettings struct {
+	SnapshotInterval       time.Duration
+	MirrorSnapshotInterval time.Duration
+	LFSSnapshot            bool
+	RepackInterval         time.Duration
+	RepackAfterFetch       bool
+	FullRepackInterval     time.Duration
+	RefCheckInterval       time.Duration
+}
+
+// SettingsFor exports Config.settingsFor for testing.
+func SettingsFor(config Config, upstreamURL string) RepoSettings {
+	settings := config.settingsFor(upstreamURL)
+	return RepoSettings{
+		SnapshotInterval:       settings.snapshotInterval,
+		MirrorSnapshotInterval: settings.mirrorSnapshotInterval,
+		LFSSnapshot:            settings.lfsSnapshot,
+		RepackInterval:         settings.repackInterval,
+		RepackAfterFetch:       settings.repackAfterFetch,
+		FullRepackInterval:     settings.fullRepackInterval,
+		RefCheckInterval:       settings.refCheckInterval,
+	}
+}
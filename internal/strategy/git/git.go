@@ -10,6 +10,7 @@ import (
 	"maps"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -27,6 +28,7 @@ import (
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/gitclone"
 	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/jobscheduler"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/metadatadb"
@@ -37,42 +39,65 @@ import (
 //nolint:gochecknoglobals // OTel tracer instances are package-scoped by convention
 var tracer = otel.Tracer("github.com/block/cachew/internal/strategy/git")
 
-func Register(r *strategy.Registry, scheduler jobscheduler.Provider, cloneManagerProvider gitclone.ManagerProvider, tokenManagerProvider githubapp.TokenManagerProvider) {
+func Register(r *strategy.Registry, scheduler jobscheduler.Provider, cloneManagerProvider gitclone.ManagerProvider, tokenManagerProvider githubapp.TokenManagerProvider, transportProvider httpclient.TransportProvider) {
 	strategy.Register(r, "git", "Caches Git repositories, including tarball snapshots.", func(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux) (*Strategy, error) {
-		return New(ctx, config, scheduler, cache, mux, cloneManagerProvider, tokenManagerProvider)
+		return New(ctx, config, scheduler, cache, mux, cloneManagerProvider, tokenManagerProvider, transportProvider)
 	})
 }
 
 type Config struct {
-	SnapshotInterval       time.Duration `hcl:"snapshot-interval,optional" help:"How often to generate tar.zstd workstation snapshots. 0 disables snapshots." default:"0"`
-	SnapshotMaxAge         time.Duration `hcl:"snapshot-max-age,optional" help:"How long an unchanged snapshot (same HEAD commit) may be served before regeneration. Requires shared metadata; keep well below the cache max-ttl. 0 regenerates every interval." default:"24h"`
-	MirrorSnapshotInterval time.Duration `hcl:"mirror-snapshot-interval,optional" help:"How often to generate mirror snapshots for pod bootstrap. 0 uses snapshot-interval. Defaults to 2h." default:"2h"`
-	RepackInterval         time.Duration `hcl:"repack-interval,optional" help:"How often to run full repack. 0 disables." default:"0"`
-	ZstdThreads            int           `hcl:"zstd-threads,optional" help:"Threads for zstd compression/decompression. 0 = all CPU cores; useful for short-lived CLI invocations but risky on a long-running server where multiple snapshot/restore operations can run concurrently." default:"4"`
-	BundleCacheTTL         time.Duration `hcl:"bundle-cache-ttl,optional" help:"TTL of cached server-side git bundles." default:"2h"`
+	SnapshotInterval         time.Duration      `hcl:"snapshot-interval,optional" help:"How often to generate tar.zstd workstation snapshots. 0 disables snapshots." default:"0"`
+	SnapshotMaxAge           time.Duration      `hcl:"snapshot-max-age,optional" help:"How long an unchanged snapshot (same HEAD commit) may be served before regeneration. Requires shared metadata; keep well below the cache max-ttl. 0 regenerates every interval." default:"24h"`
+	MirrorSnapshotInterval   time.Duration      `hcl:"mirror-snapshot-interval,optional" help:"How often to generate mirror snapshots for pod bootstrap. 0 uses snapshot-interval. Defaults to 2h." default:"2h"`
+	RepackInterval           time.Duration      `hcl:"repack-interval,optional" help:"How often to run a geometric repack. 0 disables." default:"0"`
+	RepackAfterFetch         bool               `hcl:"repack-after-fetch,optional" help:"Run a geometric repack after each fetch, keeping pack count low without periodic full repacks." default:"false"`
+	FullRepackInterval       time.Duration      `hcl:"full-repack-interval,optional" help:"How often to rewrite each mirror into a single pack. Expensive on large mirrors; only needed as a rare fallback to geometric repacks. 0 disables." default:"0"`
+	ScrubInterval            time.Duration      `hcl:"scrub-interval,optional" help:"How often to verify mirror integrity (fsck connectivity, commit-graph and multi-pack-index). Corrupt mirrors are discarded and re-cloned. 0 disables." default:"0"`
+	ZstdThreads              int                `hcl:"zstd-threads,optional" help:"Threads for zstd compression/decompression. 0 = all CPU cores; useful for short-lived CLI invocations but risky on a long-running server where multiple snapshot/restore operations can run concurrently." default:"4"`
+	BundleCacheTTL           time.Duration      `hcl:"bundle-cache-ttl,optional" help:"TTL of cached server-side git bundles." default:"2h"`
+	UploadPackCacheTTL       time.Duration      `hcl:"upload-pack-cache-ttl,optional" help:"TTL of cached upload-pack responses, reused by fetches with identical negotiations until the mirror's refs change. 0 disables." default:"0"`
+	UploadPackCacheMaxSizeMB int                `hcl:"upload-pack-cache-max-size-mb,optional" help:"Largest upload-pack response to cache, in megabytes. 0 is unlimited." default:"2048"`
+	MirrorMaxSizeMB          int                `hcl:"mirror-max-size-mb,optional" help:"Maximum total size of all mirrors in megabytes. When exceeded, the least-cloned, least-recently-accessed mirrors are evicted. 0 disables." default:"0"`
+	MirrorIdleDays           int                `hcl:"mirror-idle-days,optional" help:"Evict mirrors that haven't been accessed for this many days. 0 disables." default:"0"`
+	MirrorEvictInterval      time.Duration      `hcl:"mirror-evict-interval,optional" help:"How often to apply the mirror eviction policy." default:"1h"`
+	ReplicaAuthToken         string             `hcl:"replica-auth-token,optional" help:"Shared secret replicas present when cloning each other's mirrors. With ownership peers configured, new mirrors are cloned from a peer that has one before falling back to upstream. Empty disables peer bootstrap." default:""`
+	WarmRepos                []string           `hcl:"warm-repos,optional" help:"Repositories to clone, or restore from a mirror snapshot, at startup and keep cloned, e.g. \"github.com/org/repo\"."`
+	CriticalRepos            []string           `hcl:"critical-repos,optional" help:"Warm repositories that must be cloned before the strategy reports ready."`
+	WarmManifest             string             `hcl:"warm-manifest,optional" help:"URL of a manifest of further repositories to keep warm, one per line, each optionally followed by \"critical\"." default:""`
+	WarmInterval             time.Duration      `hcl:"warm-interval,optional" help:"How often to reload the warm repository list and clone any repositories that are missing." default:"15m"`
+	WebhookSecret            string             `hcl:"webhook-secret,optional" help:"Secret for verifying GitHub webhook signatures. Enables the push webhook receiver at /git/webhook/github." default:""`
+	WebhookSnapshot          bool               `hcl:"webhook-snapshot,optional" help:"Regenerate a repository's snapshot when a push webhook reports that its default branch moved." default:"false"`
+	Ownership                OwnershipConfig    `hcl:"ownership,block,optional"`
+	Repos                    []RepoConfig       `hcl:"repo,block,optional" help:"Per-repository overrides, matched against the upstream URL."`
+	UpstreamClient           *httpclient.Config `hcl:"upstream,block,optional" help:"HTTP client settings for proxied upstream requests, overriding the global upstream block."`
 }
 
 type Strategy struct {
-	config              Config
-	cache               cache.Cache
-	cloneManager        *gitclone.Manager
-	httpClient          *http.Client
-	proxy               *httputil.ReverseProxy
-	ctx                 context.Context
-	scheduler           jobscheduler.Scheduler
-	spoolsMu            sync.Mutex
-	spools              map[string]*RepoSpools
-	tokenManager        *githubapp.TokenManager
-	snapshotMu          sync.Map // keyed by upstream URL, values are *sync.Mutex
-	snapshotSpools      sync.Map // keyed by upstream URL, values are *snapshotSpoolEntry
-	coldSnapshotMu      sync.Map // keyed by upstream URL, values are *coldSnapshotEntry
-	deferredRestoreOnce sync.Map // keyed by upstream URL, ensures at most one deferred restore per repo
-	metrics             *gitMetrics
-	repoCounts          *RepoCounts
-	snapshotCoord       *SnapshotCoordinator
-	metadataWired       chan struct{} // closed by SetMetadataStore; gates warm-up
-	wiredOnce           sync.Once
-	ready               atomic.Bool
+	config                 Config
+	cache                  cache.Cache
+	cloneManager           *gitclone.Manager
+	httpClient             *http.Client
+	proxy                  *httputil.ReverseProxy
+	ctx                    context.Context
+	scheduler              jobscheduler.Scheduler
+	spoolsMu               sync.Mutex
+	spools                 map[string]*RepoSpools
+	tokenManager           *githubapp.TokenManager
+	snapshotMu             sync.Map // keyed by upstream URL, values are *sync.Mutex
+	snapshotSpools         sync.Map // keyed by upstream URL, values are *snapshotSpoolEntry
+	coldSnapshotMu         sync.Map // keyed by upstream URL, values are *coldSnapshotEntry
+	deferredRestoreOnce    sync.Map // keyed by upstream URL, ensures at most one deferred restore per repo
+	repackAfterFetchQueued sync.Map // keyed by upstream URL, ensures at most one queued post-fetch repack per repo
+	pushFetchQueued        sync.Map // keyed by upstream URL, ensures at most one queued post-push fetch per repo
+	refsFingerprints       sync.Map // keyed by upstream URL, values are refsFingerprint
+	metrics                *gitMetrics
+	repoCounts             *RepoCounts
+	snapshotCoord          *SnapshotCoordinator
+	ownership              *Ownership
+	metadataWired          chan struct{} // closed by SetMetadataStore; gates warm-up
+	wiredOnce              sync.Once
+	ready                  atomic.Bool
+	warmList               atomic.Pointer[[]warmRepo] // nil until the warm list first loads
 }
 
 func New(
@@ -83,6 +108,7 @@ func New(
 	mux strategy.Mux,
 	cloneManagerProvider gitclone.ManagerProvider,
 	tokenManagerProvider githubapp.TokenManagerProvider,
+	transportProvider httpclient.TransportProvider,
 ) (*Strategy, error) {
 	if _, err := exec.LookPath("git"); err != nil {
 		return nil, errors.New("git is required but not found in PATH")
@@ -90,7 +116,20 @@ func New(
 	if config.BundleCacheTTL == 0 {
 		config.BundleCacheTTL = 2 * time.Hour
 	}
-	if config.SnapshotInterval > 0 {
+	if config.MirrorEvictInterval == 0 {
+		config.MirrorEvictInterval = defaultMirrorEvictInterval
+	}
+	if config.WarmInterval == 0 {
+		config.WarmInterval = defaultWarmInterval
+	}
+	if err := validateRepoConfigs(config.Repos); err != nil {
+		return nil, err
+	}
+	transport, err := httpclient.Resolve(transportProvider, config.UpstreamClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "upstream transport")
+	}
+	if config.snapshotsEnabled() {
 		for _, bin := range []string{"tar", "pzstd"} {
 			if _, err := exec.LookPath(bin); err != nil {
 				return nil, errors.Errorf("%s is required for snapshots (snapshot-interval > 0) but not found in PATH", bin)
@@ -119,6 +158,9 @@ func New(
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create clone manager")
 	}
+	if len(config.Repos) > 0 {
+		cloneManager.SetRepoConfigFunc(config.cloneConfigFor)
+	}
 	for _, dir := range []string{".spools", ".snapshots", ".snapshot-spools"} {
 		if err := os.RemoveAll(filepath.Join(cloneManager.Config().MirrorRoot, dir)); err != nil {
 			return nil, errors.Wrapf(err, "clean up stale %s", dir)
@@ -136,22 +178,28 @@ func New(
 		config:        config,
 		cache:         cache,
 		cloneManager:  cloneManager,
-		httpClient:    http.DefaultClient,
+		httpClient:    &http.Client{Transport: transport},
 		ctx:           ctx,
 		scheduler:     scheduler.WithQueuePrefix("git"),
 		spools:        make(map[string]*RepoSpools),
 		tokenManager:  tokenManager,
 		metrics:       m,
+		ownership:     NewOwnership(config.Ownership),
 		metadataWired: make(chan struct{}),
 	}
+	if s.ownership != nil {
+		// Owners are reached through the same transport as upstreams.
+		s.ownership.client.Transport = transport
+	}
 	// Run startup fetches in the background so the HTTP listener (and
 	// /_liveness) come up immediately. /_readiness gates on Ready() so the
-	// Service load balancer holds traffic until warming completes.
+	// Service load balancer holds traffic until warming completes and any
+	// critical warm repositories are cloned.
 	go func() {
 		warmCtx := context.WithoutCancel(ctx)
 		// Coordination only matters when warm-up will schedule snapshot
 		// jobs; with snapshots disabled, warm immediately.
-		if s.config.SnapshotInterval > 0 {
+		if s.config.snapshotsEnabled() {
 			// Wait for SetMetadataStore so warm-up never schedules snapshot
 			// jobs before cross-replica coordination is installed.
 			// config.Load wires every MetadataConsumer immediately after
@@ -174,15 +222,20 @@ func New(
 		if err := s.warmExistingRepos(warmCtx); err != nil {
 			logger.WarnContext(warmCtx, "Failed to warm existing repos", "error", err)
 		}
+		s.scheduleWarmJob(warmCtx)
 		s.ready.Store(true)
 		logger.InfoContext(warmCtx, "Git strategy ready")
 	}()
 
 	s.proxy = &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
-			req.URL.Scheme = "https"
-			req.URL.Host = req.PathValue("host")
-			req.URL.Path = "/" + req.PathValue("path")
+			upstream, err := url.Parse(s.cloneManager.UpstreamURL(req.PathValue("host"), req.PathValue("path")))
+			if err != nil {
+				upstream = &url.URL{Scheme: "https", Host: req.PathValue("host"), Path: "/" + req.PathValue("path")}
+			}
+			req.URL.Scheme = upstream.Scheme
+			req.URL.Host = upstream.Host
+			req.URL.Path = upstream.Path
 			req.Host = req.URL.Host
 
 			// Inject GitHub App authentication for github.com requests
@@ -198,19 +251,34 @@ func New(
 						logger.DebugContext(req.Context(), "Injecting GitHub App auth into upstream request", "org", org)
 					}
 				}
+			} else if credentials := s.cloneManager.CredentialsForURL(req.Context(), upstream.String()); credentials.BearerToken != "" {
+				req.Header.Set("Authorization", "Bearer "+credentials.BearerToken)
+			} else if credentials.Password != "" {
+				req.SetBasicAuth(credentials.Username, credentials.Password)
 			}
 		},
 		Transport: s.httpClient.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.StatusCode >= http.StatusInternalServerError {
+				resp.Header.Set(UpstreamErrorHeader, "1")
+			}
+			return nil
+		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			logging.FromContext(r.Context()).ErrorContext(r.Context(), "Upstream request failed", "error", err)
+			w.Header().Set(UpstreamErrorHeader, "1")
 			w.WriteHeader(http.StatusBadGateway)
 		},
 	}
 
 	mux.Handle("GET /git/{host}/{path...}", http.HandlerFunc(s.handleRequest))
 	mux.Handle("POST /git/{host}/{path...}", http.HandlerFunc(s.handleRequest))
+	s.registerAdminHandlers(mux)
+	s.registerWebhookHandlers(mux)
+	s.scheduleMirrorEviction()
 
-	logger.InfoContext(ctx, "Git strategy initialized", "snapshot_interval", config.SnapshotInterval)
+	logger.InfoContext(ctx, "Git strategy initialized", "snapshot_interval", config.SnapshotInterval,
+		"mirror_max_size_mb", config.MirrorMaxSizeMB, "mirror_idle_days", config.MirrorIdleDays)
 
 	return s, nil
 }
@@ -219,9 +287,10 @@ var _ strategy.Strategy = (*Strategy)(nil)
 var _ strategy.Readier = (*Strategy)(nil)
 var _ strategy.MetadataConsumer = (*Strategy)(nil)
 
-// Ready reports whether startup warm-up has completed.
+// Ready reports whether startup warm-up has completed and every critical
+// warm repository this replica owns is cloned.
 func (s *Strategy) Ready() bool {
-	return s.ready.Load()
+	return s.ready.Load() && s.criticalReposReady()
 }
 
 // SetMetadataStore enables the per-repo clone histogram (and schedules its
@@ -230,10 +299,14 @@ func (s *Strategy) Ready() bool {
 // warm-up goroutine, which waits for wiring so warmed repos are scheduled
 // with coordination in place.
 func (s *Strategy) SetMetadataStore(store *metadatadb.Store) {
-	defer s.wiredOnce.Do(func() { close(s.metadataWired) })
+	defer s.wiredOnce.Do(func() {
+		s.scheduleOwnershipJobs()
+		close(s.metadataWired)
+	})
 	if store == nil {
 		return
 	}
+	s.ownership.discover(store.Namespace("git"))
 	s.snapshotCoord = NewSnapshotCoordinator(store.Namespace("git"))
 	s.repoCounts = NewRepoCounts(store.Namespace("git"))
 	logging.FromContext(s.ctx).InfoContext(s.ctx, "Per-repo clone histogram enabled",
@@ -280,21 +353,23 @@ func (s *Strategy) warmExistingRepos(ctx context.Context) error {
 		maps.DeleteFunc(postRefs, func(k, v string) bool { return preRefs[k] == v })
 		logger.InfoContext(ctx, "Post-fetch changed refs for existing repo", "upstream", repo.UpstreamURL(), "refs", postRefs)
 
-		if s.config.SnapshotInterval > 0 {
-			s.scheduleSnapshotJobs(repo)
-		}
-		if s.config.RepackInterval > 0 {
-			s.scheduleRepackJobs(repo)
+		s.scheduleSnapshotJobs(repo)
+		s.scheduleRepackJobs(repo)
+		if s.config.ScrubInterval > 0 {
+			s.scheduleScrubJobs(repo)
 		}
 	}
 	return nil
 }
 
 // SetHTTPTransport overrides the HTTP transport used for upstream requests.
-// This is intended for testing.
+// It must be called before the strategy serves requests.
 func (s *Strategy) SetHTTPTransport(t http.RoundTripper) {
 	s.httpClient.Transport = t
 	s.proxy.Transport = t
+	if s.ownership != nil {
+		s.ownership.client.Transport = t
+	}
 }
 
 func (s *Strategy) String() string { return "git" }
@@ -360,7 +435,16 @@ func (s *Strategy) handleGitRequest(w http.ResponseWriter, r *http.Request, host
 	logger := logging.FromContext(ctx)
 
 	repoPath := ExtractRepoPath(pathValue)
-	upstreamURL := "https://" + host + "/" + repoPath
+	if r.Header.Get(ReplicaAuthHeader) != "" {
+		// Peers address mirrors by their upstream URL, not a client's host name.
+		s.serveReplicaRequest(w, r, s.cloneManager.MirrorUpstreamURL(host, repoPath))
+		return
+	}
+	upstreamURL := s.cloneManager.UpstreamURL(host, repoPath)
+
+	if s.serveFromOwner(w, r, host, pathValue, upstreamURL) {
+		return
+	}
 
 	repo, err := s.cloneManager.GetOrCreate(ctx, upstreamURL)
 	if err != nil {
@@ -430,7 +514,25 @@ func (s *Strategy) serveReadyRepo(w http.ResponseWriter, r *http.Request, repo *
 		r.TransferEncoding = nil
 	}
 
-	if s.serveFromBackend(w, r, repo) {
+	var cacheWriter *uploadPackCacheWriter
+	if s.uploadPackCacheEnabled() {
+		if key, ok := s.uploadPackNegotiationKey(ctx, r, repo, bodyBytes); ok {
+			if s.serveCachedUploadPack(ctx, w, key, repo.UpstreamURL()) {
+				s.metrics.recordUploadPackCache(ctx, "hit")
+				return nil
+			}
+			s.metrics.recordUploadPackCache(ctx, "miss")
+			cacheWriter = s.newUploadPackCacheWriter(ctx, w, key)
+			w = cacheWriter
+		}
+	}
+
+	fellBack := s.serveFromBackend(w, r, repo)
+	if cacheWriter != nil {
+		cacheWriter.finish(fellBack)
+		w = cacheWriter.ResponseWriter
+	}
+	if fellBack {
 		// The mirror is missing the requested object — most likely a commit
 		// that was advertised before a concurrent force-push fetch orphaned
 		// it. Fall back to upstream so the client is not left with an error.
@@ -623,42 +725,15 @@ func (s *Strategy) startClone(ctx context.Context, repo *gitclone.Repository) (r
 
 	if err := s.tryRestoreSnapshot(ctx, repo); err != nil {
 		logger.InfoContext(ctx, "Mirror snapshot restore failed, falling back to clone", "upstream", upstream, "error", err)
-	} else {
-		logger.InfoContext(ctx, "Mirror snapshot restored, fetching to freshen", "upstream", upstream)
-
-		// Fetch with a generous timeout and no low-speed check: mirror
-		// snapshots can be hours old, so the delta may be very large and
-		// GitHub's server-side pack computation can stall at near-zero
-		// transfer for minutes (same as initial clone).
-		//
-		// State remains StateCloning until fetch succeeds so that
-		// concurrent requests (via ensureCloneReady) block rather than
-		// serving from a potentially empty or stale mirror.
-		if err := repo.FetchLenient(ctx, s.cloneManager.Config().CloneTimeout); err != nil {
-			logger.WarnContext(ctx, "Post-restore fetch failed, discarding snapshot and falling back to clone",
-				"upstream", upstream, "error", err)
-			// The restored snapshot may be corrupt or empty. Remove it and
-			// fall through to a fresh clone so we don't re-upload bad data.
-			repo.ResetToEmpty()
-			if rmErr := os.RemoveAll(repo.Path()); rmErr != nil {
-				return errors.Wrapf(rmErr, "remove corrupt mirror for %s", upstream)
-			}
-		} else {
-			repo.MarkReady()
-
-			if err := s.cleanupSpools(upstream); err != nil {
-				return errors.Wrapf(err, "clean up spools for %s", upstream)
-			}
-
-			logger.InfoContext(ctx, "Post-restore fetch completed, serving", "upstream", upstream)
+	} else if ready, err := s.freshenSeededMirror(ctx, repo, "snapshot"); ready || err != nil {
+		return err
+	}
 
-			if s.config.SnapshotInterval > 0 {
-				s.scheduleSnapshotJobs(repo)
-			}
-			if s.config.RepackInterval > 0 {
-				s.scheduleRepackJobs(repo)
-			}
-			return nil
+	if s.peerBootstrapEnabled() {
+		if err := s.tryCloneFromPeer(ctx, repo); err != nil {
+			logger.InfoContext(ctx, "Peer mirror clone failed, falling back to upstream clone", "upstream", upstream, "error", err)
+		} else if ready, err := s.freshenSeededMirror(ctx, repo, "peer"); ready || err != nil {
+			return err
 		}
 	}
 
@@ -682,15 +757,58 @@ func (s *Strategy) startClone(ctx context.Context, repo *gitclone.Repository) (r
 	s.metrics.recordOperation(ctx, "clone", "success", time.Since(cloneStart))
 	logger.InfoContext(ctx, "Clone completed", "upstream", upstream, "path", repo.Path())
 
-	if s.config.SnapshotInterval > 0 {
-		s.scheduleSnapshotJobs(repo)
-	}
-	if s.config.RepackInterval > 0 {
-		s.scheduleRepackJobs(repo)
+	s.scheduleSnapshotJobs(repo)
+	s.scheduleRepackJobs(repo)
+	if s.config.ScrubInterval > 0 {
+		s.scheduleScrubJobs(repo)
 	}
 	return nil
 }
 
+// freshenSeededMirror fetches from upstream into a mirror seeded from a
+// snapshot or a peer, then marks it ready and schedules its periodic jobs.
+// It reports false with a nil error if the fetch failed and the seeded
+// mirror was discarded, in which case the caller falls back to a clone.
+func (s *Strategy) freshenSeededMirror(ctx context.Context, repo *gitclone.Repository, source string) (bool, error) {
+	logger := logging.FromContext(ctx)
+	upstream := repo.UpstreamURL()
+	logger.InfoContext(ctx, "Mirror seeded, fetching to freshen", "upstream", upstream, "source", source)
+
+	// Fetch with a generous timeout and no low-speed check: the seed can be
+	// hours old, so the delta may be very large and GitHub's server-side
+	// pack computation can stall at near-zero transfer for minutes (same as
+	// initial clone).
+	//
+	// State remains StateCloning until fetch succeeds so that concurrent
+	// requests (via ensureCloneReady) block rather than serving from a
+	// potentially empty or stale mirror.
+	if err := repo.FetchLenient(ctx, s.cloneManager.Config().CloneTimeout); err != nil {
+		logger.WarnContext(ctx, "Post-seed fetch failed, discarding seeded mirror and falling back to clone",
+			"upstream", upstream, "source", source, "error", err)
+		// The seed may be corrupt or empty. Remove it and fall through to a
+		// fresh clone so we don't re-upload bad data.
+		repo.ResetToEmpty()
+		if rmErr := os.RemoveAll(repo.Path()); rmErr != nil {
+			return false, errors.Wrapf(rmErr, "remove corrupt mirror for %s", upstream)
+		}
+		return false, nil
+	}
+	repo.MarkReady()
+
+	if err := s.cleanupSpools(upstream); err != nil {
+		return true, errors.Wrapf(err, "clean up spools for %s", upstream)
+	}
+
+	logger.InfoContext(ctx, "Post-seed fetch completed, serving", "upstream", upstream, "source", source)
+
+	s.scheduleSnapshotJobs(repo)
+	s.scheduleRepackJobs(repo)
+	if s.config.ScrubInterval > 0 {
+		s.scheduleScrubJobs(repo)
+	}
+	return true, nil
+}
+
 // tryRestoreSnapshot attempts to restore a mirror from an S3 mirror snapshot.
 // Mirror snapshots are bare repositories that can be extracted and used directly
 // without any conversion. The snapshot is extracted into a temporary directory
@@ -759,7 +877,7 @@ func (s *Strategy) submitFetch(repo *gitclone.Repository) {
 // within the ref-check interval, bounding upstream load when fallback bundle
 // requests repeat against the same repository.
 func (s *Strategy) freshenMirror(ctx context.Context, repo *gitclone.Repository) error {
-	if !repo.NeedsFetch(s.cloneManager.Config().RefCheckInterval) {
+	if !repo.NeedsFetch(repo.RefCheckInterval()) {
 		return nil
 	}
 	return errors.WithStack(s.doFetch(ctx, repo))
@@ -800,5 +918,8 @@ func (s *Strategy) fetchMirror(ctx context.Context, repo *gitclone.Repository, f
 	}
 	s.metrics.recordOperation(ctx, "fetch", "success", time.Since(start))
 	logger.InfoContext(ctx, "Fetch completed", "upstream", repo.UpstreamURL(), "duration", time.Since(start))
+	if s.config.settingsFor(repo.UpstreamURL()).repackAfterFetch {
+		s.scheduleRepackAfterFetch(repo)
+	}
 	return nil
 }
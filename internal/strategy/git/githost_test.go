@@ -0,0 +1,54 @@
+package git_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestForwardToGitHost(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+
+	var gotPath, gotUser, gotPassword string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUser, gotPassword, _ = r.BasicAuth()
+		_, _ = w.Write([]byte("raw file"))
+	}))
+	defer upstream.Close()
+
+	mux := newTestMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{
+		MirrorRoot: t.TempDir(),
+		Hosts: []gitclone.HostConfig{{
+			Host:        "gitlab.example.com",
+			URL:         upstream.URL + "/gitlab",
+			Type:        gitclone.HostTypeGitLab,
+			Credentials: "token",
+			Token:       "glpat-test",
+		}},
+	}, nil)
+	_, err := git.New(ctx, git.Config{}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/git/gitlab.example.com/group/repo/-/raw/main/README.md", nil)
+	req = req.WithContext(ctx)
+	req.SetPathValue("host", "gitlab.example.com")
+	req.SetPathValue("path", "group/repo/-/raw/main/README.md")
+	w := httptest.NewRecorder()
+	mux.handlers["GET /git/{host}/{path...}"].ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "raw file", w.Body.String())
+	assert.Equal(t, "/gitlab/group/repo/-/raw/main/README.md", gotPath)
+	assert.Equal(t, "oauth2", gotUser)
+	assert.Equal(t, "glpat-test", gotPassword)
+}
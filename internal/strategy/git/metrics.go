@@ -31,6 +31,8 @@ type gitMetrics struct {
 	snapshotServeBandwidth metric.Float64Histogram
 	lfsPhaseDuration       metric.Float64Histogram
 	lfsPhaseBytes          metric.Float64Histogram
+	scrubTotal             metric.Int64Counter
+	uploadPackCacheTotal   metric.Int64Counter
 }
 
 func newGitMetrics() *gitMetrics {
@@ -50,10 +52,12 @@ func newGitMetrics() *gitMetrics {
 		spoolWriterDuration:    metrics.NewHistogram(meter, "cachew.git.spool_writer_duration_seconds", "s", "Time the snapshot spool writer spent producing the stream", metrics.LatencyBuckets()),
 		spoolFollowerWaitTotal: metrics.NewMetric[metric.Int64Counter](meter, "cachew.git.spool_follower_waits_total", "{waits}", "Snapshot spool follower events, by outcome (served, writer_failed)"),
 		spoolFollowerWait:      metrics.NewHistogram(meter, "cachew.git.spool_follower_wait_seconds", "s", "Time a snapshot spool follower spent waiting for the writer to publish headers", metrics.FastLatencyBuckets()),
-		repackPackCount:        metrics.NewHistogram(meter, "cachew.git.repack_pack_count", "{packs}", "Pack file count observed before and after repack, by stage (before, after)", metrics.SmallCountBuckets()),
+		repackPackCount:        metrics.NewHistogram(meter, "cachew.git.repack_pack_count", "{packs}", "Pack file count observed before and after repack, by mode (geometric, full) and stage (before, after)", metrics.SmallCountBuckets()),
 		snapshotServeBandwidth: metrics.NewHistogram(meter, "cachew.git.snapshot_serve_bandwidth_mbps", "MiBy/s", "Per-request snapshot serve throughput in MiB/s, by source and repository", metrics.BandwidthMbpsBuckets()),
 		lfsPhaseDuration:       metrics.NewHistogram(meter, "cachew.git.lfs_phase_duration_seconds", "s", "Duration of an LFS-snapshot generation phase (discover, clone, fetch, archive_upload), by status and repository", metrics.LatencyBuckets()),
 		lfsPhaseBytes:          metrics.NewHistogram(meter, "cachew.git.lfs_phase_bytes", "By", "Bytes processed in an LFS-snapshot generation phase, by phase and repository (e.g. .git/lfs size after fetch)", metrics.ByteBuckets()),
+		scrubTotal:             metrics.NewMetric[metric.Int64Counter](meter, "cachew.git.mirror_scrubs_total", "{scrubs}", "Mirror integrity scrubs by result (ok, corrupt, error), failed check and repository"),
+		uploadPackCacheTotal:   metrics.NewMetric[metric.Int64Counter](meter, "cachew.git.upload_pack_cache_total", "{requests}", "Upload-pack response cache lookups and stores by result (hit, miss, stored)"),
 	}
 }
 
@@ -67,6 +71,10 @@ func (m *gitMetrics) recordOperation(ctx context.Context, operation, status stri
 	m.operationDuration.Record(ctx, duration.Seconds(), attrs)
 }
 
+func (m *gitMetrics) recordUploadPackCache(ctx context.Context, result string) {
+	m.uploadPackCacheTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
+
 func (m *gitMetrics) recordRequest(ctx context.Context, requestType string) {
 	m.requestTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("type", requestType)))
 }
@@ -152,10 +160,12 @@ func (m *gitMetrics) recordSpoolFollowerWait(ctx context.Context, repo, outcome
 }
 
 // recordRepackPackCount records the pack-file count observed on a mirror at
-// a given stage of a repack. Stage is "before" or "after".
-func (m *gitMetrics) recordRepackPackCount(ctx context.Context, repo, stage string, count int) {
+// a given stage of a repack. Mode is "geometric" or "full"; stage is
+// "before" or "after".
+func (m *gitMetrics) recordRepackPackCount(ctx context.Context, repo, mode, stage string, count int) {
 	m.repackPackCount.Record(ctx, float64(count), metric.WithAttributes(
 		attribute.String("repository", repo),
+		attribute.String("mode", mode),
 		attribute.String("stage", stage),
 	))
 }
@@ -183,3 +193,13 @@ func (m *gitMetrics) recordLFSPhaseBytes(ctx context.Context, repo, phase string
 		attribute.String("phase", phase),
 	))
 }
+
+// recordScrub records the outcome of a mirror integrity scrub. Result is
+// "ok", "corrupt" or "error"; check names the failed check, if any.
+func (m *gitMetrics) recordScrub(ctx context.Context, repo, result, check string) {
+	m.scrubTotal.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("repository", repo),
+		attribute.String("result", result),
+		attribute.String("check", check),
+	))
+}
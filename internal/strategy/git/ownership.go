@@ -0,0 +1,415 @@
+package git
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
+)
+
+const (
+	ownershipPeersMapName = "ownership_peers"
+	// OwnerForwardedHeader marks a request proxied from a non-owner replica.
+	// The receiving replica serves it locally regardless of its own view of
+	// ownership, so replicas that briefly disagree can never loop.
+	OwnerForwardedHeader = "X-Cachew-Owner-Forwarded"
+	// UpstreamErrorHeader marks an error response relayed from, or caused by,
+	// a replica's upstream rather than the replica itself, so a peer proxying
+	// to its owner doesn't count it against the owner.
+	UpstreamErrorHeader = "X-Cachew-Upstream-Error"
+	// Heartbeats older than this many health intervals mark a discovered
+	// peer as gone.
+	ownershipHeartbeatTTLIntervals = 3
+	// Heartbeats older than this are deleted from the shared peer map.
+	ownershipHeartbeatReapAge = 24 * time.Hour
+)
+
+// OwnershipConfig enables consistent-hash mirror ownership. Each upstream is
+// mirrored by one owner replica, chosen by rendezvous hashing over the
+// healthy peers; the other replicas proxy (or redirect) upload-pack traffic
+// to it and skip its periodic jobs.
+type OwnershipConfig struct {
+	Self           string        `hcl:"self,optional" help:"This replica's base URL as reachable by its peers, e.g. \"http://${POD_IP}:8080\". Setting it enables ownership mode." default:""`
+	Peers          []string      `hcl:"peers,optional" help:"Base URLs of every replica sharing mirror ownership. Empty discovers peers through the metadata store."`
+	Redirect       bool          `hcl:"redirect,optional" help:"Redirect git clients' initial ref discovery to the owner so subsequent requests go to it directly, instead of proxying every request." default:"false"`
+	HealthInterval time.Duration `hcl:"health-interval,optional" help:"How often to probe configured peers, or heartbeat into the metadata store when discovering peers." default:"10s"`
+	HeaderTimeout  time.Duration `hcl:"response-header-timeout,optional" help:"How long to wait for the owner's response headers to a proxied request before failing over to serving it locally." default:"1m"`
+}
+
+// Ownership assigns upstream URLs to replicas by rendezvous hashing. A peer is
+// a candidate while it is healthy: configured peers are probed via
+// /_readiness, discovered peers must have a fresh heartbeat in the metadata
+// store, and either kind is excluded for a while after a proxied request to
+// it fails. This replica is always a candidate, so with every peer down it
+// owns everything.
+type Ownership struct {
+	self          string
+	static        []string
+	interval      time.Duration
+	headerTimeout time.Duration
+	client        *http.Client
+	now           func() time.Time
+	mu            sync.Mutex
+	heartbeat     *metadatadb.Map[string, time.Time] // nil unless discovering peers; guarded by mu
+	downUntil     map[string]time.Time
+}
+
+// NewOwnership returns nil if ownership is not configured.
+func NewOwnership(config OwnershipConfig) *Ownership {
+	if config.Self == "" {
+		return nil
+	}
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = 10 * time.Second
+	}
+	if config.HeaderTimeout <= 0 {
+		config.HeaderTimeout = time.Minute
+	}
+	self := normalizePeerURL(config.Self)
+	static := make([]string, 0, len(config.Peers)+1)
+	for _, peer := range config.Peers {
+		static = append(static, normalizePeerURL(peer))
+	}
+	if len(static) > 0 && !slices.Contains(static, self) {
+		static = append(static, self)
+	}
+	return &Ownership{
+		self:          self,
+		static:        static,
+		interval:      config.HealthInterval,
+		headerTimeout: config.HeaderTimeout,
+		client: &http.Client{
+			// Redirects from the owner are the client's business, not ours.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		now:       time.Now,
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+// Self returns this replica's peer URL.
+func (o *Ownership) Self() string {
+	if o == nil {
+		return ""
+	}
+	return o.self
+}
+
+// Owner returns the peer URL of the replica that owns upstreamURL. Without
+// ownership configured every replica owns everything.
+func (o *Ownership) Owner(upstreamURL string) string {
+	if ranked := o.Ranked(upstreamURL); len(ranked) > 0 {
+		return ranked[0]
+	}
+	return ""
+}
+
+// Ranked returns the candidate peers in rendezvous order for upstreamURL:
+// the owner first, then the replicas that would take over in turn.
+func (o *Ownership) Ranked(upstreamURL string) []string {
+	peers := o.Peers()
+	scores := make(map[string]uint64, len(peers))
+	for _, peer := range peers {
+		scores[peer] = rendezvousScore(peer, upstreamURL)
+	}
+	slices.SortFunc(peers, func(a, b string) int { return cmp.Compare(scores[b], scores[a]) })
+	return peers
+}
+
+// Owns reports whether this replica owns upstreamURL.
+func (o *Ownership) Owns(upstreamURL string) bool {
+	return o == nil || o.Owner(upstreamURL) == o.self
+}
+
+// Peers returns the sorted candidate peers, including this replica.
+func (o *Ownership) Peers() []string {
+	if o == nil {
+		return nil
+	}
+	now := o.now()
+	peers := []string{o.self}
+	if heartbeat := o.heartbeatMap(); heartbeat != nil {
+		ttl := ownershipHeartbeatTTLIntervals * o.interval
+		for peer, beat := range heartbeat.Entries() {
+			if peer != o.self && now.Sub(beat) < ttl {
+				peers = append(peers, peer)
+			}
+		}
+	} else {
+		for _, peer := range o.static {
+			if peer != o.self {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	o.mu.Lock()
+	peers = slices.DeleteFunc(peers, func(peer string) bool { return peer != o.self && now.Before(o.downUntil[peer]) })
+	o.mu.Unlock()
+	slices.Sort(peers)
+	return peers
+}
+
+// MarkDown excludes peer from ownership until it next proves healthy, or for
+// a few health intervals when it can't be probed.
+func (o *Ownership) MarkDown(peer string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.downUntil[peer] = o.now().Add(ownershipHeartbeatTTLIntervals * o.interval)
+}
+
+func (o *Ownership) markUp(peer string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.downUntil, peer)
+}
+
+// discover switches peer membership from the static list to heartbeats in
+// ns. It is a no-op when peers are configured statically.
+func (o *Ownership) discover(ns *metadatadb.Namespace) {
+	if o == nil || ns == nil || len(o.static) > 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.heartbeat = metadatadb.NewMap[string, time.Time](ns, ownershipPeersMapName)
+}
+
+func (o *Ownership) heartbeatMap() *metadatadb.Map[string, time.Time] {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.heartbeat
+}
+
+// beat records this replica's heartbeat and reaps long-dead peers.
+func (o *Ownership) beat() error {
+	now := o.now()
+	heartbeat := o.heartbeatMap()
+	for peer, beat := range heartbeat.Entries() {
+		if now.Sub(beat) > ownershipHeartbeatReapAge {
+			if err := heartbeat.Delete(peer); err != nil {
+				return errors.Wrap(err, "reap peer heartbeat")
+			}
+		}
+	}
+	return errors.Wrap(heartbeat.Set(o.self, now), "record peer heartbeat")
+}
+
+// probe checks every configured peer's /_readiness endpoint.
+func (o *Ownership) probe(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	for _, peer := range o.static {
+		if peer == o.self {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, o.interval)
+		err := o.checkReady(ctx, peer)
+		cancel()
+		if err != nil {
+			logger.WarnContext(ctx, "Ownership peer unhealthy", "peer", peer, "error", err)
+			o.MarkDown(peer)
+			continue
+		}
+		o.markUp(peer)
+	}
+}
+
+func (o *Ownership) checkReady(ctx context.Context, peer string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+"/_readiness", nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck // drain for keep-alive
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("readiness returned %s", resp.Status)
+	}
+	return nil
+}
+
+func rendezvousScore(peer, upstreamURL string) uint64 {
+	sum := sha256.Sum256([]byte(peer + "|" + upstreamURL))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func normalizePeerURL(peer string) string {
+	return strings.TrimRight(peer, "/")
+}
+
+// scheduleOwnershipJobs starts peer health probing, or heartbeating when
+// peers are discovered through the metadata store. Called once metadata
+// wiring is complete so the choice between the two is final.
+func (s *Strategy) scheduleOwnershipJobs() {
+	o := s.ownership
+	if o == nil {
+		return
+	}
+	if o.heartbeatMap() != nil {
+		s.scheduler.SubmitPeriodicJob("ownership", "ownership-heartbeat", o.interval, func(context.Context) error {
+			// Only advertise once warm-up is done, so peers don't route
+			// traffic to a replica that isn't serving yet.
+			if !s.Ready() {
+				return nil
+			}
+			return o.beat()
+		})
+		return
+	}
+	if len(o.static) > 1 {
+		s.scheduler.SubmitPeriodicJob("ownership", "ownership-health", o.interval, func(ctx context.Context) error {
+			o.probe(ctx)
+			return nil
+		})
+	}
+}
+
+// ownerOnly wraps a periodic job so that it only does work while this
+// replica owns upstreamURL. The job stays scheduled so it resumes if
+// ownership fails over here.
+func (s *Strategy) ownerOnly(upstreamURL string, run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if !s.ownership.Owns(upstreamURL) {
+			logging.FromContext(ctx).DebugContext(ctx, "Skipping periodic job for mirror owned by another replica",
+				"upstream", upstreamURL, "owner", s.ownership.Owner(upstreamURL))
+			return nil
+		}
+		return run(ctx)
+	}
+}
+
+// serveFromOwner hands a git request for upstreamURL to its owning replica.
+// It reports false if this replica should serve the request itself: because
+// it owns the mirror, the request was already forwarded by a peer, or the
+// owner failed (in which case it is marked down and ownership fails over).
+func (s *Strategy) serveFromOwner(w http.ResponseWriter, r *http.Request, host, pathValue, upstreamURL string) bool {
+	owner := s.remoteOwner(r, upstreamURL)
+	if owner == "" {
+		return false
+	}
+	target := owner + "/git/" + host + "/" + pathValue
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	// Git's default http.followRedirects=initial follows a redirect on the
+	// initial ref discovery and uses the new base URL for the rest of the
+	// session, so one redirect moves the whole clone to the owner.
+	if s.config.Ownership.Redirect && r.Method == http.MethodGet && strings.HasSuffix(pathValue, "/info/refs") &&
+		strings.HasPrefix(r.UserAgent(), "git/") {
+		s.metrics.recordRequest(r.Context(), "owner-redirect")
+		http.Redirect(w, r, target, http.StatusFound)
+		return true
+	}
+
+	return s.proxyToOwner(w, r, owner, upstreamURL, target)
+}
+
+// forwardToOwner proxies a request concerning upstreamURL to the same path on
+// its owning replica. It reports false if this replica should handle the
+// request itself, as serveFromOwner does.
+func (s *Strategy) forwardToOwner(w http.ResponseWriter, r *http.Request, upstreamURL string) bool {
+	owner := s.remoteOwner(r, upstreamURL)
+	if owner == "" {
+		return false
+	}
+	return s.proxyToOwner(w, r, owner, upstreamURL, owner+r.URL.RequestURI())
+}
+
+// remoteOwner returns the peer a request for upstreamURL should be handed
+// to, or "" if this replica should handle it: because ownership is not
+// configured, this replica owns the mirror, or a peer already forwarded it.
+func (s *Strategy) remoteOwner(r *http.Request, upstreamURL string) string {
+	if s.ownership == nil || r.Header.Get(OwnerForwardedHeader) != "" {
+		return ""
+	}
+	owner := s.ownership.Owner(upstreamURL)
+	if owner == s.ownership.Self() {
+		return ""
+	}
+	return owner
+}
+
+// proxyToOwner proxies a request to the owner of upstreamURL at target,
+// streaming back its response. It reports false, having restored the request
+// body, if the owner failed and was marked down: it could not be reached, sent
+// no response headers in time, or answered with a gateway error of its own.
+// Gateway errors the owner relays from its upstream are passed through.
+func (s *Strategy) proxyToOwner(w http.ResponseWriter, r *http.Request, owner, upstreamURL, target string) bool {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	// Buffer the body so it can be replayed locally if the owner fails.
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return true
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	// Give up on an owner that accepts the request but never answers, so the
+	// request fails over rather than hanging.
+	proxyCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(proxyCtx, r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to build owner request, serving locally", "owner", owner, "error", err)
+		return false
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set(OwnerForwardedHeader, s.ownership.Self())
+	timer := time.AfterFunc(s.ownership.headerTimeout, func() {
+		cancel(errors.Errorf("owner did not respond within %s", s.ownership.headerTimeout))
+	})
+	resp, err := s.ownership.client.Do(req)
+	timer.Stop()
+	if ctx.Err() != nil {
+		// The client went away; that says nothing about the owner.
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return true
+	}
+	if err != nil {
+		err = errors.Join(err, context.Cause(proxyCtx))
+	}
+	if err == nil && resp.StatusCode >= http.StatusBadGateway && resp.StatusCode <= http.StatusGatewayTimeout &&
+		resp.Header.Get(UpstreamErrorHeader) == "" {
+		_ = resp.Body.Close()
+		err = errors.Errorf("owner returned %s", resp.Status)
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Mirror owner unavailable, failing over", "owner", owner, "upstream", upstreamURL, "error", err)
+		s.ownership.MarkDown(owner)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return false
+	}
+	defer resp.Body.Close()
+
+	s.metrics.recordRequest(ctx, "owner-proxy")
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.WarnContext(ctx, "Failed to stream response from mirror owner", "owner", owner, "error", err)
+	}
+	return true
+}
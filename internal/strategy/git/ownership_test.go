@@ -0,0 +1,224 @@
+package git_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestOwnershipRendezvous(t *testing.T) {
+	peers := []string{"http://a:8080", "http://b:8080/", "http://c:8080"}
+	a := git.NewOwnership(git.OwnershipConfig{Self: "http://a:8080/", Peers: peers})
+	b := git.NewOwnership(git.OwnershipConfig{Self: "http://b:8080", Peers: peers})
+	assert.Equal(t, []string{"http://a:8080", "http://b:8080", "http://c:8080"}, a.Peers())
+	assert.Zero(t, git.NewOwnership(git.OwnershipConfig{}))
+
+	owners := map[string]string{}
+	counts := map[string]int{}
+	for i := range 300 {
+		upstream := fmt.Sprintf("https://github.com/org/repo%d", i)
+		owner := a.Owner(upstream)
+		// Every replica agrees on the owner.
+		assert.Equal(t, owner, b.Owner(upstream))
+		assert.Equal(t, owner == "http://a:8080", a.Owns(upstream))
+		owners[upstream] = owner
+		counts[owner]++
+	}
+	for _, peer := range a.Peers() {
+		assert.True(t, counts[peer] > 50, "peer %s owns only %d of 300 repos", peer, counts[peer])
+	}
+
+	// Only repos owned by a failed peer move.
+	a.MarkDown("http://c:8080")
+	assert.Equal(t, []string{"http://a:8080", "http://b:8080"}, a.Peers())
+	for upstream, owner := range owners {
+		if owner != "http://c:8080" {
+			assert.Equal(t, owner, a.Owner(upstream))
+		} else {
+			assert.NotEqual(t, "http://c:8080", a.Owner(upstream))
+		}
+	}
+
+	// This replica never marks itself down.
+	a.MarkDown("http://a:8080")
+	assert.Equal(t, []string{"http://a:8080", "http://b:8080"}, a.Peers())
+}
+
+func TestOwnershipServeFromOwner(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+
+	var forwardedBy string
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedBy = r.Header.Get(git.OwnerForwardedHeader)
+		_, _ = w.Write([]byte("from owner " + r.URL.Path + "?" + r.URL.RawQuery)) //nolint:errcheck
+	}))
+	defer owner.Close()
+
+	self := "http://self.invalid:8080"
+	mux := http.NewServeMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: filepath.Join(t.TempDir(), "mirrors")}, nil)
+	s, err := git.New(ctx, git.Config{
+		Ownership: git.OwnershipConfig{Self: self, Peers: []string{self, owner.URL}, Redirect: true},
+	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	// Unroutable upstream so local fallback fails fast rather than reaching the network.
+	host := "127.0.0.1:1"
+	var repoPath string
+	for i := range 100 {
+		candidate := fmt.Sprintf("org/repo%d", i)
+		if git.OwnerOf(s, "https://"+host+"/"+candidate) == owner.URL {
+			repoPath = candidate
+			break
+		}
+	}
+	assert.NotZero(t, repoPath)
+	infoRefs := "/git/" + host + "/" + repoPath + "/info/refs?service=git-upload-pack"
+
+	t.Run("Proxy", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, infoRefs, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "from owner /git/"+host+"/"+repoPath+"/info/refs?service=git-upload-pack", w.Body.String())
+		assert.Equal(t, self, forwardedBy)
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, infoRefs, nil)
+		req.Header.Set("User-Agent", "git/2.45.0")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, owner.URL+infoRefs, w.Header().Get("Location"))
+	})
+
+	t.Run("ForwardedServedLocally", func(t *testing.T) {
+		forwardedBy = ""
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, infoRefs, nil)
+		req.Header.Set(git.OwnerForwardedHeader, owner.URL)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, "", forwardedBy)
+	})
+
+	t.Run("Failover", func(t *testing.T) {
+		owner.Close()
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, infoRefs, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.NotEqual(t, http.StatusOK, w.Code)
+		assert.Equal(t, self, git.OwnerOf(s, "https://"+host+"/"+repoPath))
+	})
+}
+
+func TestOwnershipFailsOverOnHeaderTimeout(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+
+	release := make(chan struct{})
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_readiness" {
+			return
+		}
+		<-release
+	}))
+	defer owner.Close()
+	defer close(release)
+
+	self := "http://self.invalid:8080"
+	mux := http.NewServeMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: filepath.Join(t.TempDir(), "mirrors")}, nil)
+	s, err := git.New(ctx, git.Config{
+		Ownership: git.OwnershipConfig{Self: self, Peers: []string{self, owner.URL}, HeaderTimeout: 100 * time.Millisecond},
+	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	host := "127.0.0.1:1"
+	var repoPath string
+	for i := range 100 {
+		candidate := fmt.Sprintf("org/repo%d", i)
+		if git.OwnerOf(s, "https://"+host+"/"+candidate) == owner.URL {
+			repoPath = candidate
+			break
+		}
+	}
+	assert.NotZero(t, repoPath)
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/git/"+host+"/"+repoPath+"/info/refs?service=git-upload-pack", nil)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	mux.ServeHTTP(w, req)
+	assert.True(t, time.Since(start) < 10*time.Second)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.Equal(t, self, git.OwnerOf(s, "https://"+host+"/"+repoPath))
+}
+
+func TestOwnershipKeepsOwnerOnClientAndUpstreamErrors(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_readiness":
+		case strings.HasSuffix(r.URL.Path, "/git-upload-pack"):
+			// Disconnects are only noticed once the body has been read.
+			_, _ = io.Copy(io.Discard, r.Body) //nolint:errcheck
+			<-r.Context().Done()
+		default:
+			w.Header().Set(git.UpstreamErrorHeader, "1")
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer owner.Close()
+
+	self := "http://self.invalid:8080"
+	mux := http.NewServeMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: filepath.Join(t.TempDir(), "mirrors")}, nil)
+	s, err := git.New(ctx, git.Config{
+		Ownership: git.OwnershipConfig{Self: self, Peers: []string{self, owner.URL}},
+	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	host := "127.0.0.1:1"
+	var repoPath string
+	for i := range 100 {
+		candidate := fmt.Sprintf("org/repo%d", i)
+		if git.OwnerOf(s, "https://"+host+"/"+candidate) == owner.URL {
+			repoPath = candidate
+			break
+		}
+	}
+	assert.NotZero(t, repoPath)
+
+	t.Run("ClientCancel", func(t *testing.T) {
+		reqCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequestWithContext(reqCtx, http.MethodPost, "/git/"+host+"/"+repoPath+"/git-upload-pack", strings.NewReader("0000"))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, owner.URL, git.OwnerOf(s, "https://"+host+"/"+repoPath))
+	})
+
+	t.Run("UpstreamError", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/git/"+host+"/"+repoPath+"/info/refs?service=git-upload-pack", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Equal(t, owner.URL, git.OwnerOf(s, "https://"+host+"/"+repoPath))
+	})
+}
@@ -0,0 +1,96 @@
+package git
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+// ReplicaAuthHeader carries the shared replica-auth-token on requests one
+// cachew replica makes to another's /git/ endpoint to clone its mirror.
+const ReplicaAuthHeader = "X-Cachew-Replica-Auth"
+
+// peerBootstrapEnabled reports whether new mirrors may be cloned from peers.
+func (s *Strategy) peerBootstrapEnabled() bool {
+	return s.ownership != nil && s.config.ReplicaAuthToken != ""
+}
+
+// serveReplicaRequest serves a peer replica cloning this replica's mirror.
+// Only a ready local mirror is served, as-is: the peer fetches the delta
+// from upstream itself, so there is no point in stale-ref checks, upstream
+// passthrough or clone counting. Anything else is a 404 so the peer moves on
+// to its next candidate.
+func (s *Strategy) serveReplicaRequest(w http.ResponseWriter, r *http.Request, upstreamURL string) {
+	ctx := r.Context()
+	if !s.peerBootstrapEnabled() ||
+		subtle.ConstantTimeCompare([]byte(r.Header.Get(ReplicaAuthHeader)), []byte(s.config.ReplicaAuthToken)) != 1 {
+		http.Error(w, "invalid replica credentials", http.StatusUnauthorized)
+		return
+	}
+	s.metrics.recordRequest(ctx, "replica")
+	repo := s.cloneManager.Get(upstreamURL)
+	if repo == nil || repo.State() != gitclone.StateReady {
+		http.Error(w, "no local mirror", http.StatusNotFound)
+		return
+	}
+	if s.serveFromBackend(w, r, repo) {
+		http.Error(w, "mirror is missing requested objects", http.StatusNotFound)
+	}
+}
+
+// tryCloneFromPeer clones repo from the first peer replica, in rendezvous
+// order, that has a ready mirror of it. The owner is tried first; after a
+// scale-out it is usually the previous owner, next in line, that has it.
+func (s *Strategy) tryCloneFromPeer(ctx context.Context, repo *gitclone.Repository) (returnErr error) {
+	ctx, span := tracer.Start(ctx, "git.clone_from_peer",
+		trace.WithAttributes(
+			attribute.String("cachew.operation", "peer-clone"),
+			attribute.String("cachew.upstream", repo.UpstreamURL()),
+		),
+	)
+	defer func() {
+		if returnErr != nil {
+			span.RecordError(returnErr)
+			span.SetStatus(codes.Error, returnErr.Error())
+		}
+		span.End()
+	}()
+
+	if !s.peerBootstrapEnabled() {
+		return errors.New("peer bootstrap not configured")
+	}
+	logger := logging.FromContext(ctx)
+	upstream := repo.UpstreamURL()
+	_, path, _ := strings.Cut(upstream, "://")
+	header := ReplicaAuthHeader + ": " + s.config.ReplicaAuthToken
+	var errs []error
+	for _, peer := range s.ownership.Ranked(upstream) {
+		if peer == s.ownership.Self() {
+			continue
+		}
+		start := time.Now()
+		if err := repo.CloneFromPeer(ctx, peer+"/git/"+path, header); err != nil {
+			s.metrics.recordOperation(ctx, "peer-clone", "error", time.Since(start))
+			logger.InfoContext(ctx, "Peer mirror clone failed", "upstream", upstream, "peer", peer, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		s.metrics.recordOperation(ctx, "peer-clone", "success", time.Since(start))
+		logger.InfoContext(ctx, "Mirror cloned from peer", "upstream", upstream, "peer", peer, "duration", time.Since(start))
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no healthy peers")
+	}
+	return errors.Wrap(errors.Join(errs...), "clone from peers")
+}
@@ -0,0 +1,114 @@
+package git_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestCloneFromPeer(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+	upstreamURL := "https://github.com/org/repo"
+	const token = "s3cret"
+
+	// Peer A has a ready mirror and serves it over its /git/ endpoint.
+	rootA := filepath.Join(tmpDir, "a")
+	createTestMirrorRepo(t, filepath.Join(rootA, "github.com", "org", "repo"))
+	muxA := http.NewServeMux()
+	muxA.HandleFunc("GET /_readiness", func(http.ResponseWriter, *http.Request) {})
+	peerA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		muxA.ServeHTTP(w, r.WithContext(logging.ContextWithLogger(r.Context(), logging.FromContext(ctx))))
+	}))
+	defer peerA.Close()
+	selfB := "http://b.invalid:8080"
+	peers := []string{peerA.URL, selfB}
+
+	cmA := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: rootA}, nil)
+	a, err := git.New(ctx, git.Config{
+		ReplicaAuthToken: token,
+		Ownership:        git.OwnershipConfig{Self: peerA.URL, Peers: peers},
+	}, newTestScheduler(ctx, t), nil, muxA, cmA, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, a)
+
+	t.Run("RejectsBadToken", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, peerA.URL+"/git/github.com/org/repo/info/refs?service=git-upload-pack", nil)
+		assert.NoError(t, err)
+		req.Header.Set(git.ReplicaAuthHeader, "wrong")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("UnknownRepo", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, peerA.URL+"/git/github.com/org/other/info/refs?service=git-upload-pack", nil)
+		assert.NoError(t, err)
+		req.Header.Set(git.ReplicaAuthHeader, token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		rootB := filepath.Join(tmpDir, "b")
+		cmB := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: rootB}, nil)
+		b, err := git.New(ctx, git.Config{
+			ReplicaAuthToken: token,
+			Ownership:        git.OwnershipConfig{Self: selfB, Peers: peers},
+		}, newTestScheduler(ctx, t), nil, newTestMux(), cmB, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+		assert.NoError(t, err)
+		waitForReady(t, b)
+
+		managerB, err := cmB()
+		assert.NoError(t, err)
+		repo, err := managerB.GetOrCreate(ctx, upstreamURL)
+		assert.NoError(t, err)
+		assert.True(t, repo.TryStartCloning())
+		assert.NoError(t, b.TryCloneFromPeer(ctx, repo))
+
+		output, err := exec.Command("git", "-C", repo.Path(), "remote", "get-url", "origin").Output()
+		assert.NoError(t, err)
+		assert.Equal(t, upstreamURL, strings.TrimSpace(string(output)))
+		output, err = exec.Command("git", "-C", repo.Path(), "rev-parse", "HEAD").Output()
+		assert.NoError(t, err)
+		expected, err := exec.Command("git", "-C", filepath.Join(rootA, "github.com", "org", "repo"), "rev-parse", "HEAD").Output()
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), string(output))
+	})
+
+	t.Run("NoPeerHasMirror", func(t *testing.T) {
+		rootC := filepath.Join(tmpDir, "c")
+		cmC := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: rootC}, nil)
+		c, err := git.New(ctx, git.Config{
+			ReplicaAuthToken: token,
+			Ownership:        git.OwnershipConfig{Self: selfB, Peers: peers},
+		}, newTestScheduler(ctx, t), nil, newTestMux(), cmC, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+		assert.NoError(t, err)
+		waitForReady(t, c)
+
+		managerC, err := cmC()
+		assert.NoError(t, err)
+		repo, err := managerC.GetOrCreate(ctx, "https://github.com/org/other")
+		assert.NoError(t, err)
+		assert.True(t, repo.TryStartCloning())
+		assert.Error(t, c.TryCloneFromPeer(ctx, repo))
+	})
+}
@@ -1,15 +1,127 @@
 package git
 
 import (
+	"bytes"
+	"context"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/block/cachew/internal/gitclone"
 	"github.com/block/cachew/internal/logging"
 )
 
+// maxPushReportBytes bounds how much of a receive-pack response is kept to
+// find its report-status. Progress messages precede the report, so a longer
+// response is assumed to have updated refs.
+const maxPushReportBytes = 1 << 20
+
 func (s *Strategy) forwardToUpstream(w http.ResponseWriter, r *http.Request, host, pathValue string) {
 	logger := logging.FromContext(r.Context())
 
 	logger.DebugContext(r.Context(), "Forwarding to upstream", "method", r.Method, "host", host, "path", pathValue)
 
-	s.proxy.ServeHTTP(w, r)
+	if r.Method != http.MethodPost || !strings.HasSuffix(pathValue, "/git-receive-pack") {
+		s.proxy.ServeHTTP(w, r)
+		return
+	}
+	repo := s.cloneManager.Get(s.cloneManager.UpstreamURL(host, ExtractRepoPath(pathValue)))
+	if repo == nil || repo.State() != gitclone.StateReady {
+		s.proxy.ServeHTTP(w, r)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+	s.proxy.ServeHTTP(sw, r)
+	// Rejected pushes are also answered 200, with the rejection in the report.
+	if sw.code == http.StatusOK && (sw.truncated || pushUpdatedRefs(sw.body)) {
+		s.submitPushFetch(repo)
+	}
+}
+
+// pushUpdatedRefs reports whether a receive-pack response's report-status
+// accepted at least one ref update, demultiplexing it from side-band channel
+// 1 if need be. A response without a recognisable report is assumed to have.
+func pushUpdatedRefs(body []byte) bool {
+	var band []byte
+	reported := false
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return true
+		}
+		n, err := strconv.ParseUint(string(body[:4]), 16, 16)
+		if err != nil || int(n) > len(body) {
+			return true
+		}
+		if n <= 4 {
+			body = body[4:]
+			continue
+		}
+		line := body[4:n]
+		body = body[n:]
+		switch {
+		case line[0] == 1:
+			band = append(band, line[1:]...)
+		case bytes.HasPrefix(line, []byte("ok ")):
+			return true
+		case bytes.HasPrefix(line, []byte("unpack ")):
+			reported = true
+		}
+	}
+	if band != nil {
+		return pushUpdatedRefs(band)
+	}
+	return !reported
+}
+
+// submitPushFetch refreshes a mirror after a push was proxied through to its
+// upstream, so the pushed refs are served without waiting for the fetch
+// interval or a ref check to notice them. Pushes that land while a refresh
+// is queued share it. The fetch is verified rather than coalesced with an
+// in-flight fetch, which may have started before the push landed.
+func (s *Strategy) submitPushFetch(repo *gitclone.Repository) {
+	upstream := repo.UpstreamURL()
+	if _, queued := s.pushFetchQueued.LoadOrStore(upstream, true); queued {
+		return
+	}
+	s.scheduler.Submit(upstream+"/fetch", "push-fetch", func(ctx context.Context) error {
+		s.pushFetchQueued.Delete(upstream)
+		if repo.State() != gitclone.StateReady {
+			return nil
+		}
+		return s.doFetchVerified(ctx, repo)
+	})
+}
+
+// statusWriter records the status code of a proxied response, and its body
+// up to maxPushReportBytes.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	body        []byte
+	truncated   bool
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if len(w.body)+len(p) > maxPushReportBytes {
+		w.body, w.truncated = nil, true
+	}
+	if !w.truncated {
+		w.body = append(w.body, p...)
+	}
+	return w.ResponseWriter.Write(p) //nolint:wrapcheck
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so the
+// proxy can still flush streamed responses.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
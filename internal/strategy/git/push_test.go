@@ -0,0 +1,109 @@
+package git_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+// pushTransport stands in for the upstream receive-pack endpoint by running
+// push against the mirror's origin.
+type pushTransport func(req *http.Request) (*http.Response, error)
+
+func (f pushTransport) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestPushTriggersFetch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	sideband := func(lines ...string) string {
+		var report string
+		for _, line := range lines {
+			report += pkt(line + "\n")
+		}
+		return pkt("\x02remote: Resolving deltas\n") + pkt("\x01"+report+"0000") + "0000"
+	}
+	for _, tt := range []struct {
+		name    string
+		report  string
+		fetched bool
+	}{
+		{"Accepted", sideband("unpack ok", "ok refs/heads/main"), true},
+		{"AcceptedWithoutSideBand", pkt("unpack ok\n") + pkt("ok refs/heads/main\n") + "0000", true},
+		{"Rejected", sideband("unpack ok", "ng refs/heads/main non-fast-forward"), false},
+		{"UnpackFailed", pkt("unpack index-pack abnormal exit\n") + pkt("ng refs/heads/main unpacker error\n") + "0000", false},
+		{"NoReport", "0000", true},
+		{"EmptyPktLine", "0004" + sideband("unpack ok", "ng refs/heads/main non-fast-forward"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{})
+			mirrorRoot := t.TempDir()
+			mirrorPath := filepath.Join(mirrorRoot, "github.com", "org", "repo")
+			createTestMirrorRepo(t, mirrorPath)
+			origin := gitOutput(t, "-C", mirrorPath, "config", "remote.origin.url")
+			branch := gitOutput(t, "-C", origin, "symbolic-ref", "--short", "HEAD")
+
+			mux := newTestMux()
+			cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+			s, err := git.New(ctx, git.Config{}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+			assert.NoError(t, err)
+			waitForReady(t, s)
+
+			var pushedPath string
+			s.SetHTTPTransport(pushTransport(func(req *http.Request) (*http.Response, error) {
+				pushedPath = req.URL.Path
+				gitOutput(t, "-C", origin, "commit", "--allow-empty", "-m", "pushed")
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"application/x-git-receive-pack-result"}},
+					Body:       io.NopCloser(strings.NewReader(tt.report)),
+					Request:    req,
+				}, nil
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/git/github.com/org/repo.git/git-receive-pack", strings.NewReader("0000"))
+			req = req.WithContext(ctx)
+			req.Header.Set("Content-Type", "application/x-git-receive-pack-request")
+			req.SetPathValue("host", "github.com")
+			req.SetPathValue("path", "org/repo.git/git-receive-pack")
+			w := httptest.NewRecorder()
+			mux.handlers["POST /git/{host}/{path...}"].ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.report, w.Body.String())
+			assert.Equal(t, "/org/repo.git/git-receive-pack", pushedPath)
+
+			head := gitOutput(t, "-C", origin, "rev-parse", "HEAD")
+			mirrored := func() bool {
+				out, err := exec.Command("git", "-C", mirrorPath, "rev-parse", "refs/heads/"+branch).Output()
+				return err == nil && strings.TrimSpace(string(out)) == head
+			}
+			if !tt.fetched {
+				time.Sleep(500 * time.Millisecond)
+				assert.False(t, mirrored(), "mirror was fetched after rejected push")
+				return
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if mirrored() {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			t.Fatal("mirror was not fetched after proxied push")
+		})
+	}
+}
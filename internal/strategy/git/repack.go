@@ -15,46 +15,87 @@ import (
 	"github.com/block/cachew/internal/gitclone"
 )
 
+// Repack modes. Geometric repacks are cheap enough to run after every fetch;
+// full repacks rewrite the whole mirror and are only a rare fallback.
+const (
+	repackGeometric = "geometric"
+	repackFull      = "full"
+)
+
 func (s *Strategy) scheduleRepackJobs(repo *gitclone.Repository) {
-	s.scheduler.SubmitPeriodicJob(repo.UpstreamURL(), "repack-periodic", s.config.RepackInterval, func(ctx context.Context) (returnErr error) {
-		upstream := repo.UpstreamURL()
-		ctx, span := tracer.Start(ctx, "git.repack",
-			trace.WithAttributes(
-				attribute.String("cachew.operation", "repack"),
-				attribute.String("cachew.upstream", upstream),
-			),
-		)
-		defer func() {
-			if returnErr != nil {
-				span.RecordError(returnErr)
-				span.SetStatus(codes.Error, returnErr.Error())
-			}
-			span.End()
-		}()
+	settings := s.config.settingsFor(repo.UpstreamURL())
+	if settings.repackInterval > 0 {
+		s.scheduler.SubmitPeriodicJob(repo.UpstreamURL(), "repack-periodic", settings.repackInterval, s.ownerOnly(repo.UpstreamURL(), func(ctx context.Context) error {
+			return s.runRepack(ctx, repo, repackGeometric)
+		}))
+	}
+	if settings.fullRepackInterval > 0 {
+		s.scheduler.SubmitPeriodicJob(repo.UpstreamURL(), "full-repack-periodic", settings.fullRepackInterval, s.ownerOnly(repo.UpstreamURL(), func(ctx context.Context) error {
+			return s.runRepack(ctx, repo, repackFull)
+		}))
+	}
+}
 
-		// Pack count before and after gives us a direct view of how much
-		// the geometric repack actually consolidated. A flat before/after
-		// ratio over time means fragmentation is outpacing the schedule.
-		if before, err := countPackFiles(repo.Path()); err == nil {
-			s.metrics.recordRepackPackCount(ctx, upstream, "before", before)
-			span.SetAttributes(attribute.Int("cachew.pack_count_before", before))
+// scheduleRepackAfterFetch queues a geometric repack once a fetch has landed
+// a new pack. At most one is queued per mirror: a burst of fetches while the
+// repack waits behind other jobs is consolidated by that single run.
+func (s *Strategy) scheduleRepackAfterFetch(repo *gitclone.Repository) {
+	upstream := repo.UpstreamURL()
+	if _, queued := s.repackAfterFetchQueued.LoadOrStore(upstream, true); queued {
+		return
+	}
+	s.scheduler.Submit(upstream, "repack-after-fetch", func(ctx context.Context) error {
+		s.repackAfterFetchQueued.Delete(upstream)
+		if repo.State() != gitclone.StateReady {
+			return nil
 		}
+		return s.runRepack(ctx, repo, repackGeometric)
+	})
+}
 
-		start := time.Now()
-		err := repo.Repack(ctx)
-		status := "success"
-		if err != nil {
-			status = "error"
+func (s *Strategy) runRepack(ctx context.Context, repo *gitclone.Repository, mode string) (returnErr error) {
+	upstream := repo.UpstreamURL()
+	ctx, span := tracer.Start(ctx, "git.repack",
+		trace.WithAttributes(
+			attribute.String("cachew.operation", "repack"),
+			attribute.String("cachew.upstream", upstream),
+			attribute.String("cachew.repack_mode", mode),
+		),
+	)
+	defer func() {
+		if returnErr != nil {
+			span.RecordError(returnErr)
+			span.SetStatus(codes.Error, returnErr.Error())
 		}
-		s.metrics.recordOperation(ctx, "repack", status, time.Since(start))
+		span.End()
+	}()
 
-		if after, countErr := countPackFiles(repo.Path()); countErr == nil {
-			s.metrics.recordRepackPackCount(ctx, upstream, "after", after)
-			span.SetAttributes(attribute.Int("cachew.pack_count_after", after))
-		}
+	// Pack count before and after gives us a direct view of how much
+	// a geometric repack actually consolidated. A flat before/after
+	// ratio over time means fragmentation is outpacing the schedule.
+	if before, err := countPackFiles(repo.Path()); err == nil {
+		s.metrics.recordRepackPackCount(ctx, upstream, mode, "before", before)
+		span.SetAttributes(attribute.Int("cachew.pack_count_before", before))
+	}
 
-		return errors.Wrap(err, "repack")
-	})
+	repack, operation := repo.Repack, "repack"
+	if mode == repackFull {
+		repack, operation = repo.FullRepack, "full-repack"
+	}
+	start := time.Now()
+	err := repack(ctx)
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	s.metrics.recordOperation(ctx, operation, status, time.Since(start))
+
+	if after, countErr := countPackFiles(repo.Path()); countErr == nil {
+		s.metrics.recordRepackPackCount(ctx, upstream, mode, "after", after)
+		span.SetAttributes(attribute.Int("cachew.pack_count_after", after))
+	}
+
+	return errors.Wrap(err, operation)
 }
 
 // countPackFiles returns the number of .pack files in the mirror's
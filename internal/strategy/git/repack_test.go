@@ -2,6 +2,7 @@ package git_test
 
 import (
 	"context"
+	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
@@ -41,7 +42,7 @@ func TestRepackInterval(t *testing.T) {
 			}, nil)
 			s, err := git.New(ctx, git.Config{
 				RepackInterval: tt.repackInterval,
-			}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }) //nolint:nilnil
+			}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
 			assert.NoError(t, err)
 			assert.True(t, s != nil)
 		})
@@ -65,7 +66,38 @@ func TestRepackScheduledForExistingRepos(t *testing.T) {
 	}, nil)
 	s, err := git.New(ctx, git.Config{
 		RepackInterval: 24 * time.Hour,
-	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }) //nolint:nilnil
+	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
 	assert.NoError(t, err)
 	assert.True(t, s != nil)
 }
+
+func TestRepackAfterFetch(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	mirrorRoot := filepath.Join(t.TempDir(), "mirrors")
+	mirrorPath := filepath.Join(mirrorRoot, "github.com", "org", "repo")
+	createTestMirrorRepo(t, mirrorPath)
+	midxPath := filepath.Join(mirrorPath, "objects", "pack", "multi-pack-index")
+
+	mux := http.NewServeMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+	s, err := git.New(ctx, git.Config{
+		RepackAfterFetch: true,
+	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	// The startup fetch doesn't repack; only fetches on the serving path do.
+	_, err = os.Stat(midxPath)
+	assert.True(t, os.IsNotExist(err), "no repack expected before a fetch")
+
+	w := adminRequest(ctx, mux, http.MethodPost, "/admin/git/repos/github.com/org/repo/fetch")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err = os.Stat(midxPath); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.NoError(t, err, "geometric repack should write a multi-pack-index after the fetch")
+}
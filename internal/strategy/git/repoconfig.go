@@ -0,0 +1,128 @@
+package git
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/gitclone"
+)
+
+// RepoConfig overrides strategy settings for upstreams matching Pattern.
+// Unset fields inherit the strategy-wide value.
+type RepoConfig struct {
+	Pattern                string         `hcl:"pattern,label" help:"Glob matched against the upstream URL without its scheme, e.g. \"github.com/org/*\". The first matching block applies."`
+	SnapshotInterval       *time.Duration `hcl:"snapshot-interval,optional" help:"Overrides snapshot-interval. 0 disables snapshots for matching repositories."`
+	MirrorSnapshotInterval *time.Duration `hcl:"mirror-snapshot-interval,optional" help:"Overrides mirror-snapshot-interval."`
+	LFSSnapshot            *bool          `hcl:"lfs-snapshot,optional" help:"Generate LFS snapshots alongside workstation snapshots. Enabled unless set to false."`
+	RepackInterval         *time.Duration `hcl:"repack-interval,optional" help:"Overrides repack-interval."`
+	RepackAfterFetch       *bool          `hcl:"repack-after-fetch,optional" help:"Overrides repack-after-fetch."`
+	FullRepackInterval     *time.Duration `hcl:"full-repack-interval,optional" help:"Overrides full-repack-interval."`
+	RefCheckInterval       *time.Duration `hcl:"ref-check-interval,optional" help:"Overrides the git-clone ref-check-interval, which bounds how often matching repositories are fetched."`
+	RefInclude             []string       `hcl:"ref-include,optional" help:"Overrides the git-clone ref-include."`
+	RefExclude             []string       `hcl:"ref-exclude,optional" help:"Overrides the git-clone ref-exclude."`
+}
+
+// repoSettings are the effective settings for a single upstream.
+type repoSettings struct {
+	snapshotInterval       time.Duration
+	mirrorSnapshotInterval time.Duration
+	lfsSnapshot            bool
+	repackInterval         time.Duration
+	repackAfterFetch       bool
+	fullRepackInterval     time.Duration
+	refCheckInterval       time.Duration // 0 keeps the clone manager's default
+	refInclude             []string      // nil keeps the clone manager's default
+	refExclude             []string      // nil keeps the clone manager's default
+}
+
+// validateRepoConfigs rejects malformed glob and ref patterns up front
+// rather than silently never matching.
+func validateRepoConfigs(repos []RepoConfig) error {
+	for _, repo := range repos {
+		if _, err := path.Match(repo.Pattern, ""); err != nil {
+			return errors.Wrapf(err, "repo %q", repo.Pattern)
+		}
+		if err := gitclone.ValidateRefPatterns(repo.RefInclude); err != nil {
+			return errors.Wrapf(err, "repo %q: ref-include", repo.Pattern)
+		}
+		if err := gitclone.ValidateRefPatterns(repo.RefExclude); err != nil {
+			return errors.Wrapf(err, "repo %q: ref-exclude", repo.Pattern)
+		}
+	}
+	return nil
+}
+
+// settingsFor resolves the settings for upstreamURL from the first matching
+// repo block, falling back to the strategy-wide values.
+func (c Config) settingsFor(upstreamURL string) repoSettings {
+	settings := repoSettings{
+		snapshotInterval:       c.SnapshotInterval,
+		mirrorSnapshotInterval: c.MirrorSnapshotInterval,
+		lfsSnapshot:            true,
+		repackInterval:         c.RepackInterval,
+		repackAfterFetch:       c.RepackAfterFetch,
+		fullRepackInterval:     c.FullRepackInterval,
+	}
+	_, repoPath, _ := strings.Cut(upstreamURL, "://")
+	for _, repo := range c.Repos {
+		if ok, _ := path.Match(repo.Pattern, repoPath); !ok { //nolint:errcheck // validated in New
+			continue
+		}
+		override(&settings.snapshotInterval, repo.SnapshotInterval)
+		override(&settings.mirrorSnapshotInterval, repo.MirrorSnapshotInterval)
+		override(&settings.lfsSnapshot, repo.LFSSnapshot)
+		override(&settings.repackInterval, repo.RepackInterval)
+		override(&settings.repackAfterFetch, repo.RepackAfterFetch)
+		override(&settings.fullRepackInterval, repo.FullRepackInterval)
+		override(&settings.refCheckInterval, repo.RefCheckInterval)
+		if repo.RefInclude != nil {
+			settings.refInclude = repo.RefInclude
+		}
+		if repo.RefExclude != nil {
+			settings.refExclude = repo.RefExclude
+		}
+		break
+	}
+	if settings.mirrorSnapshotInterval == 0 {
+		settings.mirrorSnapshotInterval = settings.snapshotInterval
+	}
+	return settings
+}
+
+// cloneConfigFor applies the clone-manager overrides for upstreamURL to
+// config.
+func (c Config) cloneConfigFor(upstreamURL string, config gitclone.Config) gitclone.Config {
+	settings := c.settingsFor(upstreamURL)
+	if settings.refCheckInterval > 0 {
+		config.RefCheckInterval = settings.refCheckInterval
+	}
+	if settings.refInclude != nil {
+		config.RefInclude = settings.refInclude
+	}
+	if settings.refExclude != nil {
+		config.RefExclude = settings.refExclude
+	}
+	return config
+}
+
+// snapshotsEnabled reports whether any repository may be snapshotted.
+func (c Config) snapshotsEnabled() bool {
+	if c.SnapshotInterval > 0 {
+		return true
+	}
+	for _, repo := range c.Repos {
+		if repo.SnapshotInterval != nil && *repo.SnapshotInterval > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func override[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
@@ -0,0 +1,99 @@
+package git_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/hcl/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestRepoConfigOverrides(t *testing.T) {
+	var config git.Config
+	assert.NoError(t, hcl.Unmarshal([]byte(`
+snapshot-interval        = "1h"
+mirror-snapshot-interval = "2h"
+repack-interval          = "6h"
+
+repo "github.com/org/mono" {
+  snapshot-interval  = "15m"
+  repack-after-fetch = true
+  ref-check-interval = "2s"
+}
+
+repo "github.com/archived/*" {
+  snapshot-interval = "0s"
+  lfs-snapshot      = false
+}
+
+repo "github.com/org/*" {
+  repack-interval = "0s"
+}
+`), &config))
+
+	assert.Equal(t, git.RepoSettings{
+		SnapshotInterval:       15 * time.Minute,
+		MirrorSnapshotInterval: 2 * time.Hour,
+		LFSSnapshot:            true,
+		RepackInterval:         6 * time.Hour,
+		RepackAfterFetch:       true,
+		RefCheckInterval:       2 * time.Second,
+	}, git.SettingsFor(config, "https://github.com/org/mono"), "first matching block wins")
+
+	assert.Equal(t, git.RepoSettings{
+		MirrorSnapshotInterval: 2 * time.Hour,
+		RepackInterval:         6 * time.Hour,
+	}, git.SettingsFor(config, "https://github.com/archived/old"))
+
+	assert.Equal(t, git.RepoSettings{
+		SnapshotInterval:       time.Hour,
+		MirrorSnapshotInterval: 2 * time.Hour,
+		LFSSnapshot:            true,
+	}, git.SettingsFor(config, "https://github.com/org/service"))
+
+	assert.Equal(t, git.RepoSettings{
+		SnapshotInterval:       time.Hour,
+		MirrorSnapshotInterval: 2 * time.Hour,
+		LFSSnapshot:            true,
+		RepackInterval:         6 * time.Hour,
+	}, git.SettingsFor(config, "https://gitlab.com/org/service"), "unmatched repositories use strategy-wide settings")
+}
+
+func TestRepoConfigRefCheckInterval(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	interval := 2 * time.Second
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{
+		MirrorRoot:       filepath.Join(t.TempDir(), "mirrors"),
+		RefCheckInterval: time.Minute,
+	}, nil)
+	_, err := git.New(ctx, git.Config{
+		Repos: []git.RepoConfig{{Pattern: "github.com/org/mono", RefCheckInterval: &interval}},
+	}, newTestScheduler(ctx, t), nil, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+
+	manager, err := cm()
+	assert.NoError(t, err)
+	mono, err := manager.GetOrCreate(ctx, "https://github.com/org/mono")
+	assert.NoError(t, err)
+	assert.Equal(t, interval, mono.RefCheckInterval())
+	other, err := manager.GetOrCreate(ctx, "https://github.com/org/other")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, other.RefCheckInterval())
+
+	for _, invalid := range []git.RepoConfig{
+		{Pattern: "github.com/[org"},
+		{Pattern: "github.com/org/*", RefExclude: []string{"pull/*"}},
+	} {
+		_, err = git.New(ctx, git.Config{
+			Repos: []git.RepoConfig{invalid},
+		}, newTestScheduler(ctx, t), nil, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"context"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+func (s *Strategy) scheduleScrubJobs(repo *gitclone.Repository) {
+	s.scheduler.SubmitPeriodicJob(repo.UpstreamURL(), "scrub-periodic", s.config.ScrubInterval, s.ownerOnly(repo.UpstreamURL(), func(ctx context.Context) error {
+		return s.runScrub(ctx, repo)
+	}))
+}
+
+// runScrub verifies a mirror's integrity. A corrupt mirror is discarded and
+// a job submitted to re-clone it, preferring a mirror snapshot restore as for
+// any cold mirror. Clients are unaffected beyond waiting for the re-clone:
+// the repository stays known to the manager, so requests block in
+// ensureCloneReady.
+func (s *Strategy) runScrub(ctx context.Context, repo *gitclone.Repository) (returnErr error) {
+	upstream := repo.UpstreamURL()
+	ctx, span := tracer.Start(ctx, "git.scrub",
+		trace.WithAttributes(
+			attribute.String("cachew.operation", "scrub"),
+			attribute.String("cachew.upstream", upstream),
+		),
+	)
+	defer func() {
+		if returnErr != nil {
+			span.RecordError(returnErr)
+			span.SetStatus(codes.Error, returnErr.Error())
+		}
+		span.End()
+	}()
+
+	if repo.State() != gitclone.StateReady {
+		return nil
+	}
+	logger := logging.FromContext(ctx)
+
+	start := time.Now()
+	err := repo.Verify(ctx)
+	if err == nil {
+		s.metrics.recordOperation(ctx, "scrub", "success", time.Since(start))
+		s.metrics.recordScrub(ctx, upstream, "ok", "")
+		return nil
+	}
+	corruption, ok := errors.AsType[*gitclone.CorruptionError](err)
+	if !ok {
+		s.metrics.recordOperation(ctx, "scrub", "error", time.Since(start))
+		s.metrics.recordScrub(ctx, upstream, "error", "")
+		return errors.Wrap(err, "scrub")
+	}
+	s.metrics.recordOperation(ctx, "scrub", "corrupt", time.Since(start))
+	s.metrics.recordScrub(ctx, upstream, "corrupt", corruption.Check)
+	span.SetAttributes(attribute.String("cachew.scrub_check", corruption.Check))
+	logger.ErrorContext(ctx, "Mirror failed integrity check, re-cloning",
+		"upstream", upstream, "check", corruption.Check, "output", corruption.Output)
+
+	if err := s.removeMirror(ctx, repo); err != nil {
+		return errors.Wrap(err, "remove corrupt mirror")
+	}
+	// The job ID ends in "clone" so that the re-clone counts against the
+	// scheduler's clone concurrency limit.
+	s.scheduler.Submit(upstream, "scrub-clone", func(ctx context.Context) error {
+		return errors.Wrap(s.startClone(ctx, repo), "re-clone corrupt mirror")
+	})
+	return nil
+}
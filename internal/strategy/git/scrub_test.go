@@ -0,0 +1,60 @@
+package git_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestScrubDiscardsCorruptMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	mirrorRoot := filepath.Join(t.TempDir(), "mirrors")
+	// Unroutable upstream so the re-clone fails fast rather than reaching the network.
+	mirrorPath := filepath.Join(mirrorRoot, "127.0.0.1:1", "org", "repo")
+	createTestMirrorRepo(t, mirrorPath)
+
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+	s, err := git.New(ctx, git.Config{}, newTestScheduler(ctx, t), memCache, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	manager, err := cm()
+	assert.NoError(t, err)
+	repo := manager.Get("https://127.0.0.1:1/org/repo")
+	assert.NotZero(t, repo)
+
+	assert.NoError(t, s.RunScrub(ctx, repo))
+	assert.Equal(t, gitclone.StateReady, repo.State())
+	// The re-clone restores this snapshot, then freshens it from the local origin.
+	assert.NoError(t, s.GenerateAndUploadMirrorSnapshot(ctx, repo))
+
+	broken := filepath.Join(mirrorPath, "refs", "heads", "broken")
+	assert.NoError(t, os.WriteFile(broken, []byte("1111111111111111111111111111111111111111\n"), 0o644))
+	assert.NoError(t, s.RunScrub(ctx, repo))
+	_, err = os.Stat(broken)
+	assert.True(t, os.IsNotExist(err), "corrupt mirror should be removed")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for repo.State() != gitclone.StateReady && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, gitclone.StateReady, repo.State())
+	assert.NoError(t, s.RunScrub(ctx, repo))
+}
@@ -261,8 +261,12 @@ const snapshotStartupSpread = 5 * time.Minute
 
 func (s *Strategy) scheduleSnapshotJobs(repo *gitclone.Repository) {
 	upstream := repo.UpstreamURL()
+	settings := s.config.settingsFor(upstream)
+	if settings.snapshotInterval <= 0 {
+		return
+	}
 	submit := func(job string, interval time.Duration, generate func(ctx context.Context) (string, error)) {
-		run := s.coordinatedSnapshotJob(job, repo, interval, generate)
+		run := s.ownerOnly(upstream, s.coordinatedSnapshotJob(job, repo, interval, generate))
 		delay, interval := s.snapshotSchedule(interval)
 		if delay == 0 {
 			s.scheduler.SubmitPeriodicJob(upstream, job+"-periodic", interval, run)
@@ -274,20 +278,18 @@ func (s *Strategy) scheduleSnapshotJobs(repo *gitclone.Repository) {
 			s.scheduler.SubmitPeriodicJob(upstream, job+"-periodic", interval, run)
 		})
 	}
-	submit(snapshotJobBase, s.config.SnapshotInterval, func(ctx context.Context) (string, error) {
+	submit(snapshotJobBase, settings.snapshotInterval, func(ctx context.Context) (string, error) {
 		if err := s.doFetch(ctx, repo); err != nil {
 			logging.FromContext(ctx).WarnContext(ctx, "Pre-snapshot fetch failed", "upstream", upstream, "error", err)
 		}
 		return s.generateAndUploadSnapshot(ctx, repo)
 	})
-	submit(snapshotJobLFS, s.config.SnapshotInterval, func(ctx context.Context) (string, error) {
-		return s.generateAndUploadLFSSnapshot(ctx, repo)
-	})
-	mirrorInterval := s.config.MirrorSnapshotInterval
-	if mirrorInterval == 0 {
-		mirrorInterval = s.config.SnapshotInterval
+	if settings.lfsSnapshot {
+		submit(snapshotJobLFS, settings.snapshotInterval, func(ctx context.Context) (string, error) {
+			return s.generateAndUploadLFSSnapshot(ctx, repo)
+		})
 	}
-	submit(snapshotJobMirror, mirrorInterval, func(ctx context.Context) (string, error) {
+	submit(snapshotJobMirror, settings.mirrorSnapshotInterval, func(ctx context.Context) (string, error) {
 		return s.generateAndUploadMirrorSnapshot(ctx, repo)
 	})
 }
@@ -364,7 +366,7 @@ func (s *Strategy) snapshotMutexFor(upstreamURL string) *sync.Mutex {
 func (s *Strategy) handleSnapshotRequest(w http.ResponseWriter, r *http.Request, host, pathValue string) { //nolint:funlen
 	start := time.Now()
 	repoPath := ExtractRepoPath(strings.TrimSuffix(pathValue, "/snapshot.tar.zst"))
-	upstreamURL := "https://" + host + "/" + repoPath
+	upstreamURL := s.cloneManager.UpstreamURL(host, repoPath)
 	repoName := host + "/" + repoPath
 
 	ctx, span := tracer.Start(r.Context(), "git.snapshot.serve",
@@ -541,7 +543,7 @@ func serveReaderFast(w http.ResponseWriter, r *http.Request, reader io.Reader) (
 func (s *Strategy) handleBundleRequest(w http.ResponseWriter, r *http.Request, host, pathValue string) { //nolint:funlen
 	start := time.Now()
 	repoPath := ExtractRepoPath(strings.TrimSuffix(pathValue, "/snapshot.bundle"))
-	upstreamURL := "https://" + host + "/" + repoPath
+	upstreamURL := s.cloneManager.UpstreamURL(host, repoPath)
 	repoName := host + "/" + repoPath
 
 	ctx, span := tracer.Start(r.Context(), "git.bundle.serve",
@@ -1058,9 +1060,7 @@ func (s *Strategy) writeSnapshotSpool(w http.ResponseWriter, r *http.Request, re
 		}
 	}()
 
-	if s.config.SnapshotInterval > 0 {
-		s.scheduleSnapshotJobs(repo)
-	}
+	s.scheduleSnapshotJobs(repo)
 	return errors.Wrap(streamErr, "stream snapshot to client")
 }
 
@@ -1117,11 +1117,10 @@ func (s *Strategy) scheduleDeferredMirrorRestore(ctx context.Context, repo *gitc
 		repo.MarkReady()
 		logger.InfoContext(ctx, "Deferred mirror restore completed", "upstream", upstream)
 
-		if s.config.SnapshotInterval > 0 {
-			s.scheduleSnapshotJobs(repo)
-		}
-		if s.config.RepackInterval > 0 {
-			s.scheduleRepackJobs(repo)
+		s.scheduleSnapshotJobs(repo)
+		s.scheduleRepackJobs(repo)
+		if s.config.ScrubInterval > 0 {
+			s.scheduleScrubJobs(repo)
 		}
 		return nil
 	})
@@ -1329,7 +1328,7 @@ func (s *Strategy) handleLFSSnapshotRequest(w http.ResponseWriter, r *http.Reque
 	logger := logging.FromContext(ctx)
 
 	repoPath := ExtractRepoPath(strings.TrimSuffix(pathValue, "/lfs-snapshot.tar.zst"))
-	upstreamURL := "https://" + host + "/" + repoPath
+	upstreamURL := s.cloneManager.UpstreamURL(host, repoPath)
 	cacheKey := lfsSnapshotCacheKey(upstreamURL)
 
 	// Try cache first so we can serve even when the mirror isn't ready (cold start).
@@ -0,0 +1,307 @@
+package git
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+// maxNegotiationBytes bounds the upload-pack request bodies considered for
+// the response cache. Larger bodies are incremental fetches with long have
+// lists, which are unlikely to repeat.
+const maxNegotiationBytes = 1 << 20
+
+// uploadPackCacheEnabled reports whether complete upload-pack responses are
+// cached for reuse by identical negotiations.
+func (s *Strategy) uploadPackCacheEnabled() bool {
+	return s.cache != nil && s.config.UploadPackCacheTTL > 0
+}
+
+func uploadPackCacheKey(upstreamURL, negotiation string) cache.Key {
+	return cache.NewKey(upstreamURL + ".upload-pack." + negotiation)
+}
+
+// refsFingerprint is the hash of a mirror's refs as of its last fetch.
+type refsFingerprint struct {
+	lastFetch time.Time
+	hash      string
+}
+
+// mirrorRefsFingerprint returns a hash of the mirror's refs. Refs only change
+// when the mirror is cloned or fetched, so the hash is recomputed only after
+// LastFetch moves.
+func (s *Strategy) mirrorRefsFingerprint(ctx context.Context, repo *gitclone.Repository) (string, error) {
+	lastFetch := repo.LastFetch()
+	if v, ok := s.refsFingerprints.Load(repo.UpstreamURL()); ok {
+		if fp := v.(refsFingerprint); fp.lastFetch.Equal(lastFetch) { //nolint:errcheck,forcetypeassert
+			return fp.hash, nil
+		}
+	}
+	refs, err := repo.GetLocalRefs(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "list mirror refs")
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	h := sha256.New()
+	for _, name := range names {
+		_, _ = io.WriteString(h, refs[name]+" "+name+"\n")
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	s.refsFingerprints.Store(repo.UpstreamURL(), refsFingerprint{lastFetch: lastFetch, hash: hash})
+	return hash, nil
+}
+
+// NormaliseNegotiation returns a canonical form of an upload-pack request
+// body, or false if the response to it should not be cached. Only requests
+// that end negotiation with "done" are cached, since only those are answered
+// with a pack. Wants, haves, arguments and capabilities are sorted, and the
+// per-client agent and session-id capabilities are dropped, so that clients
+// asking for the same objects produce the same key. gitProtocol is the
+// request's Git-Protocol header.
+func NormaliseNegotiation(body []byte, gitProtocol string) (string, bool) {
+	lines, ok := parsePktLines(body)
+	if !ok {
+		return "", false
+	}
+	var capabilities, wants, haves, args []string
+	done := false
+	v2 := strings.Contains(gitProtocol, "version=2")
+	for i, line := range lines {
+		switch {
+		case line == "done":
+			done = true
+		case strings.HasPrefix(line, "command="):
+			if line != "command=fetch" {
+				return "", false
+			}
+		case strings.HasPrefix(line, "want "):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				return "", false
+			}
+			wants = append(wants, fields[1])
+			// Protocol v0/v1 carries capabilities on the first want line.
+			if !v2 && i == 0 {
+				capabilities = append(capabilities, fields[2:]...)
+			}
+		case strings.HasPrefix(line, "have "):
+			haves = append(haves, strings.TrimPrefix(line, "have "))
+		case v2 && strings.Contains(line, "=") && !strings.Contains(line, " "):
+			capabilities = append(capabilities, line)
+		default:
+			args = append(args, line)
+		}
+	}
+	if !done || len(wants) == 0 {
+		return "", false
+	}
+	capabilities = slices.DeleteFunc(capabilities, func(c string) bool {
+		return strings.HasPrefix(c, "agent=") || strings.HasPrefix(c, "session-id=")
+	})
+	var b strings.Builder
+	b.WriteString("protocol " + gitProtocol + "\n")
+	for _, section := range []struct {
+		name  string
+		items []string
+	}{{"capability", capabilities}, {"want", wants}, {"have", haves}, {"arg", args}} {
+		slices.Sort(section.items)
+		for _, item := range slices.Compact(section.items) {
+			b.WriteString(section.name + " " + item + "\n")
+		}
+	}
+	return b.String(), true
+}
+
+// parsePktLines splits a pkt-line stream into its data lines, dropping flush,
+// delimiter and response-end packets and trailing newlines.
+func parsePktLines(body []byte) ([]string, bool) {
+	var lines []string
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, false
+		}
+		n, err := strconv.ParseUint(string(body[:4]), 16, 16)
+		if err != nil {
+			return nil, false
+		}
+		if n < 4 {
+			body = body[4:]
+			continue
+		}
+		if int(n) > len(body) {
+			return nil, false
+		}
+		lines = append(lines, strings.TrimSuffix(string(body[4:n]), "\n"))
+		body = body[n:]
+	}
+	return lines, true
+}
+
+// uploadPackNegotiationKey returns the cache key for the response to an
+// upload-pack request, or false if the response shouldn't be cached. body is
+// the buffered request body.
+func (s *Strategy) uploadPackNegotiationKey(ctx context.Context, r *http.Request, repo *gitclone.Repository, body []byte) (cache.Key, bool) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.PathValue("path"), "/git-upload-pack") || len(body) > maxNegotiationBytes {
+		return cache.Key{}, false
+	}
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return cache.Key{}, false
+		}
+		decoded, err := io.ReadAll(io.LimitReader(gr, maxNegotiationBytes+1))
+		_ = gr.Close() //nolint:errcheck
+		if err != nil || len(decoded) > maxNegotiationBytes {
+			return cache.Key{}, false
+		}
+		body = decoded
+	}
+	negotiation, ok := NormaliseNegotiation(body, r.Header.Get("Git-Protocol"))
+	if !ok {
+		return cache.Key{}, false
+	}
+	refs, err := s.mirrorRefsFingerprint(ctx, repo)
+	if err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Skipping upload-pack cache", "upstream", repo.UpstreamURL(), "error", err)
+		return cache.Key{}, false
+	}
+	h := sha256.Sum256([]byte("refs " + refs + "\n" + negotiation))
+	return uploadPackCacheKey(repo.UpstreamURL(), hex.EncodeToString(h[:])), true
+}
+
+// serveCachedUploadPack writes a cached upload-pack response, returning false
+// if there is none.
+func (s *Strategy) serveCachedUploadPack(ctx context.Context, w http.ResponseWriter, key cache.Key, upstream string) bool {
+	reader, headers, err := s.cache.Open(ctx, key)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to open cached upload-pack response", "upstream", upstream, "error", err)
+		}
+		return false
+	}
+	defer reader.Close()
+	for _, name := range []string{"Content-Type", "Cache-Control"} {
+		if v := headers.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Failed to serve cached upload-pack response", "upstream", upstream, "error", err)
+	}
+	return true
+}
+
+// uploadPackCacheWriter tees a successful upload-pack response into the
+// cache while it streams to the client. The entry is committed by finish
+// only if the response completed with a trailing flush packet.
+type uploadPackCacheWriter struct {
+	http.ResponseWriter
+	ctx     context.Context //nolint:containedctx // scoped to a single request
+	s       *Strategy
+	key     cache.Key
+	cw      cache.Writer
+	code    int
+	written int64
+	tail    []byte
+	err     error
+}
+
+func (s *Strategy) newUploadPackCacheWriter(ctx context.Context, w http.ResponseWriter, key cache.Key) *uploadPackCacheWriter {
+	return &uploadPackCacheWriter{ResponseWriter: w, ctx: ctx, s: s, key: key}
+}
+
+func (w *uploadPackCacheWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+		if code == http.StatusOK {
+			headers := http.Header{}
+			for _, name := range []string{"Content-Type", "Cache-Control"} {
+				if v := w.Header().Get(name); v != "" {
+					headers.Set(name, v)
+				}
+			}
+			w.cw, w.err = w.s.cache.Create(w.ctx, w.key, headers, w.s.config.UploadPackCacheTTL)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *uploadPackCacheWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if w.cw == nil || w.err != nil {
+		return n, err //nolint:wrapcheck
+	}
+	if err != nil {
+		w.err = errors.Wrap(err, "write to client")
+		return n, w.err
+	}
+	w.written += int64(n)
+	if limit := int64(w.s.config.UploadPackCacheMaxSizeMB) << 20; limit > 0 && w.written > limit {
+		w.err = errors.Errorf("response exceeds %d MB", w.s.config.UploadPackCacheMaxSizeMB)
+		return n, nil
+	}
+	if _, err := w.cw.Write(p[:n]); err != nil {
+		w.err = errors.Wrap(err, "write to cache")
+		return n, nil
+	}
+	w.tail = append(w.tail, p[:n]...)
+	if len(w.tail) > 4 {
+		w.tail = w.tail[len(w.tail)-4:]
+	}
+	return n, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *uploadPackCacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish commits the cached response if it is complete, and discards it
+// otherwise. Responses that fell back to upstream are never committed.
+func (w *uploadPackCacheWriter) finish(fellBack bool) {
+	if w.cw == nil {
+		return
+	}
+	err := w.err
+	switch {
+	case err != nil:
+	case fellBack:
+		err = errors.New("fell back to upstream")
+	case string(w.tail) != "0000":
+		err = errors.New("response did not end with a flush packet")
+	}
+	logger := logging.FromContext(w.ctx)
+	if err != nil {
+		_ = w.cw.Abort(err) //nolint:errcheck
+		logger.DebugContext(w.ctx, "Discarded upload-pack response from cache", "error", err)
+		return
+	}
+	if err := w.cw.Close(); err != nil {
+		logger.WarnContext(w.ctx, "Failed to cache upload-pack response", "error", err)
+		return
+	}
+	w.s.metrics.recordUploadPackCache(w.ctx, "stored")
+}
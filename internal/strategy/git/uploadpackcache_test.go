@@ -0,0 +1,136 @@
+package git_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func pkt(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
+
+func TestNormaliseNegotiation(t *testing.T) {
+	const a = "1111111111111111111111111111111111111111"
+	const b = "2222222222222222222222222222222222222222"
+
+	v2 := func(agent string, lines ...string) string {
+		body := pkt("command=fetch\n") + pkt("agent="+agent+"\n") + pkt("object-format=sha1\n") + "0001"
+		for _, line := range lines {
+			body += pkt(line + "\n")
+		}
+		return body + "0000"
+	}
+
+	t.Run("V2IgnoresAgentAndOrder", func(t *testing.T) {
+		first, ok := git.NormaliseNegotiation([]byte(v2("git/2.39", "thin-pack", "want "+a, "want "+b, "done")), "version=2")
+		assert.True(t, ok)
+		second, ok := git.NormaliseNegotiation([]byte(v2("git/2.45", "want "+b, "want "+a, "thin-pack", "done")), "version=2")
+		assert.True(t, ok)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("V2DistinguishesFilterAndHaves", func(t *testing.T) {
+		plain, _ := git.NormaliseNegotiation([]byte(v2("git", "want "+a, "done")), "version=2")
+		filtered, ok := git.NormaliseNegotiation([]byte(v2("git", "want "+a, "filter blob:none", "done")), "version=2")
+		assert.True(t, ok)
+		assert.NotEqual(t, plain, filtered)
+		incremental, ok := git.NormaliseNegotiation([]byte(v2("git", "want "+a, "have "+b, "done")), "version=2")
+		assert.True(t, ok)
+		assert.NotEqual(t, plain, incremental)
+	})
+
+	t.Run("V0CapabilitiesOnFirstWant", func(t *testing.T) {
+		body := func(agent string) string {
+			return pkt("want "+a+" side-band-64k ofs-delta agent="+agent+"\n") + pkt("want "+b+"\n") + "0000" + pkt("done\n")
+		}
+		first, ok := git.NormaliseNegotiation([]byte(body("git/2.39")), "")
+		assert.True(t, ok)
+		second, ok := git.NormaliseNegotiation([]byte(body("git/2.45")), "")
+		assert.True(t, ok)
+		assert.Equal(t, first, second)
+		assert.Contains(t, first, "capability side-band-64k\n")
+	})
+
+	t.Run("NotCacheable", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"LsRefs":    pkt("command=ls-refs\n") + "0001" + pkt("peel\n") + "0000",
+			"NoDone":    v2("git", "want "+a, "have "+b),
+			"NoWants":   v2("git", "done"),
+			"Malformed": "zzzzwant",
+		} {
+			_, ok := git.NormaliseNegotiation([]byte(body), "version=2")
+			assert.False(t, ok, name)
+		}
+	})
+}
+
+func TestUploadPackResponseCache(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	mirrorRoot := t.TempDir()
+	mirrorPath := filepath.Join(mirrorRoot, "github.com", "org", "repo")
+	createTestMirrorRepo(t, mirrorPath)
+	origin := gitOutput(t, "-C", mirrorPath, "config", "remote.origin.url")
+
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	mux := newTestMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+	s, err := git.New(ctx, git.Config{UploadPackCacheTTL: time.Hour}, newTestScheduler(ctx, t), memCache, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	head := gitOutput(t, "-C", mirrorPath, "rev-parse", "HEAD")
+	clone := func(agent string) string {
+		body := pkt("want "+head+" side-band-64k ofs-delta agent="+agent+"\n") + "0000" + pkt("done\n")
+		req := httptest.NewRequest(http.MethodPost, "/git/github.com/org/repo.git/git-upload-pack", strings.NewReader(body))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+		req.SetPathValue("host", "github.com")
+		req.SetPathValue("path", "org/repo.git/git-upload-pack")
+		w := httptest.NewRecorder()
+		mux.handlers["POST /git/{host}/{path...}"].ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasSuffix(w.Body.String(), "0000"))
+		return w.Body.String()
+	}
+	objects := func() int64 {
+		stats, err := memCache.Stats(ctx)
+		assert.NoError(t, err)
+		return stats.Objects
+	}
+
+	first := clone("git/2.39")
+	assert.Equal(t, int64(1), objects())
+
+	// A different client agent negotiating the same objects is served the
+	// cached response.
+	assert.Equal(t, first, clone("git/2.45"))
+	assert.Equal(t, int64(1), objects())
+
+	// Fetching new refs into the mirror invalidates earlier responses.
+	gitOutput(t, "-C", origin, "commit", "--allow-empty", "-m", "update")
+	manager, err := cm()
+	assert.NoError(t, err)
+	assert.NoError(t, manager.Get("https://github.com/org/repo").Fetch(ctx))
+	clone("git/2.39")
+	assert.Equal(t, int64(2), objects())
+}
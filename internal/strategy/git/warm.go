@@ -0,0 +1,192 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+const defaultWarmInterval = 15 * time.Minute
+
+// warmRepo is a repository kept cloned whether or not clients have asked
+// for it. Critical repositories gate readiness.
+type warmRepo struct {
+	upstreamURL string
+	critical    bool
+}
+
+func (c Config) warmEnabled() bool {
+	return len(c.WarmRepos) > 0 || len(c.CriticalRepos) > 0 || c.WarmManifest != ""
+}
+
+// normalizeWarmRepo turns a configured repository ("github.com/org/repo",
+// optionally with a scheme or .git suffix) into the upstream URL used to key
+// mirrors.
+func (s *Strategy) normalizeWarmRepo(repo string) string {
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+	if strings.Contains(repo, "://") {
+		return repo
+	}
+	host, repoPath, _ := strings.Cut(repo, "/")
+	return s.cloneManager.UpstreamURL(host, repoPath)
+}
+
+// parseWarmManifest parses a warm manifest: one repository per line,
+// optionally followed by "critical". Blank lines and "#" comments are
+// ignored.
+func (s *Strategy) parseWarmManifest(r io.Reader) ([]warmRepo, error) {
+	var repos []warmRepo
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1:
+			repos = append(repos, warmRepo{upstreamURL: s.normalizeWarmRepo(fields[0])})
+		case len(fields) == 2 && fields[1] == "critical":
+			repos = append(repos, warmRepo{upstreamURL: s.normalizeWarmRepo(fields[0]), critical: true})
+		default:
+			return nil, errors.Errorf("line %d: expected a repository optionally followed by \"critical\", got %q", line, strings.TrimSpace(text))
+		}
+	}
+	return repos, errors.Wrap(scanner.Err(), "read warm manifest")
+}
+
+// configuredWarmRepos returns the repositories listed in the config.
+func (s *Strategy) configuredWarmRepos() []warmRepo {
+	repos := make([]warmRepo, 0, len(s.config.WarmRepos)+len(s.config.CriticalRepos))
+	for _, repo := range s.config.WarmRepos {
+		repos = append(repos, warmRepo{upstreamURL: s.normalizeWarmRepo(repo)})
+	}
+	for _, repo := range s.config.CriticalRepos {
+		repos = append(repos, warmRepo{upstreamURL: s.normalizeWarmRepo(repo), critical: true})
+	}
+	return repos
+}
+
+// mergeWarmRepos drops duplicate entries. A repository listed more than once
+// is critical if any entry says so.
+func mergeWarmRepos(repos []warmRepo) []warmRepo {
+	index := make(map[string]int, len(repos))
+	var merged []warmRepo
+	for _, repo := range repos {
+		if i, ok := index[repo.upstreamURL]; ok {
+			merged[i].critical = merged[i].critical || repo.critical
+			continue
+		}
+		index[repo.upstreamURL] = len(merged)
+		merged = append(merged, repo)
+	}
+	return merged
+}
+
+func (s *Strategy) fetchWarmManifest(ctx context.Context) ([]warmRepo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.WarmManifest, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create warm manifest request")
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch warm manifest")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetch warm manifest: %s", resp.Status)
+	}
+	repos, err := s.parseWarmManifest(resp.Body)
+	return repos, errors.Wrap(err, "parse warm manifest")
+}
+
+// scheduleWarmJob warms the listed repositories once, then every warm
+// interval. The first pass runs at startup rather than as the periodic job,
+// whose first run the scheduler delays by the time since its last recorded
+// run, so that readiness doesn't wait up to a whole interval for the warm
+// list after a restart.
+func (s *Strategy) scheduleWarmJob(ctx context.Context) {
+	if !s.config.warmEnabled() {
+		return
+	}
+	warmCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	if err := s.warmRepos(warmCtx); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Failed to warm repos", "error", err)
+	}
+	cancel()
+	s.scheduler.SubmitPeriodicJob("warm-repos", "warm-repos", s.config.WarmInterval, s.warmRepos)
+}
+
+// warmRepos reloads the warm list and queues a clone for every listed
+// repository this replica owns that has no mirror yet. Clones restore from a
+// mirror snapshot where one exists and schedule the repository's periodic
+// jobs, exactly as a client-triggered clone would.
+func (s *Strategy) warmRepos(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	repos := s.configuredWarmRepos()
+	if s.config.WarmManifest != "" {
+		manifest, err := s.fetchWarmManifest(ctx)
+		if err != nil {
+			// Keep warming the last loaded list so a manifest outage neither
+			// drops repositories nor wedges readiness.
+			logger.WarnContext(ctx, "Failed to load warm manifest, using the last loaded list", "error", err)
+			if last := s.warmList.Load(); last != nil {
+				manifest = *last
+			}
+		}
+		repos = append(repos, manifest...)
+	}
+	repos = mergeWarmRepos(repos)
+	s.warmList.Store(&repos)
+
+	for _, warm := range repos {
+		if !s.ownership.Owns(warm.upstreamURL) {
+			continue
+		}
+		repo, err := s.cloneManager.GetOrCreate(ctx, warm.upstreamURL)
+		if err != nil {
+			logger.WarnContext(ctx, "Invalid warm repo", "upstream", warm.upstreamURL, "error", err)
+			continue
+		}
+		if repo.State() != gitclone.StateEmpty {
+			continue
+		}
+		logger.InfoContext(ctx, "Warming repo", "upstream", warm.upstreamURL, "critical", warm.critical)
+		// The job ID ends in "clone" so that warm-ups count against the
+		// scheduler's clone concurrency limit.
+		s.scheduler.Submit(warm.upstreamURL, "warm-clone", func(ctx context.Context) error {
+			return errors.Wrap(s.startClone(ctx, repo), "warm")
+		})
+	}
+	return nil
+}
+
+// criticalReposReady reports whether every critical warm repository this
+// replica owns has a ready mirror. It is false until the warm list has been
+// loaded once.
+func (s *Strategy) criticalReposReady() bool {
+	if !s.config.warmEnabled() {
+		return true
+	}
+	repos := s.warmList.Load()
+	if repos == nil {
+		return false
+	}
+	for _, warm := range *repos {
+		if !warm.critical || !s.ownership.Owns(warm.upstreamURL) {
+			continue
+		}
+		repo := s.cloneManager.Get(warm.upstreamURL)
+		if repo == nil || repo.State() != gitclone.StateReady {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package git_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/jobscheduler"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestWarmReposFromManifest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	upstreamPath := filepath.Join(t.TempDir(), "upstream")
+	createTestMirrorRepo(t, upstreamPath)
+	upstreamURL := "file://" + upstreamPath
+
+	manifest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "# Warm repositories\n%s critical\n\n127.0.0.1:1/org/optional  # unreachable\n", upstreamURL)
+	}))
+	defer manifest.Close()
+
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()}, nil)
+	s, err := git.New(ctx, git.Config{WarmManifest: manifest.URL}, newTestScheduler(ctx, t), memCache, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+
+	// The unreachable repository isn't critical, so it must not hold up
+	// readiness.
+	waitForReady(t, s)
+
+	manager, err := cm()
+	assert.NoError(t, err)
+	repo := manager.Get(upstreamURL)
+	assert.True(t, repo != nil)
+	assert.Equal(t, gitclone.StateReady, repo.State())
+	assert.True(t, manager.Get("https://127.0.0.1:1/org/optional") != nil)
+}
+
+func TestWarmReposCriticalGatesReadiness(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()}, nil)
+	config := git.Config{CriticalRepos: []string{"127.0.0.1:1/org/repo"}}
+	s, err := git.New(ctx, config, newTestScheduler(ctx, t), memCache, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	s.SetMetadataStore(nil)
+
+	manager, err := cm()
+	assert.NoError(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for manager.Get("https://127.0.0.1:1/org/repo") == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, manager.Get("https://127.0.0.1:1/org/repo") != nil, "critical repo should be warmed")
+
+	// The clone can never succeed, so the strategy must stay unready.
+	for range 50 {
+		assert.False(t, s.Ready())
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWarmReposRespectCloneConcurrency(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	var inFlight, maxInFlight, requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		requests.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		http.NotFound(w, nil)
+	}))
+	defer upstream.Close()
+
+	schedCtx := logging.ContextWithLogger(t.Context(), logging.FromContext(ctx))
+	sched, err := jobscheduler.New(schedCtx, jobscheduler.Config{Concurrency: 4, MaxCloneConcurrency: 1})
+	assert.NoError(t, err)
+	t.Cleanup(func() { sched.Wait() })
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()}, nil)
+	config := git.Config{WarmRepos: []string{upstream.URL + "/org/a", upstream.URL + "/org/b", upstream.URL + "/org/c"}}
+	_, err = git.New(ctx, config, func() (*jobscheduler.RootScheduler, error) { return sched, nil }, memCache, newTestMux(), cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for requests.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, requests.Load() >= 3, "every warm repo should be cloned")
+	assert.Equal(t, int32(1), maxInFlight.Load(), "warm clones should count against max-clone-concurrency")
+}
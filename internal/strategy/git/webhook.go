@@ -0,0 +1,151 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+)
+
+// GitHubWebhookPath receives GitHub push and create webhooks. It is only
+// registered when webhook-secret is set.
+const GitHubWebhookPath = "/git/webhook/github"
+
+// maxWebhookBodyBytes is GitHub's own cap on webhook payloads.
+const maxWebhookBodyBytes = 25 << 20
+
+// gitHubWebhookEvent is the subset of a push or create event payload the
+// receiver needs.
+type gitHubWebhookEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		HTMLURL       string `json:"html_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+// WebhookResponse is the JSON body returned when a webhook triggered work.
+type WebhookResponse struct {
+	Upstream string   `json:"upstream"`
+	Jobs     []string `json:"jobs"`
+}
+
+func (s *Strategy) registerWebhookHandlers(mux strategy.Mux) {
+	if s.config.WebhookSecret == "" {
+		return
+	}
+	mux.Handle("POST "+GitHubWebhookPath, http.HandlerFunc(s.handleGitHubWebhook))
+}
+
+// handleGitHubWebhook refreshes a mirror as soon as GitHub reports a push or
+// a new branch or tag, rather than waiting for the fetch interval or a
+// client request to notice. In ownership mode, events are forwarded to the
+// repository's owner. Events for repositories that aren't mirrored are
+// acknowledged and ignored.
+func (s *Strategy) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	s.metrics.recordRequest(ctx, "webhook")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if !validGitHubSignature(s.config.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		logger.WarnContext(ctx, "Rejected GitHub webhook with invalid signature", "delivery", r.Header.Get("X-GitHub-Delivery"))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := r.Header.Get("X-GitHub-Event")
+	if eventType != "push" && eventType != "create" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var event gitHubWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	upstreamURL, err := s.webhookUpstreamURL(event.Repository.HTMLURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// In ownership mode only the owner fetches the mirror, so the event is
+	// handed to it. If the owner is down, this replica handles it.
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if s.forwardToOwner(w, r, upstreamURL) {
+		return
+	}
+	repo := s.cloneManager.Get(upstreamURL)
+	if repo == nil || repo.State() != gitclone.StateReady {
+		logger.DebugContext(ctx, "Ignoring GitHub webhook for unmirrored repo", "upstream", upstreamURL, "event", eventType)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	jobs := []string{"fetch"}
+	s.submitFetch(repo)
+	defaultMoved := eventType == "push" && event.Ref == "refs/heads/"+event.Repository.DefaultBranch
+	if defaultMoved && s.config.WebhookSnapshot && s.config.settingsFor(upstreamURL).snapshotInterval > 0 {
+		jobs = append(jobs, snapshotJobBase)
+		s.submitWebhookSnapshot(repo)
+	}
+	logger.InfoContext(ctx, "GitHub webhook submitted mirror refresh", "upstream", upstreamURL, "event", eventType,
+		"ref", event.Ref, "jobs", jobs, "delivery", r.Header.Get("X-GitHub-Delivery"))
+	writeAdminJSON(ctx, w, http.StatusAccepted, WebhookResponse{Upstream: upstreamURL, Jobs: jobs})
+}
+
+// submitWebhookSnapshot regenerates the workstation snapshot after the
+// default branch moved. The snapshot job fetches first, which coalesces with
+// the fetch the webhook already queued.
+func (s *Strategy) submitWebhookSnapshot(repo *gitclone.Repository) {
+	upstream := repo.UpstreamURL()
+	s.scheduler.Submit(upstream, "webhook-"+snapshotJobBase, s.ownerOnly(upstream, func(ctx context.Context) error {
+		if err := s.doFetch(ctx, repo); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Pre-snapshot fetch failed", "upstream", upstream, "error", err)
+		}
+		_, err := s.generateAndUploadSnapshot(ctx, repo)
+		return err
+	}))
+}
+
+// webhookUpstreamURL maps a payload's repository URL onto the mirror's
+// upstream URL.
+func (s *Strategy) webhookUpstreamURL(htmlURL string) (string, error) {
+	parsed, err := url.Parse(htmlURL)
+	if err != nil || parsed.Host == "" {
+		return "", errors.Errorf("invalid repository URL %q", htmlURL)
+	}
+	return s.cloneManager.MirrorUpstreamURL(parsed.Host, strings.Trim(parsed.Path, "/")), nil
+}
+
+// validGitHubSignature checks an X-Hub-Signature-256 header ("sha256=<hex>")
+// against the HMAC-SHA256 of body.
+func validGitHubSignature(secret string, body []byte, header string) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,160 @@
+package git_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestGitHubWebhook(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	mirrorRoot := t.TempDir()
+	mirrorPath := filepath.Join(mirrorRoot, "github.com", "org", "repo")
+	createTestMirrorRepo(t, mirrorPath)
+	origin := gitOutput(t, "-C", mirrorPath, "config", "remote.origin.url")
+
+	mux := newTestMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot}, nil)
+	const secret = "webhook-secret"
+	s, err := git.New(ctx, git.Config{WebhookSecret: secret}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	handler := mux.handlers["POST "+git.GitHubWebhookPath]
+	assert.NotZero(t, handler)
+	send := func(event, body, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, git.GitHubWebhookPath, strings.NewReader(body))
+		req = req.WithContext(ctx)
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", signature)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	gitOutput(t, "-C", origin, "commit", "--allow-empty", "-m", "pushed")
+	head := gitOutput(t, "-C", origin, "rev-parse", "HEAD")
+	branch := gitOutput(t, "-C", origin, "symbolic-ref", "--short", "HEAD")
+	push := `{"ref": "refs/heads/` + branch + `", "repository": {"html_url": "https://github.com/org/repo", "default_branch": "` + branch + `"}}`
+
+	t.Run("InvalidSignature", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("push", push, sign("tampered")).Code)
+		assert.Equal(t, http.StatusUnauthorized, send("push", push, "").Code)
+	})
+
+	t.Run("IgnoredEvent", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send("ping", `{"zen": "hi"}`, sign(`{"zen": "hi"}`)).Code)
+	})
+
+	t.Run("UnmirroredRepo", func(t *testing.T) {
+		body := `{"ref": "refs/heads/main", "repository": {"html_url": "https://github.com/org/other"}}`
+		assert.Equal(t, http.StatusNoContent, send("push", body, sign(body)).Code)
+	})
+
+	t.Run("PushFetches", func(t *testing.T) {
+		w := send("push", push, sign(push))
+		assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"upstream":"https://github.com/org/repo"`)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if out, err := exec.Command("git", "-C", mirrorPath, "rev-parse", "refs/heads/"+branch).Output(); err == nil && strings.TrimSpace(string(out)) == head {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("mirror was not fetched after push webhook")
+	})
+}
+
+func gitOutput(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(cmd.Environ(), "GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@test.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@test.com")
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+	return strings.TrimSpace(string(output))
+}
+
+func TestGitHubWebhookForwardedToOwner(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	const secret = "webhook-secret"
+	var forwarded struct {
+		path, by, signature, body string
+	}
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != git.GitHubWebhookPath {
+			return // Health probes.
+		}
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck
+		forwarded.path = r.URL.Path
+		forwarded.by = r.Header.Get(git.OwnerForwardedHeader)
+		forwarded.signature = r.Header.Get("X-Hub-Signature-256")
+		forwarded.body = string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer owner.Close()
+
+	self := "http://self.invalid:8080"
+	mux := newTestMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()}, nil)
+	s, err := git.New(ctx, git.Config{
+		WebhookSecret: secret,
+		Ownership:     git.OwnershipConfig{Self: self, Peers: []string{self, owner.URL}},
+	}, newTestScheduler(ctx, t), nil, mux, cm, func() (*githubapp.TokenManager, error) { return nil, nil }, nil) //nolint:nilnil
+	assert.NoError(t, err)
+	waitForReady(t, s)
+
+	var repoPath string
+	for i := range 100 {
+		candidate := fmt.Sprintf("org/repo%d", i)
+		if git.OwnerOf(s, "https://github.com/"+candidate) == owner.URL {
+			repoPath = candidate
+			break
+		}
+	}
+	assert.NotZero(t, repoPath)
+
+	body := `{"ref": "refs/heads/main", "repository": {"html_url": "https://github.com/` + repoPath + `", "default_branch": "main"}}`
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, git.GitHubWebhookPath, strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", signature)
+	w := httptest.NewRecorder()
+	mux.handlers["POST "+git.GitHubWebhookPath].ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, git.GitHubWebhookPath, forwarded.path)
+	assert.Equal(t, self, forwarded.by)
+	assert.Equal(t, signature, forwarded.signature)
+	assert.Equal(t, body, forwarded.body)
+}
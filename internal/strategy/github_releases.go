@@ -12,20 +12,34 @@ import (
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/githubapp"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
-func RegisterGitHubReleases(r *Registry, tokenManagerProvider githubapp.TokenManagerProvider) {
+func RegisterGitHubReleases(r *Registry, tokenManagerProvider githubapp.TokenManagerProvider, transportProvider httpclient.TransportProvider) {
 	Register(r, "github-releases", "Caches public and authenticated GitHub releases.", func(ctx context.Context, config GitHubReleasesConfig, cache cache.Cache, mux Mux) (*GitHubReleases, error) {
-		return NewGitHubReleases(ctx, config, cache, mux, tokenManagerProvider)
+		g, err := NewGitHubReleases(ctx, config, cache, mux, tokenManagerProvider)
+		if err != nil {
+			return nil, err
+		}
+		if err := setUpstreamTransport(g, transportProvider, config.UpstreamClient); err != nil {
+			return nil, err
+		}
+		return g, nil
 	})
 }
 
 type GitHubReleasesConfig struct {
-	Token       string   `hcl:"token,optional" help:"GitHub token for authentication."`
-	PrivateOrgs []string `hcl:"private-orgs" help:"List of private GitHub organisations."`
+	Token          string                 `hcl:"token,optional" help:"GitHub token for authentication."`
+	PrivateOrgs    []string               `hcl:"private-orgs" help:"List of private GitHub organisations."`
+	Grace          handler.GraceConfig    `hcl:",embed"`
+	Lease          handler.LeaseConfig    `hcl:",embed"`
+	Negative       handler.NegativeConfig `hcl:",embed"`
+	Slices         handler.SliceConfig    `hcl:",embed"`
+	UpstreamClient *httpclient.Config     `hcl:"upstream,block,optional" help:"HTTP client settings for upstream requests, overriding the global upstream block."`
 }
 
 // The GitHubReleases strategy fetches private (and public) release binaries from GitHub.
@@ -34,6 +48,7 @@ type GitHubReleases struct {
 	cache        cache.Cache
 	client       *http.Client
 	tokenManager *githubapp.TokenManager
+	handler      *handler.Handler
 }
 
 // NewGitHubReleases creates a [Strategy] that fetches private (and public) release binaries from GitHub.
@@ -54,12 +69,12 @@ func NewGitHubReleases(ctx context.Context, config GitHubReleasesConfig, cache c
 	s := &GitHubReleases{
 		config:       config,
 		cache:        cache,
-		client:       http.DefaultClient,
+		client:       &http.Client{},
 		tokenManager: tokenManager,
 	}
 	// eg. https://github.com/alecthomas/chroma/releases/download/v2.21.1/chroma-2.21.1-darwin-amd64.tar.gz
 	// eg. https://github.com/kubernetes-sigs/kustomize/releases/download/kustomize/v5.8.1/kustomize_v5.8.1_linux_amd64.tar.gz
-	h := handler.New(s.client, cache).
+	s.handler = handler.New(s.client, cache).
 		CacheKey(func(r *http.Request) string {
 			org := r.PathValue("org")
 			repo := r.PathValue("repo")
@@ -71,15 +86,30 @@ func NewGitHubReleases(ctx context.Context, config GitHubReleasesConfig, cache c
 			repo := r.PathValue("repo")
 			release, file := splitReleasePath(r.PathValue("rest"))
 			return s.downloadRelease(r.Context(), org, repo, release, file)
-		})
-	mux.Handle("GET /github.com/{org}/{repo}/releases/download/{rest...}", h)
+		}).
+		Grace(config.Grace).
+		Negative(config.Negative).
+		Slices(config.Slices)
+	mux.Handle("GET /github.com/{org}/{repo}/releases/download/{rest...}", s.handler)
 	return s, nil
 }
 
-var _ Strategy = (*GitHubReleases)(nil)
+var (
+	_ Strategy         = (*GitHubReleases)(nil)
+	_ MetadataConsumer = (*GitHubReleases)(nil)
+)
 
 func (g *GitHubReleases) String() string { return "github-releases" }
 
+// SetHTTPTransport overrides the HTTP transport used for upstream requests.
+func (g *GitHubReleases) SetHTTPTransport(t http.RoundTripper) {
+	g.client.Transport = t
+}
+
+func (g *GitHubReleases) SetMetadataStore(store *metadatadb.Store) {
+	setFillLeases(g.handler, store, g.config.Lease)
+}
+
 // splitReleasePath splits "release-tag/file.tar.gz" into the release tag and filename.
 // Supports release tags with slashes (e.g. "kustomize/v5.8.1/file.tar.gz").
 func splitReleasePath(rest string) (release, file string) {
@@ -14,6 +14,7 @@ import (
 	"github.com/block/cachew/internal/gitclone"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy"
+	"github.com/block/cachew/internal/strategy/handler"
 )
 
 func Register(r *strategy.Registry, cloneManager gitclone.ManagerProvider) {
@@ -23,8 +24,9 @@ func Register(r *strategy.Registry, cloneManager gitclone.ManagerProvider) {
 }
 
 type Config struct {
-	Proxy        string   `hcl:"proxy,optional" help:"Upstream Go module proxy URL (defaults to proxy.golang.org)" default:"https://proxy.golang.org"`
-	PrivatePaths []string `hcl:"private-paths,optional" help:"Module path patterns for private repositories"`
+	Proxy        string                 `hcl:"proxy,optional" help:"Upstream Go module proxy URL (defaults to proxy.golang.org)" default:"https://proxy.golang.org"`
+	PrivatePaths []string               `hcl:"private-paths,optional" help:"Module path patterns for private repositories"`
+	Negative     handler.NegativeConfig `hcl:",embed"`
 }
 
 type Strategy struct {
@@ -80,6 +82,10 @@ func New(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux
 		s.logger.InfoContext(ctx, "Configured private module support", "private-paths", config.PrivatePaths)
 	}
 
+	if config.Negative.NegativeTTL > 0 {
+		fetcher = NewNegativeFetcher(fetcher, cache, config.Negative.NegativeTTL)
+	}
+
 	s.goproxy = &goproxy.Goproxy{
 		Logger:  s.logger,
 		Fetcher: fetcher,
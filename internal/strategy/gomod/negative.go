@@ -0,0 +1,115 @@
+package gomod
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"github.com/goproxy/goproxy"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+// negativeKeyPrefix distinguishes negative cache entries from the goproxy
+// cacher's, which are keyed by module paths that cannot contain a colon.
+const negativeKeyPrefix = "negative:"
+
+// NegativeFetcher caches not-found errors from a fetcher, so that repeated
+// lookups of missing modules and versions (e.g. `@v/list` probes) are
+// answered without reaching the upstream until the TTL expires. Entries carry
+// the handler.NegativeHeader like those of the HTTP caching strategies.
+type NegativeFetcher struct {
+	fetcher goproxy.Fetcher
+	cache   cache.Cache
+	ttl     time.Duration
+}
+
+func NewNegativeFetcher(fetcher goproxy.Fetcher, cache cache.Cache, ttl time.Duration) *NegativeFetcher {
+	return &NegativeFetcher{
+		fetcher: fetcher,
+		cache:   cache,
+		ttl:     ttl,
+	}
+}
+
+func (n *NegativeFetcher) Query(ctx context.Context, path, query string) (version string, t time.Time, err error) {
+	name := "query:" + path + "@" + query
+	if err := n.cached(ctx, name); err != nil {
+		return "", time.Time{}, err
+	}
+	version, t, err = n.fetcher.Query(ctx, path, query)
+	n.record(ctx, name, err)
+	return version, t, errors.WithStack(err)
+}
+
+func (n *NegativeFetcher) List(ctx context.Context, path string) (versions []string, err error) {
+	name := "list:" + path
+	if err := n.cached(ctx, name); err != nil {
+		return nil, err
+	}
+	versions, err = n.fetcher.List(ctx, path)
+	n.record(ctx, name, err)
+	return versions, errors.WithStack(err)
+}
+
+func (n *NegativeFetcher) Download(ctx context.Context, path, version string) (info, mod, zip io.ReadSeekCloser, err error) {
+	name := "download:" + path + "@" + version
+	if err := n.cached(ctx, name); err != nil {
+		return nil, nil, nil, err
+	}
+	info, mod, zip, err = n.fetcher.Download(ctx, path, version)
+	n.record(ctx, name, err)
+	return info, mod, zip, errors.WithStack(err)
+}
+
+// cached returns the negatively cached error for name, if any.
+func (n *NegativeFetcher) cached(ctx context.Context, name string) error {
+	rc, _, err := n.cache.Open(ctx, cache.NewKey(negativeKeyPrefix+name))
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	msg, err := io.ReadAll(io.LimitReader(rc, handler.NegativeMaxBody))
+	if err != nil {
+		return nil
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "Negative cache hit", "name", name)
+	return &notFoundError{msg: string(msg)}
+}
+
+// record negatively caches err for name if it is a not-found error. Timeouts
+// and upstream failures, which goproxy also reports as not found, are not
+// cached.
+func (n *NegativeFetcher) record(ctx context.Context, name string, err error) {
+	if err == nil || !errors.Is(err, fs.ErrNotExist) || ctx.Err() != nil {
+		return
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "bad upstream") || strings.Contains(msg, "fetch timed out") {
+		return
+	}
+	headers := http.Header{}
+	headers.Set(handler.NegativeHeader, strconv.Itoa(http.StatusNotFound))
+	err = cache.WriteFunc(ctx, n.cache, cache.NewKey(negativeKeyPrefix+name), headers, n.ttl, func(w io.Writer) error {
+		_, err := io.WriteString(w, msg[:min(len(msg), handler.NegativeMaxBody)])
+		return errors.WithStack(err)
+	})
+	if err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Failed to cache negative response", "name", name, "error", err)
+	}
+}
+
+// notFoundError is a negatively cached not-found error, which goproxy
+// answers with a 404.
+type notFoundError struct{ msg string }
+
+func (e *notFoundError) Error() string { return e.msg }
+
+func (e *notFoundError) Is(target error) bool { return target == fs.ErrNotExist }
@@ -0,0 +1,86 @@
+package gomod_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/gomod"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+type countingFetcher struct {
+	err   error
+	calls int
+}
+
+func (f *countingFetcher) Query(context.Context, string, string) (string, time.Time, error) {
+	f.calls++
+	return "", time.Time{}, f.err
+}
+
+func (f *countingFetcher) List(context.Context, string) ([]string, error) {
+	f.calls++
+	return nil, f.err
+}
+
+func (f *countingFetcher) Download(context.Context, string, string) (io.ReadSeekCloser, io.ReadSeekCloser, io.ReadSeekCloser, error) {
+	f.calls++
+	return nil, nil, nil, f.err
+}
+
+func TestNegativeFetcher(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	newFetcher := func(t *testing.T, err error) (*gomod.NegativeFetcher, *countingFetcher, cache.Cache) {
+		t.Helper()
+		memCache, cerr := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+		assert.NoError(t, cerr)
+		t.Cleanup(func() { _ = memCache.Close() })
+		inner := &countingFetcher{err: err}
+		return gomod.NewNegativeFetcher(inner, memCache, time.Minute), inner, memCache
+	}
+
+	t.Run("CachesNotFound", func(t *testing.T) {
+		notFound := errors.Errorf("not found: module example.com/missing: %w", fs.ErrNotExist)
+		fetcher, inner, memCache := newFetcher(t, notFound)
+
+		for range 2 {
+			_, err := fetcher.List(ctx, "example.com/missing")
+			assert.IsError(t, err, fs.ErrNotExist)
+			assert.Contains(t, err.Error(), "module example.com/missing")
+			_, _, _, err = fetcher.Download(ctx, "example.com/missing", "v1.0.0")
+			assert.IsError(t, err, fs.ErrNotExist)
+		}
+		assert.Equal(t, 2, inner.calls)
+
+		headers, err := memCache.Stat(ctx, cache.NewKey("negative:list:example.com/missing"))
+		assert.NoError(t, err)
+		assert.Equal(t, "404", headers.Get(handler.NegativeHeader))
+	})
+
+	t.Run("OtherErrorsNotCached", func(t *testing.T) {
+		fetcher, inner, _ := newFetcher(t, errors.New("connection refused"))
+		for range 2 {
+			_, _, err := fetcher.Query(ctx, "example.com/missing", "latest")
+			assert.Error(t, err)
+		}
+		assert.Equal(t, 2, inner.calls)
+	})
+
+	t.Run("TimeoutsNotCached", func(t *testing.T) {
+		fetcher, inner, _ := newFetcher(t, errors.Errorf("fetch timed out: %w", fs.ErrNotExist))
+		for range 2 {
+			_, err := fetcher.List(ctx, "example.com/slow")
+			assert.IsError(t, err, fs.ErrNotExist)
+		}
+		assert.Equal(t, 2, inner.calls)
+	})
+}
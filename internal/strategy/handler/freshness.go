@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Internal headers recorded alongside cached responses. They are stripped
+// before a cached response is served.
+const (
+	// freshUntilHeader records when a response with explicit upstream
+	// freshness becomes stale. Responses without one stay fresh until the
+	// cache evicts them.
+	freshUntilHeader = "X-Cachew-Fresh-Until"
+	// upstreamETagHeader preserves the upstream's ETag for revalidation, as
+	// the cache replaces ETag with its own.
+	upstreamETagHeader = "X-Cachew-Upstream-Etag"
+	// varyHeader marks an entry as a placeholder listing the request headers
+	// the upstream varies on. The response itself is stored under a key that
+	// includes their values.
+	varyHeader = "X-Cachew-Vary"
+)
+
+var internalHeaders = []string{ //nolint:gochecknoglobals
+	freshUntilHeader,
+	upstreamETagHeader,
+	varyHeader,
+	NegativeHeader,
+	sliceSizeHeader,
+	objectSizeHeader,
+	sliceGenerationHeader,
+	sliceValidatorHeader,
+}
+
+// revalidationHeaders are client request headers that are not forwarded when
+// revalidating a stale entry, as they apply to the cached copy rather than
+// the upstream.
+var revalidationHeaders = []string{ //nolint:gochecknoglobals
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+	"Range",
+}
+
+// notModifiedSkipHeaders are 304 response headers that must not replace the
+// stored response's headers (RFC 9111 section 3.2).
+var notModifiedSkipHeaders = []string{ //nolint:gochecknoglobals
+	"Content-Length",
+	"Content-Encoding",
+	"Content-Range",
+	"Transfer-Encoding",
+}
+
+// freshness is the caching policy an upstream response declares.
+type freshness struct {
+	// store is false if the response must not be cached.
+	store bool
+	// explicit is true if the response declares its own lifetime.
+	explicit bool
+	lifetime time.Duration
+}
+
+// responseFreshness evaluates the Cache-Control, Expires, Date, Age and Vary
+// headers of an upstream response as a shared cache would (RFC 9111 section
+// 4.2.1).
+func responseFreshness(header http.Header, now time.Time) freshness {
+	directives := parseCacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return freshness{}
+	}
+	if _, ok := directives["private"]; ok {
+		return freshness{}
+	}
+	if slices.Contains(varyNames(header), "*") {
+		return freshness{}
+	}
+
+	f := freshness{store: true, explicit: true}
+	if _, ok := directives["no-cache"]; ok {
+		return f
+	}
+	switch {
+	case directives["s-maxage"] != "":
+		f.lifetime = parseSeconds(directives["s-maxage"])
+	case directives["max-age"] != "":
+		f.lifetime = parseSeconds(directives["max-age"])
+	case header.Get("Expires") != "":
+		// An invalid Expires means already expired.
+		expires, err := http.ParseTime(header.Get("Expires"))
+		if err != nil {
+			return f
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		f.lifetime = expires.Sub(date)
+	default:
+		return freshness{store: true}
+	}
+	if age := parseSeconds(header.Get("Age")); age > 0 {
+		f.lifetime -= age
+	}
+	f.lifetime = max(f.lifetime, 0)
+	return f
+}
+
+// parseCacheControl returns the lower-cased directives of a Cache-Control
+// header, mapped to their unquoted arguments.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for directive := range strings.SplitSeq(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// parseSeconds parses a delta-seconds value, returning 0 if it is invalid.
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(min(seconds, int64(1<<31))) * time.Second
+}
+
+// varyNames returns the canonical request header names listed in a response's
+// Vary header, sorted and deduplicated.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for name := range strings.SplitSeq(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// isStale reports whether a cached entry's explicit freshness has lapsed.
+func isStale(headers http.Header, now time.Time) bool {
+	value := headers.Get(freshUntilHeader)
+	if value == "" {
+		return false
+	}
+	freshUntil, err := time.Parse(time.RFC3339Nano, value)
+	return err != nil || !now.Before(freshUntil)
+}
+
+// cacheHeaders returns the headers to store for an upstream response,
+// recording its freshness and validators.
+func cacheHeaders(header http.Header, f freshness, now time.Time) http.Header {
+	stored := header.Clone()
+	for _, name := range internalHeaders {
+		stored.Del(name)
+	}
+	if etag := header.Get("ETag"); etag != "" {
+		stored.Set(upstreamETagHeader, etag)
+	}
+	// The lifetime already accounts for Age, which is stale once stored.
+	stored.Del("Age")
+	setFreshUntil(stored, f, now)
+	return stored
+}
+
+// setFreshUntil records when an entry with explicit freshness becomes stale.
+func setFreshUntil(headers http.Header, f freshness, now time.Time) {
+	if f.explicit {
+		headers.Set(freshUntilHeader, now.Add(f.lifetime).UTC().Format(time.RFC3339Nano))
+	} else {
+		headers.Del(freshUntilHeader)
+	}
+}
+
+// setValidators makes req a conditional request for the cached entry
+// described by stored.
+func setValidators(req *http.Request, stored http.Header) {
+	if etag := stored.Get(upstreamETagHeader); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := stored.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// mergeNotModified updates stored headers with those from a 304 response,
+// returning the updated headers and their freshness.
+func mergeNotModified(stored, notModified http.Header) (http.Header, freshness) {
+	merged := stored.Clone()
+	merged.Del("Age")
+	for name, values := range notModified {
+		if slices.Contains(notModifiedSkipHeaders, name) || slices.Contains(internalHeaders, name) {
+			continue
+		}
+		merged[name] = values
+	}
+	if etag := notModified.Get("ETag"); etag != "" {
+		merged.Set(upstreamETagHeader, etag)
+	}
+	f := responseFreshness(merged, time.Now())
+	merged.Del("Age")
+	return merged, f
+}
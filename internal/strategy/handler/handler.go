@@ -1,12 +1,15 @@
 package handler
 
 import (
+	"context"
 	"io"
 	"maps"
 	"net/http"
 	"os"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alecthomas/errors"
@@ -67,6 +70,15 @@ type Handler struct {
 	transformFunc func(*http.Request) (*http.Request, error)
 	errorHandler  func(error, http.ResponseWriter, *http.Request)
 	ttlFunc       func(*http.Request) time.Duration
+	grace         GraceConfig
+	negative      NegativeConfig
+	rules         *Rules
+	sliceSize     int64
+	revalidating  sync.Map // cache.Key -> struct{}, for background revalidations in flight
+	spoolsMu      sync.Mutex
+	spools        map[cache.Key]*spool // upstream fetches in flight, for coalescing misses
+	leases        *FillLeases
+	leaseWait     time.Duration
 }
 
 // New creates a new Handler with the given HTTP client and cache.
@@ -88,6 +100,7 @@ func New(client *http.Client, c cache.Cache) *Handler {
 		ttlFunc: func(_ *http.Request) time.Duration {
 			return 0
 		},
+		spools: make(map[cache.Key]*spool),
 	}
 }
 
@@ -124,13 +137,74 @@ func (h *Handler) TTL(f func(*http.Request) time.Duration) *Handler {
 	return h
 }
 
+// Grace sets how long past their freshness cached responses may still be
+// served while they are revalidated, or when the upstream fails. Upstream
+// stale-while-revalidate and stale-if-error directives take precedence.
+func (h *Handler) Grace(config GraceConfig) *Handler {
+	h.grace = config
+	return h
+}
+
+// Negative sets how long upstream 404 and 410 responses are cached. By
+// default they are passed through uncached.
+func (h *Handler) Negative(config NegativeConfig) *Handler {
+	h.negative = config
+	return h
+}
+
+// Rules sets caching rules for upstream responses. The first rule matching a
+// response may forbid caching it, or override its freshness and the TTL. By
+// default there are no rules.
+func (h *Handler) Rules(rules *Rules) *Handler {
+	h.rules = rules
+	return h
+}
+
+// Slices enables caching large objects in slices of the configured size.
+// Range requests for objects that are not cached in full fetch and cache only
+// the slices they need, and the whole object is assembled from its slices
+// once it has been sliced.
+func (h *Handler) Slices(config SliceConfig) *Handler {
+	h.sliceSize = int64(config.SliceSizeMB) << 20
+	return h
+}
+
+// FillLeases sets the leases used to coalesce cache misses across replicas,
+// and how long a miss waits for the replica holding a key's lease to cache
+// it before fetching it itself. A nil leases or zero wait disables this.
+func (h *Handler) FillLeases(leases *FillLeases, wait time.Duration) *Handler {
+	h.leases = leases
+	h.leaseWait = wait
+	return h
+}
+
 // ServeHTTP implements http.Handler.
 // The handler will:
-// 1. Determine the cache key using the configured function
-// 2. Check if the content exists in cache
-// 3. If cached, stream from cache
-// 4. If not cached, transform the request and fetch from upstream
-// 5. Cache the response while streaming to the client.
+//  1. Determine the cache key using the configured function
+//  2. Check if the content exists in cache
+//  3. If cached and fresh, stream from cache
+//  4. If cached but stale, serve it within the stale-while-revalidate grace
+//     period and revalidate in the background, or else revalidate with the
+//     upstream and refresh the entry on 304
+//  5. Otherwise, transform the request and fetch from upstream, unless a fetch
+//     for the same key is already in flight, in which case follow its response,
+//     or another replica holds the key's fill lease, in which case wait for it
+//     to cache the response. A cacheable response is fetched in the
+//     background and served from its spool, so that the fetch completes for
+//     followers even if the first client disconnects
+//  6. For a range request, serve it from slices if slicing is enabled, or
+//     else fetch the whole object and serve the range from it as soon as its
+//     bytes arrive, while the object is cached in the background. If the
+//     object turns out not to be cacheable, the range is fetched as is
+//  7. Cache the response while streaming to the client, unless it or a caching
+//     rule forbids storage. 404 and 410 responses are cached only if negative
+//     caching is configured, or a caching rule sets their TTL.
+//
+// Upstream Cache-Control (max-age, s-maxage, no-cache, no-store, private),
+// Expires and Vary headers are honoured. Responses without explicit freshness
+// are fresh for the configured TTL, or until the cache evicts them if there is
+// none. If the upstream fails, a stale response within the stale-if-error
+// grace period is served instead.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -143,65 +217,311 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if ae := r.Header.Get("Accept-Encoding"); ae != "" {
 		parts.Vary["Accept-Encoding"] = ae
 	}
-	key := parts.Key()
 
 	logger.DebugContext(ctx, "Processing request", "cache_key", parts.Path)
 
-	served, err := h.serveCached(w, r, key)
-	if err != nil {
-		logger.ErrorContext(ctx, "Failed to serve from cache", "error", err)
-	}
+	served, key, stale := h.serveCached(w, r, parts)
 	if served {
 		return
 	}
+	if stale != nil && stale.age < stale.whileRevalidate {
+		if h.serveStale(w, r, stale, staleWarning) {
+			h.revalidateInBackground(r, parts, stale)
+			return
+		}
+		stale = nil
+	}
+
+	// Range requests for objects not cached in full are served from slices.
+	if stale == nil && h.serveSliced(w, r, parts) {
+		return
+	}
+
+	// Concurrent misses for a key share one upstream fetch.
+	sp, leader := h.joinSpool(key)
+	if leader {
+		defer func() {
+			if !sp.detached {
+				h.finishSpool(sp)
+			}
+		}()
+	} else {
+		logger.DebugContext(ctx, "Following in-flight upstream fetch")
+		err := sp.serveTo(w, r)
+		if !errors.Is(err, errSpoolUnavailable) {
+			if err != nil {
+				logger.ErrorContext(ctx, "Failed to serve from spool", "error", err)
+			}
+			return
+		}
+		// The leader's response was not spooled, but it may have been cached.
+		if served, _, stale = h.serveCached(w, r, parts); served {
+			return
+		}
+		sp = nil
+	}
+
+	// Concurrent misses across replicas wait for the lease holder to fill
+	// the shared cache.
+	if stale == nil {
+		served, release := h.awaitFill(w, r, parts, key)
+		if served {
+			return
+		}
+		if sp != nil {
+			sp.release = release
+		} else {
+			defer release()
+		}
+	}
 
-	if err := h.fetchAndCache(w, r, key); err != nil {
+	if err := h.fetchAndCache(w, r, parts, stale, sp); err != nil {
 		logger.ErrorContext(ctx, "Failed to fetch and cache", "error", err)
 	}
 }
 
-func (h *Handler) serveCached(w http.ResponseWriter, r *http.Request, key cache.Key) (bool, error) {
-	cr, headers, err := h.cache.Open(r.Context(), key, httputil.ConditionalOptions(r)...)
+// serveCached serves a fresh cached response, following a Vary placeholder
+// to the variant matching the request, and returns the key it looked up. A
+// stale response is not served, and is returned for revalidation instead.
+func (h *Handler) serveCached(w http.ResponseWriter, r *http.Request, parts CacheKeyParts) (served bool, key cache.Key, stale *staleEntry) {
+	ctx := r.Context()
+	key = parts.Key()
+	cr, headers, err := h.cache.Open(ctx, key, httputil.ConditionalOptions(r)...)
+	if names := headers.Values(varyHeader); len(names) > 0 {
+		if cr != nil {
+			_ = cr.Close()
+		}
+		key = variantParts(r, parts, names).Key()
+		cr, headers, err = h.cache.Open(ctx, key, httputil.ConditionalOptions(r)...)
+	}
+	if status := negativeStatus(headers); status != 0 {
+		if cr != nil {
+			_ = cr.Close()
+		}
+		return h.serveNegative(w, r, key, status), key, nil
+	}
+	if headers != nil && isStale(headers, time.Now()) {
+		if cr != nil {
+			_ = cr.Close()
+		}
+		logging.FromContext(ctx).DebugContext(ctx, "Cache entry stale")
+		return false, key, h.newStaleEntry(key, headers, time.Now())
+	}
+	return h.serveEntry(w, r, cr, headers, err), key, nil
+}
+
+// serveEntry serves the result of opening a cache entry, returning false if
+// it does not exist.
+func (h *Handler) serveEntry(w http.ResponseWriter, r *http.Request, cr io.ReadCloser, headers http.Header, err error) bool {
+	for _, name := range internalHeaders {
+		headers.Del(name)
+	}
 	if handled, _, serveErr := httputil.ServeCacheHit(w, headers, cr, err); handled {
-		logging.FromContext(r.Context()).DebugContext(r.Context(), "Cache hit")
-		return true, errors.WithStack(serveErr)
+		logger := logging.FromContext(r.Context())
+		logger.DebugContext(r.Context(), "Cache hit")
+		if serveErr != nil {
+			logger.ErrorContext(r.Context(), "Failed to serve from cache", "error", serveErr)
+		}
+		return true
 	}
 	if errors.Is(err, os.ErrNotExist) {
-		return false, nil
+		return false
 	}
 	h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to open cache: %w", err), w, r)
-	return true, nil
+	return true
 }
 
-func (h *Handler) fetchAndCache(w http.ResponseWriter, r *http.Request, key cache.Key) error {
-	logging.FromContext(r.Context()).DebugContext(r.Context(), "Cache miss, fetching from upstream")
+// fetchAndCache fetches the response from upstream and caches it, teeing a
+// cacheable response into sp if it is set. If stale is set, the request is made
+// conditional on its validators and a 304 refreshes the entry without
+// re-downloading it.
+func (h *Handler) fetchAndCache(w http.ResponseWriter, r *http.Request, parts CacheKeyParts, stale *staleEntry, sp *spool) error {
+	ctx := r.Context()
+	if stale == nil {
+		logging.FromContext(ctx).DebugContext(ctx, "Cache miss, fetching from upstream")
+	} else {
+		logging.FromContext(ctx).DebugContext(ctx, "Revalidating stale cache entry")
+	}
+
+	upstreamReq := r
+	wholeObject := stale == nil && r.Header.Get("Range") != ""
+	switch {
+	case wholeObject:
+		upstreamReq = wholeObjectRequest(r)
+	case sp != nil:
+		// A coalesced fetch is not cancelled with the leader's request, so
+		// that its followers get the whole response if the leader's client
+		// disconnects.
+		upstreamReq = r.Clone(context.WithoutCancel(ctx))
+	}
+	resp, cancel, err := h.fetchForRevalidation(upstreamReq, stale)
+	if err != nil {
+		cancel()
+		if stale != nil && stale.age < stale.ifError && h.serveStale(w, r, stale, revalidationFailedWarning) {
+			logging.FromContext(ctx).WarnContext(ctx, "Serving stale response after upstream error", "error", err)
+			return nil
+		}
+		h.errorHandler(err, w, r)
+		return nil
+	}
+	if wholeObject && (resp.StatusCode != http.StatusOK || !h.cacheable(r, resp)) {
+		// The whole object is only worth fetching if it is cached. Otherwise
+		// the client gets just the range it asked for.
+		cancel()
+		_ = resp.Body.Close()
+		return h.passRangeThrough(w, r)
+	}
+	if sp != nil && resp.StatusCode == http.StatusOK && h.cacheable(r, resp) {
+		return h.serveDetached(w, r, upstreamReq, parts, resp, cancel, sp)
+	}
+	defer cancel()
+	defer resp.Body.Close()
 
-	upstreamReq, err := h.transformFunc(r)
+	if stale != nil {
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			kept, err := h.refresh(ctx, r, stale.key, resp)
+			if err != nil {
+				h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to refresh cache entry: %w", err), w, r)
+				return nil
+			}
+			if !kept {
+				return h.fetchAndCache(w, r, parts, nil, sp)
+			}
+			cr, headers, err := h.cache.Open(ctx, stale.key, httputil.ConditionalOptions(r)...)
+			if !h.serveEntry(w, r, cr, headers, err) {
+				h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "revalidated cache entry disappeared"), w, r)
+			}
+			return nil
+		case resp.StatusCode >= http.StatusInternalServerError && stale.age < stale.ifError:
+			if h.serveStale(w, r, stale, revalidationFailedWarning) {
+				logging.FromContext(ctx).WarnContext(ctx, "Serving stale response after upstream error", "status", resp.StatusCode)
+				return nil
+			}
+		}
+	}
+
+	return h.streamResponse(w, r, parts, resp, sp)
+}
+
+// streamResponse streams an upstream response to the client, caching it if
+// it is cacheable.
+func (h *Handler) streamResponse(w http.ResponseWriter, r *http.Request, parts CacheKeyParts, resp *http.Response, sp *spool) error {
+	if IsNegativeStatus(resp.StatusCode) {
+		return h.streamAndCacheNegative(w, r, parts, resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return h.streamNonOKResponse(w, resp)
+	}
+	return h.streamAndCache(w, r, parts, resp, sp)
+}
+
+// wholeObjectRequest returns a copy of a range request that misses the cache
+// for the whole object, so that it can be cached. The copy is not cancelled
+// with the client's request, as the object may still be being cached once the
+// range has been served.
+func wholeObjectRequest(r *http.Request) *http.Request {
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+	return req
+}
+
+// passRangeThrough fetches a range request as is and passes the upstream's
+// response, typically a 206, through uncached.
+func (h *Handler) passRangeThrough(w http.ResponseWriter, r *http.Request) error {
+	resp, err := h.fetch(r, nil)
 	if err != nil {
 		h.errorHandler(err, w, r)
 		return nil
 	}
+	defer resp.Body.Close()
+	maps.Copy(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	return errors.Wrap(err, "stream range response")
+}
+
+// serveDetached caches the upstream's response to a coalesced miss in the
+// background, and serves it to the leader's client from its spool, as it is
+// served to followers. The background fetch continues if the leader's client
+// disconnects. A single byte range is served as soon as its bytes arrive,
+// while the whole object is cached. cancel is called once the fetch is done.
+func (h *Handler) serveDetached(w http.ResponseWriter, r, upstreamReq *http.Request, parts CacheKeyParts, resp *http.Response, cancel context.CancelFunc, sp *spool) error {
+	ctx := r.Context()
+	logging.FromContext(ctx).DebugContext(ctx, "Fetching response in background")
+	sp.join()
+	sp.detached = true
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		defer h.finishSpool(sp)
+		bgCtx := upstreamReq.Context()
+		if err := h.streamAndCache(&discardResponseWriter{header: http.Header{}}, upstreamReq, parts, resp, sp); err != nil {
+			logging.FromContext(bgCtx).ErrorContext(bgCtx, "Failed to fetch and cache", "error", err)
+		}
+	}()
+	err := sp.serveTo(w, r)
+	if errors.Is(err, errSpoolUnavailable) {
+		h.errorHandler(httputil.Errorf(http.StatusBadGateway, "failed to spool upstream response"), w, r)
+		return nil
+	}
+	return errors.Wrap(err, "serve from spool")
+}
+
+// fetch sends the transformed request upstream. If stale is set, the request
+// is made conditional on its validators.
+func (h *Handler) fetch(r *http.Request, stale *staleEntry) (*http.Response, error) {
+	upstreamReq, err := h.transformFunc(r)
+	if err != nil {
+		return nil, err
+	}
 
 	// Forward safe headers from the original request, without overwriting headers set by transform.
-	forwardable := httputil.FilterHeaders(r.Header, httputil.HopByHopHeaders...)
+	skip := httputil.HopByHopHeaders
+	if stale != nil {
+		skip = append(slices.Clone(skip), revalidationHeaders...)
+	}
+	forwardable := httputil.FilterHeaders(r.Header, skip...)
 	for key, values := range forwardable {
 		if upstreamReq.Header.Get(key) == "" {
 			upstreamReq.Header[key] = values
 		}
 	}
+	if stale != nil {
+		setValidators(upstreamReq, stale.headers)
+	}
 
 	resp, err := h.client.Do(upstreamReq)
 	if err != nil {
-		h.errorHandler(httputil.Errorf(http.StatusBadGateway, "failed to fetch: %w", err), w, r)
-		return nil
+		return nil, httputil.Errorf(http.StatusBadGateway, "failed to fetch: %w", err)
 	}
-	defer resp.Body.Close()
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return h.streamNonOKResponse(w, resp)
-	}
+// refresh updates a revalidated cache entry with the headers of the
+// upstream's 304 response, copying the cached body. It returns false if the
+// upstream no longer permits storing the response, and the entry was deleted.
+func (h *Handler) refresh(ctx context.Context, r *http.Request, key cache.Key, resp *http.Response) (bool, error) {
+	logging.FromContext(ctx).DebugContext(ctx, "Cache entry revalidated")
 
-	return h.streamAndCache(w, r, key, resp)
+	cr, stored, err := h.cache.Open(ctx, key)
+	if err != nil {
+		return false, errors.Wrap(err, "open revalidated cache entry")
+	}
+	defer cr.Close()
+	now := time.Now()
+	headers, f := mergeNotModified(stored, resp.Header)
+	f, ttl := h.storagePolicy(r, http.StatusOK, headers, f)
+	if !f.store {
+		return false, errors.Wrap(h.cache.Delete(ctx, key), "delete uncacheable entry")
+	}
+	setFreshUntil(headers, f, now)
+	err = cache.WriteFunc(ctx, h.cache, key, headers, ttl, func(w io.Writer) error {
+		_, err := io.Copy(w, cr)
+		return errors.WithStack(err)
+	})
+	return true, errors.Wrap(err, "refresh cache entry")
 }
 
 func (h *Handler) streamNonOKResponse(w http.ResponseWriter, resp *http.Response) error {
@@ -212,18 +532,40 @@ func (h *Handler) streamNonOKResponse(w http.ResponseWriter, resp *http.Response
 	return nil
 }
 
-func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, key cache.Key, resp *http.Response) error {
-	ttl := h.ttlFunc(r)
-	responseHeaders := maps.Clone(resp.Header)
-	cw, err := h.cache.Create(r.Context(), key, responseHeaders, ttl)
+func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, parts CacheKeyParts, resp *http.Response, sp *spool) error {
+	ctx := r.Context()
+	now := time.Now()
+	f, ttl := h.storagePolicy(r, resp.StatusCode, resp.Header, responseFreshness(resp.Header, now))
+	if !f.store {
+		logging.FromContext(ctx).DebugContext(ctx, "Upstream response is not cacheable")
+		maps.Copy(w.Header(), resp.Header)
+		_, err := io.Copy(w, resp.Body)
+		return errors.Wrap(err, "stream uncacheable response")
+	}
+
+	key, err := h.variantKey(r, parts, varyNames(resp.Header), ttl)
+	if err != nil {
+		h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to create cache entry: %w", err), w, r)
+		return nil
+	}
+	cw, err := h.cache.Create(ctx, key, cacheHeaders(resp.Header, f, now), ttl)
 	if err != nil {
 		h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to create cache entry: %w", err), w, r)
 		return nil
 	}
 
 	pr, pw := io.Pipe()
+	writers := []io.Writer{pw, cw}
+	if sp != nil {
+		if err := sp.start(resp.Header, spoolVary(r, resp.Header)); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to spool response", "error", err)
+			sp = nil
+		} else {
+			writers = append(writers, sp)
+		}
+	}
 	go func() {
-		mw := io.MultiWriter(pw, cw)
+		mw := io.MultiWriter(writers...)
 		_, copyErr := io.Copy(mw, resp.Body)
 		var closeErr error
 		if copyErr != nil {
@@ -231,6 +573,16 @@ func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, key cac
 		} else {
 			closeErr = cw.Close()
 		}
+		if sp != nil {
+			// Later misses revalidate against the cache, which holds the
+			// response by now, rather than following a finished spool.
+			h.unregisterSpool(sp)
+			if err := errors.Join(copyErr, closeErr); err != nil {
+				sp.fail(err)
+			} else {
+				sp.done()
+			}
+		}
 		pw.CloseWithError(errors.Join(copyErr, closeErr))
 	}()
 
@@ -240,6 +592,45 @@ func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, key cac
 	return errors.Wrap(errors.Join(copyErr, closeErr), "stream and cache response")
 }
 
+// variantKey returns the key to store a response that varies on the request
+// headers in vary. Accept-Encoding is always part of the key, so a response
+// varying on other headers is stored under a key including their values, with
+// a placeholder at the primary key naming them for later lookups.
+func (h *Handler) variantKey(r *http.Request, parts CacheKeyParts, vary []string, ttl time.Duration) (cache.Key, error) {
+	vary = slices.DeleteFunc(vary, func(name string) bool { return name == "Accept-Encoding" })
+	if len(vary) == 0 {
+		return parts.Key(), nil
+	}
+	placeholder := http.Header{varyHeader: vary}
+	if err := cache.WriteFunc(r.Context(), h.cache, parts.Key(), placeholder, ttl, func(io.Writer) error { return nil }); err != nil {
+		return cache.Key{}, errors.Wrap(err, "store vary placeholder")
+	}
+	return variantParts(r, parts, vary).Key(), nil
+}
+
+// spoolVary returns the request's values for the headers other than
+// Accept-Encoding that a response varies on, which followers of its spool
+// must match.
+func spoolVary(r *http.Request, header http.Header) map[string]string {
+	vary := map[string]string{}
+	for _, name := range varyNames(header) {
+		if name != "Accept-Encoding" {
+			vary[name] = r.Header.Get(name)
+		}
+	}
+	return vary
+}
+
+// variantParts extends parts with the values of the request headers in vary.
+func variantParts(r *http.Request, parts CacheKeyParts, vary []string) CacheKeyParts {
+	variant := NewCacheKeyParts(parts.Path)
+	maps.Copy(variant.Vary, parts.Vary)
+	for _, name := range vary {
+		variant.Vary[name] = r.Header.Get(name)
+	}
+	return variant
+}
+
 func defaultErrorHandler(err error, w http.ResponseWriter, r *http.Request) {
 	if h, ok := errors.AsType[httputil.HTTPResponder](err); ok {
 		h.WriteHTTP(w, r)
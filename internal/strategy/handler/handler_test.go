@@ -1,13 +1,19 @@
 package handler_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,6 +23,7 @@ import (
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
@@ -438,6 +445,953 @@ func TestStreamAndCacheAbortsOnUpstreamError(t *testing.T) {
 	assert.IsError(t, err, os.ErrNotExist)
 }
 
+func TestHTTPCaching(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	type upstreamCalls struct {
+		full, notModified int
+	}
+	newHandler := func(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) (*handler.Handler, *upstreamCalls) {
+		t.Helper()
+		calls := &upstreamCalls{}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			respond(rec, r)
+			if rec.Code == http.StatusNotModified {
+				calls.notModified++
+			} else {
+				calls.full++
+			}
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(rec.Body.Bytes())
+		}))
+		t.Cleanup(upstream.Close)
+		h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+			})
+		return h, calls
+	}
+	get := func(h http.Handler, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/file", nil).WithContext(ctx)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("FreshMaxAge", func(t *testing.T) {
+		h, calls := newHandler(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			_, _ = fmt.Fprint(w, "body")
+		})
+		assert.Equal(t, "body", get(h).Body.String())
+		w := get(h)
+		assert.Equal(t, "body", w.Body.String())
+		assert.Equal(t, "", w.Header().Get("X-Cachew-Fresh-Until"))
+		assert.Equal(t, upstreamCalls{full: 1}, *calls)
+	})
+
+	t.Run("RevalidatesStaleEntry", func(t *testing.T) {
+		var validators []string
+		h, calls := newHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			validators = append(validators, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.Header().Set("X-Revalidated", "true")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = fmt.Fprint(w, "body")
+		})
+		assert.Equal(t, "body", get(h).Body.String())
+		// A client's own validators are not forwarded when revalidating.
+		w := get(h, "If-None-Match", `"client"`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "body", w.Body.String())
+		assert.Equal(t, "true", w.Header().Get("X-Revalidated"))
+		assert.Equal(t, "body", get(h).Body.String())
+		assert.Equal(t, upstreamCalls{full: 1, notModified: 2}, *calls)
+		assert.Equal(t, []string{"|", `"v1"|Mon, 02 Jan 2006 15:04:05 GMT`, `"v1"|Mon, 02 Jan 2006 15:04:05 GMT`}, validators)
+	})
+
+	t.Run("RevalidationReplacesChangedEntry", func(t *testing.T) {
+		version := 1
+		h, calls := newHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			etag := fmt.Sprintf(`"v%d"`, version)
+			w.Header().Set("Expires", "Thu, 01 Jan 1970 00:00:00 GMT")
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = fmt.Fprintf(w, "version %d", version)
+		})
+		assert.Equal(t, "version 1", get(h).Body.String())
+		version = 2
+		assert.Equal(t, "version 2", get(h).Body.String())
+		assert.Equal(t, "version 2", get(h).Body.String())
+		assert.Equal(t, upstreamCalls{full: 2, notModified: 1}, *calls)
+	})
+
+	t.Run("NoStoreAndPrivate", func(t *testing.T) {
+		for _, cacheControl := range []string{"no-store", "private, max-age=3600"} {
+			h, calls := newHandler(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Cache-Control", cacheControl)
+				_, _ = fmt.Fprint(w, "body")
+			})
+			assert.Equal(t, "body", get(h).Body.String())
+			assert.Equal(t, "body", get(h).Body.String())
+			assert.Equal(t, upstreamCalls{full: 2}, *calls, cacheControl)
+		}
+	})
+
+	t.Run("Vary", func(t *testing.T) {
+		h, calls := newHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "X-Platform")
+			_, _ = fmt.Fprintf(w, "platform=%s", r.Header.Get("X-Platform"))
+		})
+		assert.Equal(t, "platform=linux", get(h, "X-Platform", "linux").Body.String())
+		assert.Equal(t, "platform=darwin", get(h, "X-Platform", "darwin").Body.String())
+		assert.Equal(t, "platform=linux", get(h, "X-Platform", "linux").Body.String())
+		assert.Equal(t, "platform=", get(h).Body.String())
+		assert.Equal(t, "platform=darwin", get(h, "X-Platform", "darwin").Body.String())
+		assert.Equal(t, upstreamCalls{full: 3}, *calls)
+	})
+}
+
+func TestStaleServing(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	type upstreamState struct {
+		mu      sync.Mutex
+		version int
+		status  int
+		calls   int
+	}
+	newHandler := func(t *testing.T, cacheControl string) (*handler.Handler, *upstreamState, *httptest.Server) {
+		t.Helper()
+		state := &upstreamState{version: 1, status: http.StatusOK}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			state.calls++
+			if state.status == 0 {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+				return
+			}
+			if state.status != http.StatusOK {
+				w.WriteHeader(state.status)
+				return
+			}
+			etag := fmt.Sprintf(`"v%d"`, state.version)
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = fmt.Fprintf(w, "version %d", state.version)
+		}))
+		t.Cleanup(upstream.Close)
+		h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/file", nil)
+			})
+		return h, state, upstream
+	}
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/file", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	set := func(state *upstreamState, version, status int) {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		state.version, state.status = version, status
+	}
+
+	t.Run("StaleWhileRevalidate", func(t *testing.T) {
+		h, state, _ := newHandler(t, "max-age=0")
+		h.Grace(handler.GraceConfig{StaleWhileRevalidate: time.Minute})
+		assert.Equal(t, "version 1", get(h).Body.String())
+		set(state, 2, http.StatusOK)
+
+		w := get(h)
+		assert.Equal(t, "version 1", w.Body.String())
+		assert.Equal(t, `110 - "Response is Stale"`, w.Header().Get("Warning"))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			w = get(h)
+			if w.Body.String() == "version 2" || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, "version 2", w.Body.String())
+	})
+
+	t.Run("UpstreamDirectiveOverridesConfig", func(t *testing.T) {
+		h, state, _ := newHandler(t, "max-age=0, stale-if-error=0")
+		h.Grace(handler.GraceConfig{StaleIfError: time.Minute})
+		assert.Equal(t, "version 1", get(h).Body.String())
+		set(state, 1, http.StatusServiceUnavailable)
+		assert.Equal(t, http.StatusServiceUnavailable, get(h).Code)
+	})
+
+	t.Run("StaleIfError", func(t *testing.T) {
+		h, state, upstream := newHandler(t, "max-age=0")
+		h.Grace(handler.GraceConfig{StaleIfError: time.Minute})
+		assert.Equal(t, "version 1", get(h).Body.String())
+
+		set(state, 1, http.StatusServiceUnavailable)
+		w := get(h)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "version 1", w.Body.String())
+		assert.Equal(t, `111 - "Revalidation Failed"`, w.Header().Get("Warning"))
+
+		upstream.Close()
+		w = get(h)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "version 1", w.Body.String())
+	})
+
+	t.Run("StaleIfErrorOnTimeout", func(t *testing.T) {
+		h, state, _ := newHandler(t, "max-age=0")
+		h.Grace(handler.GraceConfig{StaleIfError: time.Minute, RevalidateTimeout: 50 * time.Millisecond})
+		assert.Equal(t, "version 1", get(h).Body.String())
+		set(state, 1, 0)
+		start := time.Now()
+		w := get(h)
+		assert.Equal(t, "version 1", w.Body.String())
+		assert.True(t, time.Since(start) < 2*time.Second, "revalidation was not abandoned")
+	})
+
+	t.Run("GracePastTTL", func(t *testing.T) {
+		h, state, _ := newHandler(t, "")
+		h.TTL(func(*http.Request) time.Duration { return 50 * time.Millisecond }).
+			Grace(handler.GraceConfig{StaleIfError: time.Minute})
+		assert.Equal(t, "version 1", get(h).Body.String())
+		time.Sleep(100 * time.Millisecond)
+		set(state, 1, http.StatusBadGateway)
+		w := get(h)
+		assert.Equal(t, "version 1", w.Body.String())
+		assert.Equal(t, 2, state.calls)
+	})
+
+	t.Run("NoGrace", func(t *testing.T) {
+		h, state, _ := newHandler(t, "max-age=0")
+		assert.Equal(t, "version 1", get(h).Body.String())
+		set(state, 1, http.StatusServiceUnavailable)
+		assert.Equal(t, http.StatusServiceUnavailable, get(h).Code)
+	})
+}
+
+func TestCoalescesConcurrentMisses(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	run := func(t *testing.T, status int) (calls int32, responses []*httptest.ResponseRecorder) {
+		t.Helper()
+		var upstreamCalls atomic.Int32
+		release := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if upstreamCalls.Add(1) == 1 {
+				w.WriteHeader(status)
+				_, _ = fmt.Fprint(w, "first half, ")
+				w.(http.Flusher).Flush()
+				<-release
+			} else {
+				w.WriteHeader(status)
+				_, _ = fmt.Fprint(w, "first half, ")
+			}
+			_, _ = fmt.Fprint(w, "second half")
+		}))
+		defer upstream.Close()
+		h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/artifact", nil)
+			})
+
+		responses = make([]*httptest.ResponseRecorder, 5)
+		var wg sync.WaitGroup
+		for i := range responses {
+			responses[i] = httptest.NewRecorder()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.ServeHTTP(responses[i], httptest.NewRequest(http.MethodGet, "http://example.com/artifact", nil).WithContext(ctx))
+			}()
+			if i == 0 {
+				for upstreamCalls.Load() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}
+		// Give the followers time to join the leader's fetch.
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return upstreamCalls.Load(), responses
+	}
+
+	t.Run("FollowersShareOneFetch", func(t *testing.T) {
+		calls, responses := run(t, http.StatusOK)
+		assert.Equal(t, int32(1), calls)
+		for _, w := range responses {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "first half, second half", w.Body.String())
+		}
+	})
+
+	t.Run("FollowersFetchUncacheableResponses", func(t *testing.T) {
+		calls, responses := run(t, http.StatusNotFound)
+		assert.Equal(t, int32(5), calls)
+		for _, w := range responses {
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+	})
+}
+
+func TestCoalescedFetchSurvivesLeaderDisconnect(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	var upstreamCalls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls.Add(1)
+		_, _ = fmt.Fprint(w, "first half, ")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = fmt.Fprint(w, "second half")
+	}))
+	defer upstream.Close()
+	c := mustNewMemoryCache()
+	h := handler.New(http.DefaultClient, c).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/artifact", nil)
+		})
+
+	leaderCtx, cancelLeader := context.WithCancel(ctx)
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/artifact", nil).WithContext(leaderCtx))
+	}()
+	for upstreamCalls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	responses := make([]*httptest.ResponseRecorder, 3)
+	var wg sync.WaitGroup
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(responses[i], httptest.NewRequest(http.MethodGet, "http://example.com/artifact", nil).WithContext(ctx))
+		}()
+	}
+	// Give the followers time to join, then disconnect the leader's client
+	// mid-body.
+	time.Sleep(100 * time.Millisecond)
+	cancelLeader()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	<-leaderDone
+
+	assert.Equal(t, int32(1), upstreamCalls.Load())
+	for _, w := range responses {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "first half, second half", w.Body.String())
+	}
+	cr, _, err := c.Open(ctx, handler.NewCacheKeyParts("http://example.com/artifact").Key())
+	assert.NoError(t, err)
+	defer cr.Close()
+	body, err := io.ReadAll(cr)
+	assert.NoError(t, err)
+	assert.Equal(t, "first half, second half", string(body))
+}
+
+func TestNegativeCaching(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	const url = "http://example.com/missing"
+
+	newHandler := func(t *testing.T, config handler.NegativeConfig, respond http.HandlerFunc) (*handler.Handler, cache.Cache, *atomic.Int32) {
+		t.Helper()
+		calls := &atomic.Int32{}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			respond(w, r)
+		}))
+		t.Cleanup(upstream.Close)
+		c := mustNewMemoryCache()
+		h := handler.New(http.DefaultClient, c).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+			}).
+			Negative(config)
+		return h, c, calls
+	}
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx))
+		return w
+	}
+	notFound := func(status int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-Upstream", "yes")
+			w.WriteHeader(status)
+			_, _ = fmt.Fprint(w, body)
+		}
+	}
+	enabled := handler.NegativeConfig{NegativeTTL: time.Minute}
+
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			h, c, calls := newHandler(t, enabled, notFound(status, "no such file"))
+			for range 2 {
+				w := get(h)
+				assert.Equal(t, status, w.Code)
+				assert.Equal(t, "no such file", w.Body.String())
+				assert.Equal(t, "yes", w.Header().Get("X-Upstream"))
+				assert.Equal(t, "", w.Header().Get(handler.NegativeHeader))
+			}
+			assert.Equal(t, int32(1), calls.Load())
+
+			headers, err := c.Stat(ctx, handler.NewCacheKeyParts(url).Key())
+			assert.NoError(t, err)
+			assert.Equal(t, strconv.Itoa(status), headers.Get(handler.NegativeHeader))
+		})
+	}
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		h, _, calls := newHandler(t, handler.NegativeConfig{}, notFound(http.StatusNotFound, "no such file"))
+		assert.Equal(t, http.StatusNotFound, get(h).Code)
+		assert.Equal(t, http.StatusNotFound, get(h).Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("LargeBodyNotCached", func(t *testing.T) {
+		body := strings.Repeat("x", handler.NegativeMaxBody+1)
+		h, _, calls := newHandler(t, enabled, notFound(http.StatusNotFound, body))
+		w := get(h)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, body, w.Body.String())
+		get(h)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("NoStoreNotCached", func(t *testing.T) {
+		h, _, calls := newHandler(t, enabled, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusNotFound)
+		})
+		get(h)
+		get(h)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("OtherStatusesNotCached", func(t *testing.T) {
+		h, _, calls := newHandler(t, enabled, notFound(http.StatusForbidden, "forbidden"))
+		assert.Equal(t, http.StatusForbidden, get(h).Code)
+		assert.Equal(t, http.StatusForbidden, get(h).Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+}
+
+func TestRules(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	newHandler := func(t *testing.T, rules []handler.RuleConfig) (*handler.Handler, *sync.Map) {
+		t.Helper()
+		calls := &sync.Map{} // path -> *atomic.Int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n, _ := calls.LoadOrStore(r.URL.Path, &atomic.Int32{})
+			n.(*atomic.Int32).Add(1) //nolint:forcetypeassert
+			switch {
+			case strings.HasSuffix(r.URL.Path, ".json"):
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			case strings.HasPrefix(r.URL.Path, "/missing"):
+				w.WriteHeader(http.StatusNotFound)
+				return
+			default:
+				w.Header().Set("Cache-Control", "max-age=3600")
+			}
+			_, _ = fmt.Fprint(w, "content of "+r.URL.Path)
+		}))
+		t.Cleanup(upstream.Close)
+		rs, err := handler.NewRules(handler.RulesConfig{Rules: rules})
+		assert.NoError(t, err)
+		h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+			}).
+			Rules(rs)
+		return h, calls
+	}
+	get := func(t *testing.T, h http.Handler, path string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil).WithContext(ctx))
+		if !strings.HasPrefix(path, "/missing") {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "content of "+path, w.Body.String())
+		}
+	}
+	callsTo := func(calls *sync.Map, path string) int32 {
+		n, ok := calls.Load(path)
+		if !ok {
+			return 0
+		}
+		return n.(*atomic.Int32).Load() //nolint:forcetypeassert
+	}
+
+	t.Run("GlobTTL", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{Match: "*/latest/*", TTL: 50 * time.Millisecond, Cache: true},
+		})
+		for _, path := range []string{"/pkg/latest/app.tar.gz", "/pkg/v1/app.tar.gz"} {
+			get(t, h, path)
+			get(t, h, path)
+		}
+		time.Sleep(100 * time.Millisecond)
+		get(t, h, "/pkg/latest/app.tar.gz")
+		get(t, h, "/pkg/v1/app.tar.gz")
+		// The rule's TTL overrides the upstream's max-age.
+		assert.Equal(t, int32(2), callsTo(calls, "/pkg/latest/app.tar.gz"))
+		assert.Equal(t, int32(1), callsTo(calls, "/pkg/v1/app.tar.gz"))
+	})
+
+	t.Run("NeverCache", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{Match: "*/index.json", Cache: false},
+		})
+		for range 2 {
+			get(t, h, "/pkg/index.json")
+			get(t, h, "/pkg/app.json")
+		}
+		assert.Equal(t, int32(2), callsTo(calls, "/pkg/index.json"))
+		assert.Equal(t, int32(1), callsTo(calls, "/pkg/app.json"))
+	})
+
+	t.Run("Regex", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{Regex: `/v[0-9]+/`, Cache: false},
+		})
+		for range 2 {
+			get(t, h, "/pkg/v1/app.tar.gz")
+			get(t, h, "/pkg/latest/app.tar.gz")
+		}
+		assert.Equal(t, int32(2), callsTo(calls, "/pkg/v1/app.tar.gz"))
+		assert.Equal(t, int32(1), callsTo(calls, "/pkg/latest/app.tar.gz"))
+	})
+
+	t.Run("ContentType", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{ContentType: "application/*", Cache: false},
+		})
+		for range 2 {
+			get(t, h, "/pkg/index.json")
+			get(t, h, "/pkg/app.tar.gz")
+		}
+		assert.Equal(t, int32(2), callsTo(calls, "/pkg/index.json"))
+		assert.Equal(t, int32(1), callsTo(calls, "/pkg/app.tar.gz"))
+	})
+
+	t.Run("StatusEnablesNegativeCaching", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{Status: []int{http.StatusNotFound}, TTL: time.Minute, Cache: true},
+		})
+		get(t, h, "/missing/app.tar.gz")
+		get(t, h, "/missing/app.tar.gz")
+		assert.Equal(t, int32(1), callsTo(calls, "/missing/app.tar.gz"))
+	})
+
+	t.Run("TTLAppliesToNegativeStatusesOnlyIfListed", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{Match: "/missing/*", TTL: time.Minute, Cache: true},
+		})
+		get(t, h, "/missing/app.tar.gz")
+		get(t, h, "/missing/app.tar.gz")
+		assert.Equal(t, int32(2), callsTo(calls, "/missing/app.tar.gz"))
+	})
+
+	t.Run("FirstMatchWins", func(t *testing.T) {
+		h, calls := newHandler(t, []handler.RuleConfig{
+			{Match: "*.sha256", Cache: true},
+			{Match: "*", Cache: false},
+		})
+		for range 2 {
+			get(t, h, "/pkg/app.tar.gz.sha256")
+			get(t, h, "/pkg/app.tar.gz")
+		}
+		assert.Equal(t, int32(1), callsTo(calls, "/pkg/app.tar.gz.sha256"))
+		assert.Equal(t, int32(2), callsTo(calls, "/pkg/app.tar.gz"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := handler.NewRules(handler.RulesConfig{Rules: []handler.RuleConfig{{Regex: "("}}})
+		assert.Error(t, err)
+		_, err = handler.NewRules(handler.RulesConfig{Rules: []handler.RuleConfig{{ContentType: "text/["}}})
+		assert.Error(t, err)
+	})
+}
+
+func TestSlices(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	const mib = 1 << 20
+	const url = "http://example.com/system-image.zip"
+	object := make([]byte, 3*mib+mib/2)
+	for i := range object {
+		object[i] = byte(i % 251)
+	}
+
+	type upstream struct {
+		mu       sync.Mutex
+		ranges   []string
+		content  []byte
+		etag     string
+		noRanges bool
+	}
+	newHandler := func(t *testing.T) (*handler.Handler, *upstream) {
+		t.Helper()
+		u := &upstream{content: object, etag: `"v1"`}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u.mu.Lock()
+			u.ranges = append(u.ranges, r.Header.Get("Range"))
+			content, etag, noRanges := u.content, u.etag, u.noRanges
+			u.mu.Unlock()
+			if noRanges {
+				r.Header.Del("Range")
+			}
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		}))
+		t.Cleanup(server.Close)
+		h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, server.URL+"/system-image.zip", nil)
+			}).
+			Slices(handler.SliceConfig{SliceSizeMB: 1})
+		return h, u
+	}
+	get := func(h http.Handler, rangeSpec string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx)
+		if rangeSpec != "" {
+			r.Header.Set("Range", rangeSpec)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	upstreamRanges := func(u *upstream) []string {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		ranges := u.ranges
+		u.ranges = nil
+		return ranges
+	}
+
+	t.Run("FetchesOnlyNeededSlices", func(t *testing.T) {
+		h, u := newHandler(t)
+		w := get(h, "bytes=1048576-1048585")
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes 1048576-1048585/3670016", w.Header().Get("Content-Range"))
+		assert.Equal(t, object[mib:mib+10], w.Body.Bytes())
+		assert.Equal(t, []string{"bytes=1048576-2097151"}, upstreamRanges(u))
+
+		// A range within a cached slice is served from the cache.
+		w = get(h, "bytes=1048600-1048609")
+		assert.Equal(t, object[mib+24:mib+34], w.Body.Bytes())
+		assert.Equal(t, 0, len(upstreamRanges(u)))
+
+		// A range spanning slices fetches only the missing one.
+		w = get(h, "bytes=2097140-2097160")
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, object[2*mib-12:2*mib+9], w.Body.Bytes())
+		assert.Equal(t, []string{"bytes=2097152-3145727"}, upstreamRanges(u))
+	})
+
+	t.Run("SuffixRange", func(t *testing.T) {
+		h, u := newHandler(t)
+		w := get(h, "bytes=-100")
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, object[len(object)-100:], w.Body.Bytes())
+		assert.Equal(t, []string{"bytes=0-0", "bytes=3145728-3670015"}, upstreamRanges(u))
+	})
+
+	t.Run("AssemblesFullObjectFromSlices", func(t *testing.T) {
+		h, u := newHandler(t)
+		get(h, "bytes=0-10")
+		upstreamRanges(u)
+
+		w := get(h, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, strconv.Itoa(len(object)), w.Header().Get("Content-Length"))
+		assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+		assert.True(t, bytes.Equal(object, w.Body.Bytes()))
+		assert.Equal(t, []string{"bytes=1048576-2097151", "bytes=2097152-3145727", "bytes=3145728-3670015"}, upstreamRanges(u))
+
+		w = get(h, "")
+		assert.True(t, bytes.Equal(object, w.Body.Bytes()))
+		assert.Equal(t, 0, len(upstreamRanges(u)))
+	})
+
+	t.Run("ObjectChanged", func(t *testing.T) {
+		h, u := newHandler(t)
+		get(h, "bytes=0-10")
+		changed := bytes.Repeat([]byte("x"), len(object))
+		u.mu.Lock()
+		u.content, u.etag = changed, `"v2"`
+		u.mu.Unlock()
+
+		// The If-Range fails, so the new object is served and cached in full.
+		w := get(h, "bytes=1048576-1048585")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, bytes.Equal(changed, w.Body.Bytes()))
+		upstreamRanges(u)
+
+		w = get(h, "bytes=0-9")
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, changed[:10], w.Body.Bytes())
+		assert.Equal(t, 0, len(upstreamRanges(u)))
+	})
+
+	t.Run("UpstreamIgnoresRanges", func(t *testing.T) {
+		h, u := newHandler(t)
+		u.noRanges = true
+		w := get(h, "bytes=0-10")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, bytes.Equal(object, w.Body.Bytes()))
+
+		w = get(h, "bytes=0-9")
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, object[:10], w.Body.Bytes())
+		assert.Equal(t, 1, len(upstreamRanges(u)))
+	})
+}
+
+func TestRangeOnMiss(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	object := make([]byte, 1<<20)
+	for i := range object {
+		object[i] = byte(i % 251)
+	}
+	half := len(object) / 2
+
+	var calls atomic.Int32
+	var upstreamRanges []string
+	var mu sync.Mutex
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		mu.Lock()
+		upstreamRanges = append(upstreamRanges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		_, _ = w.Write(object[:half])
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write(object[half:])
+	}))
+	defer upstream.Close()
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/object", nil)
+		})
+	server := httptest.NewUnstartedServer(h)
+	server.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	server.Start()
+	defer server.Close()
+
+	get := func(rangeSpec string) (*http.Response, []byte) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/object", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", rangeSpec)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, body
+	}
+
+	// The first range is served while the upstream is still sending the
+	// rest of the object.
+	resp, body := get("bytes=10-19")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 10-19/1048576", resp.Header.Get("Content-Range"))
+	assert.Equal(t, object[10:20], body)
+
+	// A range past what has arrived follows the same fetch.
+	type result struct {
+		resp *http.Response
+		body []byte
+	}
+	tail := make(chan result)
+	go func() {
+		resp, body := get("bytes=-10")
+		tail <- result{resp, body}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	got := <-tail
+	assert.Equal(t, http.StatusPartialContent, got.resp.StatusCode)
+	assert.Equal(t, object[len(object)-10:], got.body)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, []string{""}, upstreamRanges)
+
+	// Once the background fetch completes, the object is cached in full.
+	var cached []byte
+	for range 100 {
+		resp, cached = get("bytes=0-9")
+		if calls.Load() == 1 && resp.StatusCode == http.StatusPartialContent {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, object[:10], cached)
+	assert.Equal(t, int32(1), calls.Load())
+
+	t.Run("Uncacheable", func(t *testing.T) {
+		var ranges []string
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+			w.Header().Set("Cache-Control", "no-store")
+			http.ServeContent(w, r, "object", time.Time{}, bytes.NewReader(object))
+		}))
+		defer upstream.Close()
+		h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/object", nil)
+			})
+
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/object", nil)
+		req.Header.Set("Range", "bytes=10-19")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes 10-19/1048576", w.Header().Get("Content-Range"))
+		assert.Equal(t, object[10:20], w.Body.Bytes())
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{"", "bytes=10-19"}, ranges)
+	})
+}
+
+func TestFillLeases(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	const url = "http://example.com/artifact"
+
+	// replicas returns two handlers sharing a cache and metadata backend, as
+	// replicas share the authoritative tier and the metadata store.
+	replicas := func(t *testing.T, status int, wait time.Duration) (h []*handler.Handler, leases *handler.FillLeases, calls *atomic.Int32, release chan struct{}) {
+		t.Helper()
+		calls = &atomic.Int32{}
+		release = make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) == 1 {
+				<-release
+			}
+			w.WriteHeader(status)
+			_, _ = fmt.Fprint(w, "artifact")
+		}))
+		t.Cleanup(upstream.Close)
+		c := mustNewMemoryCache()
+		backend := metadatadb.NewMemoryBackend()
+		for range 2 {
+			store := metadatadb.New(ctx, backend)
+			leases = handler.NewFillLeases(store.Namespace("http"))
+			h = append(h, handler.New(http.DefaultClient, c).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/artifact", nil)
+				}).
+				FillLeases(leases, wait))
+		}
+		return h, leases, calls, release
+	}
+
+	// serveBoth serves the request on the first replica and, once it is
+	// fetching, on the second.
+	serveBoth := func(h []*handler.Handler, calls *atomic.Int32, release chan struct{}) []*httptest.ResponseRecorder {
+		responses := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+		var wg sync.WaitGroup
+		for i := range h {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h[i].ServeHTTP(responses[i], httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx))
+			}()
+			for calls.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		// Give the second replica time to find the lease taken.
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return responses
+	}
+
+	t.Run("LoserServesWinnersResponse", func(t *testing.T) {
+		h, _, calls, release := replicas(t, http.StatusOK, time.Minute)
+		responses := serveBoth(h, calls, release)
+		assert.Equal(t, int32(1), calls.Load())
+		for _, w := range responses {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "artifact", w.Body.String())
+		}
+	})
+
+	t.Run("LoserFetchesUncacheableResponse", func(t *testing.T) {
+		h, _, calls, release := replicas(t, http.StatusNotFound, time.Minute)
+		responses := serveBoth(h, calls, release)
+		assert.Equal(t, int32(2), calls.Load())
+		for _, w := range responses {
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("LoserFetchesAfterTimeout", func(t *testing.T) {
+		h, leases, calls, release := replicas(t, http.StatusOK, 100*time.Millisecond)
+		close(release)
+		claimed, err := leases.Claim(handler.NewCacheKeyParts(url).Key(), "crashed-replica")
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+
+		w := httptest.NewRecorder()
+		h[1].ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx))
+		assert.Equal(t, int32(1), calls.Load())
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "artifact", w.Body.String())
+	})
+
+	t.Run("NilSafe", func(t *testing.T) {
+		var leases *handler.FillLeases
+		claimed, err := leases.Claim(handler.NewCacheKeyParts(url).Key(), "replica")
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+		assert.False(t, leases.Held(handler.NewCacheKeyParts(url).Key()))
+		assert.NoError(t, leases.Release(handler.NewCacheKeyParts(url).Key(), "replica"))
+		assert.Zero(t, handler.NewFillLeases(nil))
+	})
+}
+
 func mustNewMemoryCache() cache.Cache {
 	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
 	c, err := cache.NewMemory(ctx, cache.MemoryConfig{
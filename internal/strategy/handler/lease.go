@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
+)
+
+const (
+	fillLeaseMapName = "fill_leases"
+	// fillLeaseTTL bounds how long a crashed holder's lease keeps other
+	// replicas waiting. Live holders renew it every fillLeaseRenewInterval
+	// for as long as the fill runs, however large the object.
+	fillLeaseTTL           = time.Minute
+	fillLeaseRenewInterval = fillLeaseTTL / 3
+	// fillLeasePollInterval is how often a replica that lost the lease checks
+	// the cache for the winner's response.
+	fillLeasePollInterval = time.Second
+)
+
+// LeaseConfig configures how long a replica that loses the fill lease for a
+// cache miss waits for the winner. Strategies embed it in their configuration.
+type LeaseConfig struct {
+	FillLeaseWait time.Duration `hcl:"fill-lease-wait,optional" help:"How long a cache miss waits for another replica already fetching the same response to cache it, before fetching it itself. 0 disables cross-replica coalescing." default:"5m"`
+}
+
+// fillLease is the shared record of a replica filling a cache miss.
+type fillLease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FillLeases coalesces cache misses across replicas: the replica holding a
+// key's lease fetches it from upstream, while the others wait for it to reach
+// the shared cache. Like SnapshotCoordinator, leases are advisory: the
+// metadata store is last-write-wins and syncs asynchronously, so replicas
+// that miss within the same sync window can still both fetch.
+//
+// All methods are nil-safe; without a metadata store every replica fetches.
+type FillLeases struct {
+	leases *metadatadb.Map[string, fillLease]
+	now    func() time.Time
+}
+
+// NewFillLeases returns nil if ns is nil so callers don't need a separate
+// "no metadata configured" code path.
+func NewFillLeases(ns *metadatadb.Namespace) *FillLeases {
+	if ns == nil {
+		return nil
+	}
+	return &FillLeases{
+		leases: metadatadb.NewMap[string, fillLease](ns, fillLeaseMapName),
+		now:    time.Now,
+	}
+}
+
+// Claim reports whether holder may fill key, recording or renewing its lease
+// when it may. It declines while another holder's lease is unexpired.
+func (l *FillLeases) Claim(key cache.Key, holder string) (bool, error) {
+	if l == nil {
+		return true, nil
+	}
+	now := l.now()
+	lease, ok := l.leases.Get(key.String())
+	if ok && lease.Holder != holder && now.Before(lease.ExpiresAt) {
+		return false, nil
+	}
+	if err := l.leases.Set(key.String(), fillLease{Holder: holder, ExpiresAt: now.Add(fillLeaseTTL)}); err != nil {
+		return true, errors.Wrap(err, "record fill lease")
+	}
+	return true, nil
+}
+
+// Held reports whether any holder has an unexpired lease on key.
+func (l *FillLeases) Held(key cache.Key) bool {
+	if l == nil {
+		return false
+	}
+	lease, ok := l.leases.Get(key.String())
+	return ok && l.now().Before(lease.ExpiresAt)
+}
+
+// Release drops holder's lease on key, so replicas waiting on it stop
+// waiting even if the response was not cached.
+func (l *FillLeases) Release(key cache.Key, holder string) error {
+	if l == nil {
+		return nil
+	}
+	if lease, ok := l.leases.Get(key.String()); !ok || lease.Holder != holder {
+		return nil
+	}
+	return errors.Wrap(l.leases.Delete(key.String()), "release fill lease")
+}
+
+// awaitFill coordinates a cache miss on key with other replicas. It returns
+// served if another replica filled the cache and the response was served from
+// it, or the client went away while waiting. Otherwise the caller fetches from
+// upstream and must call release once the response is cached, which is a
+// no-op if the lease was not won in time.
+func (h *Handler) awaitFill(w http.ResponseWriter, r *http.Request, parts CacheKeyParts, key cache.Key) (served bool, release func()) {
+	release = func() {}
+	if h.leases == nil || h.leaseWait <= 0 {
+		return false, release
+	}
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	holder := newToken()
+	deadline := time.Now().Add(h.leaseWait)
+	waiting := false
+	for {
+		claimed, err := h.leases.Claim(key, holder)
+		if err != nil {
+			// Coordination is advisory, so fail open.
+			logger.WarnContext(ctx, "Failed to claim fill lease", "error", err)
+		}
+		if claimed {
+			return false, h.holdLease(r, key, holder)
+		}
+		if !waiting {
+			logger.DebugContext(ctx, "Waiting for another replica to fill cache miss")
+			waiting = true
+		}
+		// Poll until the winner caches the response, releases its lease
+		// without doing so, or the wait times out.
+		for h.leases.Held(key) {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				logger.WarnContext(ctx, "Timed out waiting for another replica to fill cache miss", "wait", h.leaseWait)
+				return false, release
+			}
+			select {
+			case <-ctx.Done():
+				return true, release
+			case <-time.After(min(remaining, fillLeasePollInterval)):
+			}
+			if served, _, _ := h.serveCached(w, r, parts); served {
+				return true, release
+			}
+		}
+		if served, _, _ := h.serveCached(w, r, parts); served {
+			return true, release
+		}
+	}
+}
+
+// holdLease renews holder's lease on key until the returned function is
+// called, which releases it.
+func (h *Handler) holdLease(r *http.Request, key cache.Key, holder string) func() {
+	ctx := r.Context()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(fillLeaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := h.leases.Claim(key, holder); err != nil {
+					logging.FromContext(ctx).WarnContext(ctx, "Failed to renew fill lease", "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		if err := h.leases.Release(key, holder); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to release fill lease", "error", err)
+		}
+	}
+}
+
+// newToken returns a random identifier, such as a fill lease holder.
+func newToken() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+)
+
+// NegativeHeader marks a cache entry as a negatively cached upstream response,
+// recording its status code. Admin tooling can identify negative entries by
+// it, for example with a HEAD of the object API, to purge them.
+const NegativeHeader = "X-Cachew-Negative"
+
+// NegativeMaxBody is the largest upstream 404 or 410 body that is negatively
+// cached. Larger responses are passed through uncached.
+const NegativeMaxBody = 64 * 1024
+
+// NegativeConfig configures caching of upstream 404 and 410 responses, so that
+// repeated probes for missing paths don't all reach the upstream. Strategies
+// embed it in their configuration.
+type NegativeConfig struct {
+	NegativeTTL time.Duration `hcl:"negative-ttl,optional" help:"Cache upstream 404 and 410 responses for this long. 0 disables negative caching."`
+}
+
+// IsNegativeStatus reports whether an upstream status may be negatively cached.
+func IsNegativeStatus(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone
+}
+
+// negativeStatus returns the status of a negatively cached entry, or 0 if the
+// entry is a regular response.
+func negativeStatus(headers http.Header) int {
+	status, err := strconv.Atoi(headers.Get(NegativeHeader))
+	if err != nil || !IsNegativeStatus(status) {
+		return 0
+	}
+	return status
+}
+
+// negativeTTL returns how long to negatively cache a response with the given
+// freshness, or 0 if it should not be cached. The first caching rule matching
+// the response may forbid caching it, or set the TTL if it lists the status
+// explicitly, even if negative caching is not configured. Otherwise the
+// upstream's own explicit freshness shortens the configured TTL.
+func (h *Handler) negativeTTL(r *http.Request, status int, header http.Header, f freshness) time.Duration {
+	if !f.store {
+		return 0
+	}
+	if rule := h.rules.match(r, status, header); rule != nil {
+		if !rule.cache {
+			return 0
+		}
+		if rule.ttl > 0 && slices.Contains(rule.status, status) {
+			return rule.ttl
+		}
+	}
+	if h.negative.NegativeTTL <= 0 {
+		return 0
+	}
+	if f.explicit {
+		return min(f.lifetime, h.negative.NegativeTTL)
+	}
+	return h.negative.NegativeTTL
+}
+
+// streamAndCacheNegative passes an upstream 404 or 410 response through to
+// the client, caching its status, headers and body if they are small enough
+// and the upstream permits it.
+func (h *Handler) streamAndCacheNegative(w http.ResponseWriter, r *http.Request, parts CacheKeyParts, resp *http.Response) error {
+	ctx := r.Context()
+	now := time.Now()
+	ttl := h.negativeTTL(r, resp.StatusCode, resp.Header, responseFreshness(resp.Header, now))
+	if ttl <= 0 {
+		return h.streamNonOKResponse(w, resp)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, NegativeMaxBody+1))
+	if err != nil {
+		return errors.Wrap(err, "read negative response")
+	}
+	if len(body) > NegativeMaxBody {
+		logging.FromContext(ctx).DebugContext(ctx, "Upstream response too large to cache negatively", "status", resp.StatusCode)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return h.streamNonOKResponse(w, resp)
+	}
+
+	headers := cacheHeaders(resp.Header, freshness{}, now)
+	headers.Set(NegativeHeader, strconv.Itoa(resp.StatusCode))
+	key, err := h.variantKey(r, parts, varyNames(resp.Header), ttl)
+	if err == nil {
+		err = cache.WriteFunc(ctx, h.cache, key, headers, ttl, func(w io.Writer) error {
+			_, err := w.Write(body)
+			return errors.WithStack(err)
+		})
+	}
+	if err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Failed to cache negative response", "error", err)
+	} else {
+		logging.FromContext(ctx).DebugContext(ctx, "Cached negative response", "status", resp.StatusCode, "ttl", ttl)
+	}
+
+	maps.Copy(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	_, err = w.Write(body)
+	return errors.Wrap(err, "write negative response")
+}
+
+// serveNegative serves a negatively cached entry with its recorded status,
+// returning false if it has since been evicted. The entry is reopened without
+// the request's conditions, which apply to the resource rather than the error.
+func (h *Handler) serveNegative(w http.ResponseWriter, r *http.Request, key cache.Key, status int) bool {
+	ctx := r.Context()
+	cr, headers, err := h.cache.Open(ctx, key)
+	if err != nil {
+		return false
+	}
+	defer cr.Close()
+	for _, name := range internalHeaders {
+		headers.Del(name)
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "Negative cache hit", "status", status)
+	maps.Copy(w.Header(), headers)
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, cr); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to serve from cache", "error", err)
+	}
+	return true
+}
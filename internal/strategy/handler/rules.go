@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// RuleConfig configures how upstream responses matching it are cached. Every
+// condition that is set must match.
+type RuleConfig struct {
+	Match       string        `hcl:"match,optional" help:"Glob matched against the request path, where * matches any characters including / and ? matches one, e.g. \"*/latest/*\" or \"*.sha256\"."`
+	Regex       string        `hcl:"regex,optional" help:"Regular expression matched against the request path."`
+	ContentType string        `hcl:"content-type,optional" help:"Glob matched against the response's media type, without parameters, e.g. \"application/json\" or \"text/*\"."`
+	Status      []int         `hcl:"status,optional" help:"Response status codes to match. Empty matches any. Only 200, 404 and 410 responses are cached."`
+	TTL         time.Duration `hcl:"ttl,optional" help:"How long matching responses stay fresh, overriding upstream freshness and the strategy's TTL. Applies to 404 and 410 responses only if status lists them. 0 leaves them unchanged."`
+	Cache       bool          `hcl:"cache,optional" help:"Whether matching responses are cached at all." default:"true"`
+}
+
+// RulesConfig configures caching rules for upstream responses. Strategies
+// embed it in their configuration, so that rules are repeated rule blocks.
+type RulesConfig struct {
+	Rules []RuleConfig `hcl:"rule,block,optional" help:"Caching rules, applied in order. The first rule matching a response decides how it is cached."`
+}
+
+// Rules is a compiled list of caching rules. The zero value and nil have no
+// rules.
+type Rules struct {
+	rules []rule
+}
+
+type rule struct {
+	match       *regexp.Regexp
+	regex       *regexp.Regexp
+	contentType string
+	status      []int
+	ttl         time.Duration
+	cache       bool
+}
+
+// NewRules compiles caching rules.
+func NewRules(config RulesConfig) (*Rules, error) {
+	rules := &Rules{}
+	for i, c := range config.Rules {
+		rl := rule{
+			contentType: strings.ToLower(c.ContentType),
+			status:      c.Status,
+			ttl:         c.TTL,
+			cache:       c.Cache,
+		}
+		if c.Match != "" {
+			rl.match = globRegexp(c.Match)
+		}
+		if c.Regex != "" {
+			re, err := regexp.Compile(c.Regex)
+			if err != nil {
+				return nil, errors.Errorf("rule %d: invalid regex %q: %w", i+1, c.Regex, err)
+			}
+			rl.regex = re
+		}
+		if _, err := path.Match(rl.contentType, ""); err != nil {
+			return nil, errors.Errorf("rule %d: invalid content-type pattern %q: %w", i+1, c.ContentType, err)
+		}
+		if c.TTL < 0 {
+			return nil, errors.Errorf("rule %d: ttl must not be negative", i+1)
+		}
+		rules.rules = append(rules.rules, rl)
+	}
+	return rules, nil
+}
+
+// globRegexp converts a path glob to an anchored regular expression. Unlike
+// path.Match, * also matches /, so that "*/latest/*" matches at any depth.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// match returns the first rule matching an upstream response to r, or nil.
+func (rs *Rules) match(r *http.Request, status int, header http.Header) *rule {
+	if rs == nil {
+		return nil
+	}
+	for i := range rs.rules {
+		if rs.rules[i].matches(r, status, header) {
+			return &rs.rules[i]
+		}
+	}
+	return nil
+}
+
+func (rl *rule) matches(r *http.Request, status int, header http.Header) bool {
+	if rl.match != nil && !rl.match.MatchString(r.URL.Path) {
+		return false
+	}
+	if rl.regex != nil && !rl.regex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rl.status) > 0 && !slices.Contains(rl.status, status) {
+		return false
+	}
+	if rl.contentType != "" {
+		matched, _ := path.Match(rl.contentType, mediaType(header)) //nolint:errcheck // validated by NewRules
+		return matched
+	}
+	return true
+}
+
+// mediaType returns the lower-cased media type of a response, without
+// parameters.
+func mediaType(header http.Header) string {
+	value := header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(value); err == nil {
+		return mt
+	}
+	mt, _, _ := strings.Cut(value, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// cacheable reports whether an upstream response to r may be cached.
+func (h *Handler) cacheable(r *http.Request, resp *http.Response) bool {
+	f, _ := h.storagePolicy(r, resp.StatusCode, resp.Header, responseFreshness(resp.Header, time.Now()))
+	return f.store
+}
+
+// configuredTTL returns the TTL of an upstream response to r: that of the
+// first caching rule matching it, or else the handler's.
+func (h *Handler) configuredTTL(r *http.Request, status int, header http.Header) time.Duration {
+	if rule := h.rules.match(r, status, header); rule != nil && rule.ttl > 0 {
+		return rule.ttl
+	}
+	return h.ttlFunc(r)
+}
@@ -0,0 +1,405 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+)
+
+// Internal headers of sliced objects.
+const (
+	// sliceSizeHeader and objectSizeHeader record the slice size and the size
+	// of the whole object on a sliced object's manifest.
+	sliceSizeHeader  = "X-Cachew-Slice-Size"
+	objectSizeHeader = "X-Cachew-Object-Size"
+	// sliceGenerationHeader identifies the upstream version of a sliced object
+	// on its manifest and slices, so that slices of different versions are
+	// never served together.
+	sliceGenerationHeader = "X-Cachew-Slice-Generation"
+	// sliceValidatorHeader records the validator slices are fetched with, as
+	// the cache synthesizes a Last-Modified header if the upstream sent none.
+	sliceValidatorHeader = "X-Cachew-Slice-Validator"
+)
+
+// errNotSliceable is returned when an upstream response cannot be sliced,
+// because it forbids storage or varies on request headers.
+var errNotSliceable = errors.New("response cannot be sliced")
+
+// SliceConfig configures caching large objects in slices. Strategies embed it
+// in their configuration.
+type SliceConfig struct {
+	SliceSizeMB int `hcl:"slice-size-mb,optional" help:"Cache byte-range requests in slices of this many MiB, fetching only the slices a request needs from upstream. 0 disables slicing."`
+}
+
+// sliceManifest describes a sliced object. It is stored, without a body,
+// under a key derived from the object's.
+type sliceManifest struct {
+	headers    http.Header // the object's upstream headers
+	size       int64
+	generation string
+	// validator is the upstream ETag or Last-Modified slices are fetched with
+	// as If-Range, or empty if the upstream sent neither.
+	validator string
+}
+
+// sliceParts returns the key parts of a sliced object's manifest, or of slice
+// index if it is not negative.
+func sliceParts(parts CacheKeyParts, index int64) CacheKeyParts {
+	path := parts.Path + "#slices"
+	if index >= 0 {
+		path += "/" + strconv.FormatInt(index, 10)
+	}
+	return CacheKeyParts{Path: path, Vary: parts.Vary}
+}
+
+// serveSliced serves a request from the slices of an object, fetching the
+// slices it needs that are not cached with upstream Range requests. Range
+// requests for objects that are not cached in full are sliced, and requests
+// for the whole of an object that has been sliced are assembled from its
+// slices. It returns false, having written nothing, if the request is not
+// served this way.
+func (h *Handler) serveSliced(w http.ResponseWriter, r *http.Request, parts CacheKeyParts) bool {
+	if h.sliceSize <= 0 {
+		return false
+	}
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Range"} {
+		if r.Header.Get(name) != "" {
+			return false
+		}
+	}
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	spec := r.Header.Get("Range")
+	m := h.loadManifest(r, parts)
+	if m == nil && spec == "" {
+		return false
+	}
+
+	if m == nil {
+		// Without a manifest the object's size is not known. Suffix ranges
+		// need it to find their first slice, so probe for it.
+		start, ok := rangeStart(spec)
+		if !ok {
+			return false
+		}
+		var resp *http.Response
+		var err error
+		if start < 0 {
+			m, resp, err = h.probeSlices(r, parts)
+		} else {
+			m, resp, err = h.fetchSlice(r, parts, nil, start/h.sliceSize)
+		}
+		if !h.handleSliceFetch(w, r, parts, resp, err) {
+			return false
+		}
+		if resp != nil {
+			return true
+		}
+	}
+
+	start, length, outcome := cache.RequestOptions{Range: spec}.ResolveRange(m.size, "")
+	if outcome == cache.RangeNotSatisfiable {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", m.size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	wroteHeader := false
+	writeHeader := func() {
+		wroteHeader = true
+		maps.Copy(w.Header(), m.headers)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		if outcome == cache.RangePartial {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, m.size))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	if length == 0 {
+		writeHeader()
+		return true
+	}
+
+	for offset, end := start, start+length; offset < end; {
+		index := offset / h.sliceSize
+		sliceEnd := min((index+1)*h.sliceSize, end)
+		cr, err := h.openSlice(r, parts, m, index, offset, sliceEnd)
+		if err != nil {
+			logger.DebugContext(ctx, "Fetching slice from upstream", "slice", index)
+			var resp *http.Response
+			_, resp, err = h.fetchSlice(r, parts, m, index)
+			if !wroteHeader {
+				if !h.handleSliceFetch(w, r, parts, resp, err) {
+					return false
+				}
+				if resp != nil {
+					return true
+				}
+			} else if resp != nil {
+				_ = resp.Body.Close()
+				err = errors.Errorf("upstream returned %s for slice", resp.Status)
+			}
+			if err == nil {
+				cr, err = h.openSlice(r, parts, m, index, offset, sliceEnd)
+			}
+			if err != nil {
+				// The response has started, so it can only be cut short.
+				logger.ErrorContext(ctx, "Failed to serve slice", "slice", index, "error", err)
+				return true
+			}
+		}
+		if !wroteHeader {
+			writeHeader()
+		}
+		n, err := io.Copy(w, cr)
+		_ = cr.Close()
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to serve slice", "slice", index, "error", err)
+			return true
+		}
+		offset += n
+		if n == 0 {
+			logger.ErrorContext(ctx, "Cached slice is empty", "slice", index)
+			return true
+		}
+	}
+	return true
+}
+
+// handleSliceFetch handles the outcome of fetching a slice before any of the
+// response has been written. If the upstream answered with something other
+// than the slice, such as the whole object or an error, that response is
+// served in its place. It returns false if the request should be served
+// without slicing instead.
+func (h *Handler) handleSliceFetch(w http.ResponseWriter, r *http.Request, parts CacheKeyParts, resp *http.Response, err error) bool {
+	ctx := r.Context()
+	switch {
+	case errors.Is(err, errNotSliceable):
+		logging.FromContext(ctx).DebugContext(ctx, "Upstream response cannot be sliced")
+		return false
+	case err != nil:
+		h.errorHandler(err, w, r)
+		return true
+	case resp != nil:
+		// The object changed or the upstream ignored the Range, so its
+		// slices can't be trusted.
+		_ = h.cache.Delete(ctx, sliceParts(parts, -1).Key())
+		defer resp.Body.Close()
+		if err := h.streamResponse(w, r, parts, resp, nil); err != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "Failed to fetch and cache", "error", err)
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// loadManifest returns the manifest of a sliced object, or nil if there is no
+// fresh manifest for the configured slice size.
+func (h *Handler) loadManifest(r *http.Request, parts CacheKeyParts) *sliceManifest {
+	headers, err := h.cache.Stat(r.Context(), sliceParts(parts, -1).Key())
+	if err != nil || isStale(headers, time.Now()) {
+		return nil
+	}
+	sliceSize, err := strconv.ParseInt(headers.Get(sliceSizeHeader), 10, 64)
+	if err != nil || sliceSize != h.sliceSize {
+		return nil
+	}
+	size, err := strconv.ParseInt(headers.Get(objectSizeHeader), 10, 64)
+	if err != nil || size < 0 {
+		return nil
+	}
+	m := &sliceManifest{
+		size:       size,
+		generation: headers.Get(sliceGenerationHeader),
+		validator:  headers.Get(sliceValidatorHeader),
+	}
+	// The cache's own ETag applies to the manifest, not the object.
+	headers.Del("ETag")
+	if etag := headers.Get(upstreamETagHeader); etag != "" {
+		headers.Set("ETag", etag)
+	}
+	for _, name := range internalHeaders {
+		headers.Del(name)
+	}
+	headers.Del("Content-Length")
+	m.headers = headers
+	return m
+}
+
+// createManifest records a sliced object's manifest from the headers of an
+// upstream 206 response.
+func (h *Handler) createManifest(r *http.Request, parts CacheKeyParts, header http.Header, size int64) (*sliceManifest, error) {
+	ctx := r.Context()
+	now := time.Now()
+	f, ttl := h.storagePolicy(r, http.StatusOK, header, responseFreshness(header, now))
+	vary := varyNames(header)
+	if !f.store || len(vary) > 1 || (len(vary) == 1 && vary[0] != "Accept-Encoding") {
+		return nil, errNotSliceable
+	}
+	stored := header.Clone()
+	stored.Del("Content-Range")
+	stored.Del("Content-Length")
+	stored = cacheHeaders(stored, f, now)
+
+	m := &sliceManifest{
+		size:      size,
+		validator: sliceValidator(header.Get("ETag"), header.Get("Last-Modified")),
+	}
+	// Slices of an object without validators can only be trusted alongside
+	// the manifest they were fetched for.
+	m.generation = m.validator
+	if m.generation == "" {
+		m.generation = newToken()
+	}
+	stored.Set(sliceSizeHeader, strconv.FormatInt(h.sliceSize, 10))
+	stored.Set(objectSizeHeader, strconv.FormatInt(size, 10))
+	stored.Set(sliceGenerationHeader, m.generation)
+	stored.Set(sliceValidatorHeader, m.validator)
+	err := cache.WriteFunc(ctx, h.cache, sliceParts(parts, -1).Key(), stored, ttl, func(io.Writer) error { return nil })
+	if err != nil {
+		return nil, errors.Wrap(err, "create slice manifest")
+	}
+
+	m.headers = header.Clone()
+	m.headers.Del("Content-Range")
+	m.headers.Del("Content-Length")
+	for _, name := range internalHeaders {
+		m.headers.Del(name)
+	}
+	return m, nil
+}
+
+// probeSlices creates the manifest of an object by fetching its first byte.
+func (h *Handler) probeSlices(r *http.Request, parts CacheKeyParts) (*sliceManifest, *http.Response, error) {
+	resp, err := h.fetchRange(r, nil, 0, 0)
+	if err != nil || resp.StatusCode != http.StatusPartialContent {
+		return nil, resp, err
+	}
+	defer resp.Body.Close()
+	_, _, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok {
+		return nil, nil, errors.Errorf("invalid upstream Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+	m, err := h.createManifest(r, parts, resp.Header, size)
+	return m, nil, err
+}
+
+// fetchSlice fetches slice index of an object from upstream and caches it,
+// creating the object's manifest if m is nil. If the upstream responds with
+// anything but the slice, the response is returned for the caller to serve
+// and close instead.
+func (h *Handler) fetchSlice(r *http.Request, parts CacheKeyParts, m *sliceManifest, index int64) (*sliceManifest, *http.Response, error) {
+	ctx := r.Context()
+	start := index * h.sliceSize
+	end := start + h.sliceSize - 1
+	if m != nil {
+		end = min(end, m.size-1)
+	}
+	resp, err := h.fetchRange(r, m, start, end)
+	if err != nil || resp.StatusCode != http.StatusPartialContent {
+		return m, resp, err
+	}
+	defer resp.Body.Close()
+
+	first, last, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || first != start || (m != nil && size != m.size) {
+		return nil, nil, errors.Errorf("unexpected upstream Content-Range %q for slice %d", resp.Header.Get("Content-Range"), index)
+	}
+	if want := min(start+h.sliceSize, size) - 1; last != want {
+		return nil, nil, errors.Errorf("upstream returned a partial slice %d: %q", index, resp.Header.Get("Content-Range"))
+	}
+	if m == nil {
+		if m, err = h.createManifest(r, parts, resp.Header, size); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	headers := http.Header{}
+	headers.Set(sliceGenerationHeader, m.generation)
+	length := last - first + 1
+	err = cache.WriteFunc(ctx, h.cache, sliceParts(parts, index).Key(), headers, h.configuredTTL(r, http.StatusOK, resp.Header), func(w io.Writer) error {
+		n, err := io.Copy(w, io.LimitReader(resp.Body, length))
+		if err == nil && n != length {
+			err = errors.Errorf("short slice: %d of %d bytes", n, length)
+		}
+		return errors.WithStack(err)
+	})
+	return m, nil, errors.Wrapf(err, "cache slice %d", index)
+}
+
+// fetchRange requests bytes [start, end] of an object from upstream, on
+// condition that it has not changed since m was created.
+func (h *Handler) fetchRange(r *http.Request, m *sliceManifest, start, end int64) (*http.Response, error) {
+	req := r.Clone(r.Context())
+	for _, name := range revalidationHeaders {
+		req.Header.Del(name)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if m != nil && m.validator != "" {
+		req.Header.Set("If-Range", m.validator)
+	}
+	return h.fetch(req, nil)
+}
+
+// openSlice opens bytes [start, end) of an object, which must lie within slice
+// index, from the cached slice.
+func (h *Handler) openSlice(r *http.Request, parts CacheKeyParts, m *sliceManifest, index, start, end int64) (io.ReadCloser, error) {
+	offset := index * h.sliceSize
+	cr, headers, err := h.cache.Open(r.Context(), sliceParts(parts, index).Key(), cache.Range(start-offset, end-offset))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if headers.Get(sliceGenerationHeader) != m.generation {
+		_ = cr.Close()
+		return nil, errors.Errorf("slice %d is from another version of the object", index)
+	}
+	return cr, nil
+}
+
+// sliceValidator returns the validator to fetch slices with: the upstream
+// ETag if it is strong, as weak ETags can't be used with If-Range, or else
+// Last-Modified.
+func sliceValidator(etag, lastModified string) string {
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return lastModified
+}
+
+// rangeStart returns the first byte of a single byte range, or -1 for a
+// suffix range. It returns false for other ranges.
+func rangeStart(spec string) (int64, bool) {
+	spec, ok := strings.CutPrefix(spec, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, false
+	}
+	if first = strings.TrimSpace(first); first == "" {
+		return -1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil && start >= 0
+}
+
+// parseContentRange parses a "bytes first-last/size" Content-Range header.
+func parseContentRange(value string) (first, last, size int64, ok bool) {
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%d", &first, &last, &size); err != nil {
+		return 0, 0, 0, false
+	}
+	return first, last, size, first >= 0 && first <= last && last < size
+}
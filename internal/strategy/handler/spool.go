@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// errSpoolUnavailable is returned by spool.serveTo when nothing was written to
+// the client because the leader's response was not spooled, or varies from
+// what the follower asked for. The follower falls back to the cache and the
+// upstream.
+var errSpoolUnavailable = errors.New("spool unavailable")
+
+// spool coalesces concurrent cache misses for one key. The first miss, the
+// leader, fetches from upstream and tees a cacheable response into a temporary
+// file; the others follow it, blocking when caught up until the fetch
+// completes, as git's ResponseSpool does for upload-pack.
+type spool struct {
+	key      cache.Key
+	mu       sync.Mutex
+	cond     *sync.Cond
+	file     *os.File
+	headers  http.Header
+	vary     map[string]string // request header values the response varies on
+	written  int64
+	started  bool
+	complete bool
+	err      error
+	readers  int
+	// release is called when the spool is finished, to release the leader's
+	// fill lease.
+	release func()
+	// detached is set by a leader that hands the spool over to a background
+	// fetch, which finishes it instead.
+	detached bool
+}
+
+func newSpool(key cache.Key) *spool {
+	s := &spool{key: key}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// joinSpool returns the spool for a cache miss on key, creating it if there
+// is none. leader is true if the caller created it and must fetch from
+// upstream and call finishSpool. Followers are registered as readers before
+// the spool can be finished.
+func (h *Handler) joinSpool(key cache.Key) (s *spool, leader bool) {
+	h.spoolsMu.Lock()
+	defer h.spoolsMu.Unlock()
+	if s, ok := h.spools[key]; ok {
+		s.mu.Lock()
+		s.readers++
+		s.mu.Unlock()
+		return s, false
+	}
+	s = newSpool(key)
+	h.spools[key] = s
+	return s, true
+}
+
+// finishSpool stops new followers joining the leader's spool, releases the
+// leader's fill lease, and removes the spool's file once the fetch is complete
+// and every follower is done.
+func (h *Handler) finishSpool(s *spool) {
+	h.unregisterSpool(s)
+	s.abandon()
+	if s.release != nil {
+		s.release()
+	}
+	go s.close()
+}
+
+// unregisterSpool stops new followers joining a spool. Later misses for its
+// key look up the cache, or fetch from upstream themselves.
+func (h *Handler) unregisterSpool(s *spool) {
+	h.spoolsMu.Lock()
+	defer h.spoolsMu.Unlock()
+	if h.spools[s.key] == s {
+		delete(h.spools, s.key)
+	}
+}
+
+// join registers the leader as a reader of its own spool, for serving a
+// range of the response it is fetching in the background.
+func (s *spool) join() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readers++
+}
+
+// start records the headers of a cacheable response and opens the spool
+// file. vary holds the leader's values for the request headers the response
+// varies on.
+func (s *spool) start(headers http.Header, vary map[string]string) error {
+	f, err := os.CreateTemp("", "cachew-spool-*")
+	if err != nil {
+		s.fail(err)
+		return errors.Wrap(err, "create spool file")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = f
+	s.headers = headers.Clone()
+	s.vary = vary
+	s.started = true
+	s.cond.Broadcast()
+	return nil
+}
+
+// Write appends to the spool. Spool failures are reported to followers but
+// never to the leader, whose response and cache write carry on.
+func (s *spool) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.complete {
+		return len(data), nil
+	}
+	n, err := s.file.Write(data)
+	s.written += int64(n)
+	if err != nil {
+		s.err = errors.Wrap(err, "write to spool file")
+		s.complete = true
+	}
+	s.cond.Broadcast()
+	return len(data), nil
+}
+
+// done marks the spooled response as completely written.
+func (s *spool) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.complete = true
+	s.cond.Broadcast()
+}
+
+// fail marks the spool as failed, unless it is already complete.
+func (s *spool) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.complete {
+		return
+	}
+	s.err = err
+	s.complete = true
+	s.cond.Broadcast()
+}
+
+// abandon releases followers waiting on a response that was never spooled.
+func (s *spool) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.complete {
+		return
+	}
+	s.err = errSpoolUnavailable
+	s.complete = true
+	s.cond.Broadcast()
+}
+
+// close waits for the fetch and all followers to finish, then removes the
+// spool file.
+func (s *spool) close() {
+	s.mu.Lock()
+	for !s.complete || s.readers > 0 {
+		s.cond.Wait()
+	}
+	f := s.file
+	s.mu.Unlock()
+	if f != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+}
+
+// serveTo streams the spooled response to a follower, blocking when caught up
+// to the leader. A single byte range is served as soon as its bytes arrive,
+// unless it is conditional on If-Range. It returns errSpoolUnavailable,
+// having written nothing, if the response was not spooled or varies on
+// request headers r does not match.
+func (s *spool) serveTo(w http.ResponseWriter, r *http.Request) error {
+	defer func() {
+		s.mu.Lock()
+		s.readers--
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}()
+
+	s.mu.Lock()
+	for !s.started && !s.complete {
+		s.cond.Wait()
+	}
+	if !s.started {
+		s.mu.Unlock()
+		return errSpoolUnavailable
+	}
+	for name, value := range s.vary {
+		if r.Header.Get(name) != value {
+			s.mu.Unlock()
+			return errSpoolUnavailable
+		}
+	}
+	headers := s.headers
+	s.mu.Unlock()
+
+	maps.Copy(w.Header(), headers)
+	offset, end := int64(0), int64(-1)
+	if spec := r.Header.Get("Range"); spec != "" && r.Header.Get("If-Range") == "" {
+		size, err := s.size()
+		if err != nil {
+			return err
+		}
+		start, length, outcome := cache.RequestOptions{Range: spec}.ResolveRange(size, "")
+		switch outcome {
+		case cache.RangeNotSatisfiable:
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		case cache.RangePartial:
+			offset, end = start, start+length
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+			w.WriteHeader(http.StatusPartialContent)
+		case cache.RangeFull:
+		}
+	}
+	if end < 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	buf := make([]byte, 32*1024)
+	for end < 0 || offset < end {
+		s.mu.Lock()
+		for offset >= s.written && !s.complete {
+			s.cond.Wait()
+		}
+		written, complete, spoolErr := s.written, s.complete, s.err
+		s.mu.Unlock()
+		if end >= 0 {
+			written = min(written, end)
+		}
+
+		for offset < written {
+			n, err := s.file.ReadAt(buf[:min(written-offset, int64(len(buf)))], offset)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return errors.Wrap(err, "write to client from spool")
+				}
+				offset += int64(n)
+				if flusher, ok := w.(http.Flusher); ok {
+					flusher.Flush()
+				}
+			}
+			if err != nil && !errors.Is(err, io.EOF) {
+				return errors.Wrap(err, "read spool")
+			}
+		}
+
+		if complete && offset >= written {
+			return spoolErr
+		}
+	}
+	return nil
+}
+
+// size returns the size of the spooled response, from its Content-Length or
+// else by waiting for it to complete.
+func (s *spool) size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size, err := strconv.ParseInt(s.headers.Get("Content-Length"), 10, 64); err == nil && size >= 0 {
+		return size, nil
+	}
+	for !s.complete {
+		s.cond.Wait()
+	}
+	return s.written, s.err
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+)
+
+// Warning headers marking stale responses (RFC 7234 section 5.5).
+const (
+	staleWarning              = `110 - "Response is Stale"`
+	revalidationFailedWarning = `111 - "Revalidation Failed"`
+)
+
+// GraceConfig configures how long past their freshness cached responses may
+// still be served. Strategies embed it in their configuration.
+type GraceConfig struct {
+	StaleWhileRevalidate time.Duration `hcl:"stale-while-revalidate,optional" help:"Serve responses up to this long past their freshness immediately, while refreshing them in the background."`
+	StaleIfError         time.Duration `hcl:"stale-if-error,optional" help:"Serve responses up to this long past their freshness when the upstream fails or times out."`
+	RevalidateTimeout    time.Duration `hcl:"revalidate-timeout,optional" help:"How long to wait for upstream response headers when revalidating a response that could be served stale on error." default:"30s"`
+}
+
+// staleEntry is a cached response whose freshness has lapsed.
+type staleEntry struct {
+	key     cache.Key
+	headers http.Header
+	// age is how long ago the response became stale.
+	age time.Duration
+	// whileRevalidate and ifError are the grace periods for the response.
+	whileRevalidate time.Duration
+	ifError         time.Duration
+}
+
+func (h *Handler) newStaleEntry(key cache.Key, headers http.Header, now time.Time) *staleEntry {
+	stale := &staleEntry{key: key, headers: headers}
+	if freshUntil, err := time.Parse(time.RFC3339Nano, headers.Get(freshUntilHeader)); err == nil {
+		stale.age = now.Sub(freshUntil)
+		stale.whileRevalidate, stale.ifError = h.graceWindows(headers)
+	}
+	return stale
+}
+
+// graceWindows returns how long past its freshness a response may be served
+// while it is revalidated, and when revalidation fails. Upstream
+// stale-while-revalidate and stale-if-error directives (RFC 5861) override the
+// handler's configuration, and no-cache, must-revalidate and proxy-revalidate
+// forbid serving stale responses at all.
+func (h *Handler) graceWindows(header http.Header) (whileRevalidate, ifError time.Duration) {
+	directives := parseCacheControl(header)
+	for _, name := range []string{"no-cache", "must-revalidate", "proxy-revalidate"} {
+		if _, ok := directives[name]; ok {
+			return 0, 0
+		}
+	}
+	whileRevalidate, ifError = h.grace.StaleWhileRevalidate, h.grace.StaleIfError
+	if value, ok := directives["stale-while-revalidate"]; ok {
+		whileRevalidate = parseSeconds(value)
+	}
+	if value, ok := directives["stale-if-error"]; ok {
+		ifError = parseSeconds(value)
+	}
+	return whileRevalidate, ifError
+}
+
+// storagePolicy returns the freshness to record for a response and the TTL
+// to store it for. The first caching rule matching the response may forbid
+// storing it, or fix its freshness at the rule's TTL. Otherwise responses
+// without explicit freshness are fresh for the configured TTL, and responses
+// are stored for their grace periods beyond it. Without a configured TTL, the
+// cache's maximum is used and responses without explicit freshness stay fresh
+// until evicted.
+func (h *Handler) storagePolicy(r *http.Request, status int, header http.Header, f freshness) (freshness, time.Duration) {
+	ttl := h.ttlFunc(r)
+	if rule := h.rules.match(r, status, header); rule != nil {
+		if !rule.cache {
+			return freshness{}, 0
+		}
+		if rule.ttl > 0 {
+			ttl = rule.ttl
+			f.explicit = true
+			f.lifetime = rule.ttl
+		}
+	}
+	if !f.store || ttl == 0 {
+		return f, 0
+	}
+	if !f.explicit {
+		f.explicit = true
+		f.lifetime = ttl
+	}
+	whileRevalidate, ifError := h.graceWindows(header)
+	return f, ttl + max(whileRevalidate, ifError)
+}
+
+// fetchForRevalidation fetches like fetch, but if the stale entry could be
+// served on error, gives up waiting for response headers after the configured
+// timeout. The returned cancel function must be called once the response body
+// is no longer needed.
+func (h *Handler) fetchForRevalidation(r *http.Request, stale *staleEntry) (*http.Response, context.CancelFunc, error) {
+	if stale == nil || stale.age >= stale.ifError || h.grace.RevalidateTimeout <= 0 {
+		resp, err := h.fetch(r, stale)
+		return resp, func() {}, err
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	timer := time.AfterFunc(h.grace.RevalidateTimeout, func() {
+		cancel(errors.Errorf("upstream did not respond within %s", h.grace.RevalidateTimeout))
+	})
+	resp, err := h.fetch(r.WithContext(ctx), stale)
+	timer.Stop()
+	if err != nil {
+		err = errors.Join(err, context.Cause(ctx))
+	}
+	return resp, func() { cancel(nil) }, err
+}
+
+// serveStale serves a stale cached response with a Warning header, returning
+// false if it has since been evicted.
+func (h *Handler) serveStale(w http.ResponseWriter, r *http.Request, stale *staleEntry, warning string) bool {
+	cr, headers, err := h.cache.Open(r.Context(), stale.key, httputil.ConditionalOptions(r)...)
+	if headers != nil {
+		headers.Set("Warning", warning)
+	}
+	return h.serveEntry(w, r, cr, headers, err)
+}
+
+// revalidateInBackground revalidates a stale entry that has been served,
+// unless a revalidation of it is already in flight.
+func (h *Handler) revalidateInBackground(r *http.Request, parts CacheKeyParts, stale *staleEntry) {
+	if _, inFlight := h.revalidating.LoadOrStore(stale.key, struct{}{}); inFlight {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	req := r.Clone(ctx)
+	go func() {
+		defer h.revalidating.Delete(stale.key)
+		logger := logging.FromContext(ctx)
+		logger.DebugContext(ctx, "Revalidating stale cache entry in background")
+		resp, err := h.fetch(req, stale)
+		if err != nil {
+			logger.WarnContext(ctx, "Background revalidation failed", "error", err)
+			return
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNotModified:
+			_, err = h.refresh(ctx, req, stale.key, resp)
+		case http.StatusOK:
+			err = h.streamAndCache(&discardResponseWriter{header: http.Header{}}, req, parts, resp, nil)
+		default:
+			err = errors.Errorf("upstream returned %s", resp.Status)
+		}
+		if err != nil {
+			logger.WarnContext(ctx, "Background revalidation failed", "error", err)
+		}
+	}()
+}
+
+// discardResponseWriter is the response writer of a background revalidation,
+// which has no client.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
@@ -6,20 +6,30 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/jobscheduler"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
-func RegisterHermit(r *Registry) {
+func RegisterHermit(r *Registry, transportProvider httpclient.TransportProvider) {
 	Register(r, "hermit", "Caches Hermit package downloads.", func(ctx context.Context, config HermitConfig, c cache.Cache, mux Mux) (*Hermit, error) {
-		return NewHermit(ctx, config, nil, c, mux)
+		s, err := NewHermit(ctx, config, nil, c, mux)
+		if err != nil {
+			return nil, err
+		}
+		if err := setUpstreamTransport(s, transportProvider, config.UpstreamClient); err != nil {
+			return nil, err
+		}
+		return s, nil
 	})
 }
 
@@ -31,8 +41,14 @@ const defaultGitHubBaseURL = "http://127.0.0.1:8080/github.com"
 var hermitBinaryPattern = regexp.MustCompile(`/hermit-[a-z]+-[a-z0-9]+\.gz$`)
 
 type HermitConfig struct {
-	GitHubBaseURL string        `hcl:"github-base-url" help:"Base URL for GitHub release redirects" default:"http://127.0.0.1:8080/github.com"`
-	BinaryTTL     time.Duration `hcl:"binary-ttl,optional" help:"Cache TTL for the mutable Hermit self-update binary" default:"1h"`
+	GitHubBaseURL  string                 `hcl:"github-base-url" help:"Base URL for GitHub release redirects" default:"http://127.0.0.1:8080/github.com"`
+	BinaryTTL      time.Duration          `hcl:"binary-ttl,optional" help:"Cache TTL for the mutable Hermit self-update binary" default:"1h"`
+	Grace          handler.GraceConfig    `hcl:",embed"`
+	Lease          handler.LeaseConfig    `hcl:",embed"`
+	Negative       handler.NegativeConfig `hcl:",embed"`
+	Rules          handler.RulesConfig    `hcl:",embed"`
+	Slices         handler.SliceConfig    `hcl:",embed"`
+	UpstreamClient *httpclient.Config     `hcl:"upstream,block,optional" help:"HTTP client settings for upstream requests, overriding the global upstream block."`
 }
 
 // Hermit caches Hermit package downloads.
@@ -45,10 +61,13 @@ type Hermit struct {
 	logger          *slog.Logger
 	mux             Mux
 	redirectHandler http.Handler
-	directHandler   http.Handler
+	directHandler   *handler.Handler
 }
 
-var _ Strategy = (*Hermit)(nil)
+var (
+	_ Strategy         = (*Hermit)(nil)
+	_ MetadataConsumer = (*Hermit)(nil)
+)
 
 func NewHermit(ctx context.Context, config HermitConfig, _ jobscheduler.Scheduler, c cache.Cache, mux Mux) (*Hermit, error) {
 	logger := logging.FromContext(ctx)
@@ -56,12 +75,16 @@ func NewHermit(ctx context.Context, config HermitConfig, _ jobscheduler.Schedule
 	s := &Hermit{
 		config: config,
 		cache:  c,
-		client: http.DefaultClient,
+		client: &http.Client{},
 		logger: logger,
 		mux:    mux,
 	}
 
-	s.directHandler = s.createDirectHandler(c)
+	directHandler, err := s.createDirectHandler(c)
+	if err != nil {
+		return nil, err
+	}
+	s.directHandler = directHandler
 	mux.Handle("GET /hermit/{host}/{path...}", s.directHandler)
 
 	if config.GitHubBaseURL != "" {
@@ -79,20 +102,42 @@ func NewHermit(ctx context.Context, config HermitConfig, _ jobscheduler.Schedule
 
 func (s *Hermit) String() string { return "hermit" }
 
-func (s *Hermit) createDirectHandler(c cache.Cache) http.Handler {
+// SetHTTPTransport overrides the HTTP transport used for upstream requests.
+func (s *Hermit) SetHTTPTransport(t http.RoundTripper) {
+	s.client.Transport = t
+}
+
+// SetMetadataStore enables cross-replica miss coalescing for direct downloads.
+// GitHub release downloads are coalesced by the github-releases strategy.
+func (s *Hermit) SetMetadataStore(store *metadatadb.Store) {
+	setFillLeases(s.directHandler, store, s.config.Lease)
+}
+
+// createDirectHandler creates the handler for direct downloads. The binary TTL
+// is applied as a rule after those configured, so that they can override it.
+func (s *Hermit) createDirectHandler(c cache.Cache) (*handler.Handler, error) {
+	config := s.config.Rules
+	config.Rules = append(slices.Clip(config.Rules), handler.RuleConfig{
+		Regex:  hermitBinaryPattern.String(),
+		Status: []int{http.StatusOK},
+		TTL:    s.config.BinaryTTL,
+		Cache:  true,
+	})
+	rules, err := handler.NewRules(config)
+	if err != nil {
+		return nil, err
+	}
 	return handler.New(s.client, c).
 		CacheKey(func(r *http.Request) string {
 			return s.buildOriginalURL(r)
 		}).
-		TTL(func(r *http.Request) time.Duration {
-			if hermitBinaryPattern.MatchString(r.URL.Path) {
-				return s.config.BinaryTTL
-			}
-			return 0
-		}).
 		Transform(func(r *http.Request) (*http.Request, error) {
 			return s.buildDirectRequest(r)
-		})
+		}).
+		Grace(s.config.Grace).
+		Negative(s.config.Negative).
+		Rules(rules).
+		Slices(s.config.Slices), nil
 }
 
 func (s *Hermit) createRedirectHandler(isInternalRedirect bool, c cache.Cache) http.Handler {
@@ -8,53 +8,88 @@ import (
 	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httpclient"
+	"github.com/block/cachew/internal/metadatadb"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
-func RegisterHost(r *Registry) {
-	Register(r, "host", "A generic host-based proxying strategy.", NewHost)
+func RegisterHost(r *Registry, transportProvider httpclient.TransportProvider) {
+	Register(r, "host", "A generic host-based proxying strategy.", func(ctx context.Context, config HostConfig, c cache.Cache, mux Mux) (*Host, error) {
+		h, err := NewHost(ctx, config, c, mux)
+		if err != nil {
+			return nil, err
+		}
+		if err := setUpstreamTransport(h, transportProvider, config.UpstreamClient); err != nil {
+			return nil, err
+		}
+		return h, nil
+	})
 }
 
 // HostConfig represents the configuration for the Host strategy.
 //
 // In HCL it looks something like this:
 //
-//	host {
-//		target = "https://github.com/"
+//	host "https://github.com/" {
+//		mirrors = ["https://github-mirror.example.com/"]
 //	}
 //
-// In this example, the strategy will be mounted under "/github.com".
+// In this example, the strategy will be mounted under "/github.com". Requests
+// fail over to the mirrors, in order, when the target is unhealthy.
 type HostConfig struct {
-	Target  string            `hcl:"target,label" help:"The target URL to proxy requests to."`
-	Headers map[string]string `hcl:"headers,optional" help:"Headers to add to upstream requests."`
+	Target         string                 `hcl:"target,label" help:"The target URL to proxy requests to."`
+	Headers        map[string]string      `hcl:"headers,optional" help:"Headers to add to upstream requests."`
+	Grace          handler.GraceConfig    `hcl:",embed"`
+	Lease          handler.LeaseConfig    `hcl:",embed"`
+	Negative       handler.NegativeConfig `hcl:",embed"`
+	Rules          handler.RulesConfig    `hcl:",embed"`
+	Slices         handler.SliceConfig    `hcl:",embed"`
+	Upstreams      UpstreamsConfig        `hcl:",embed"`
+	UpstreamClient *httpclient.Config     `hcl:"upstream,block,optional" help:"HTTP client settings for upstream requests, overriding the global upstream block."`
 }
 
 // The Host [Strategy] forwards all GET requests to the specified host, caching the response payloads.
 type Host struct {
-	target  *url.URL
-	cache   cache.Cache
-	client  *http.Client
-	prefix  string
-	headers map[string]string
+	target    *url.URL
+	cache     cache.Cache
+	client    *http.Client
+	prefix    string
+	headers   map[string]string
+	handler   *handler.Handler
+	lease     handler.LeaseConfig
+	upstreams *upstreamPool
 }
 
-var _ Strategy = (*Host)(nil)
+var (
+	_ Strategy         = (*Host)(nil)
+	_ MetadataConsumer = (*Host)(nil)
+)
 
-func NewHost(_ context.Context, config HostConfig, cache cache.Cache, mux Mux) (*Host, error) {
+func NewHost(ctx context.Context, config HostConfig, cache cache.Cache, mux Mux) (*Host, error) {
 	u, err := url.Parse(config.Target)
 	if err != nil {
 		return nil, errors.Errorf("invalid target URL: %w", err)
 	}
+	upstreams, err := newUpstreamPool(ctx, u, config.Upstreams)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := handler.NewRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
 	prefix := "/" + u.Host + u.EscapedPath()
 	h := &Host{
-		target:  u,
-		cache:   cache,
-		client:  &http.Client{},
-		prefix:  prefix,
-		headers: config.Headers,
+		target:    u,
+		cache:     cache,
+		client:    &http.Client{Transport: upstreams},
+		prefix:    prefix,
+		headers:   config.Headers,
+		lease:     config.Lease,
+		upstreams: upstreams,
 	}
 
-	hdlr := handler.New(h.client, cache).
+	h.handler = handler.New(h.client, cache).
 		CacheKey(func(r *http.Request) string {
 			return h.buildTargetURL(r).String()
 		}).
@@ -68,14 +103,27 @@ func NewHost(_ context.Context, config HostConfig, cache cache.Cache, mux Mux) (
 				req.Header.Set(k, v)
 			}
 			return req, nil
-		})
+		}).
+		Grace(config.Grace).
+		Negative(config.Negative).
+		Rules(rules).
+		Slices(config.Slices)
 
-	mux.Handle("GET "+prefix+"/", hdlr)
+	mux.Handle("GET "+prefix+"/", h.handler)
 	return h, nil
 }
 
 func (d *Host) String() string { return "host:" + d.target.Host + d.target.Path }
 
+// SetHTTPTransport overrides the HTTP transport used for upstream requests.
+func (d *Host) SetHTTPTransport(t http.RoundTripper) {
+	d.upstreams.setTransport(t)
+}
+
+func (d *Host) SetMetadataStore(store *metadatadb.Store) {
+	setFillLeases(d.handler, store, d.lease)
+}
+
 // buildTargetURL constructs the target URL from the incoming request.
 func (d *Host) buildTargetURL(r *http.Request) *url.URL {
 	// Strip the prefix from the request path
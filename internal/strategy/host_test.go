@@ -6,12 +6,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/hcl/v2"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy"
 )
@@ -143,3 +148,96 @@ func TestHostString(t *testing.T) {
 
 	assert.Equal(t, "host:example.com/prefix", host.String())
 }
+
+func TestHostUpstreamBlock(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.RequestURI)
+		_, _ = w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	// The strategy's upstream block overrides the global transport.
+	global := httpclient.NewTransportProvider(httpclient.Config{})
+	r := strategy.NewRegistry()
+	strategy.RegisterHost(r, global)
+	ast, err := hcl.Parse(strings.NewReader(`host "http://upstream.example.com" {
+		upstream {
+			proxy = "` + proxy.URL + `"
+		}
+	}`))
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	_, err = r.Create(ctx, "host", ast.Entries[0].(*hcl.Block), memCache, mux, nil) //nolint:forcetypeassert
+	assert.NoError(t, err)
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/upstream.example.com/file", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "via proxy", w.Body.String())
+	assert.Equal(t, "http://upstream.example.com/file", proxied.Load())
+}
+
+func TestHostRuleBlocks(t *testing.T) {
+	calls := map[string]int{}
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		mu.Unlock()
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	r := strategy.NewRegistry()
+	strategy.RegisterHost(r, nil)
+	ast, err := hcl.Parse(strings.NewReader(`host "` + upstream.URL + `" {
+		rule {
+			match = "*.sha256"
+			ttl = "1h"
+		}
+		rule {
+			match = "*/index.json"
+			cache = false
+		}
+	}`))
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	_, err = r.Create(ctx, "host", ast.Entries[0].(*hcl.Block), memCache, mux, nil) //nolint:forcetypeassert
+	assert.NoError(t, err)
+
+	u, err := url.Parse(upstream.URL)
+	assert.NoError(t, err)
+	for range 2 {
+		for _, path := range []string{"/pkg/app.sha256", "/pkg/index.json"} {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+u.Host+path, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, path, w.Body.String())
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"/pkg/app.sha256": 1, "/pkg/index.json": 2}, calls)
+
+	ast, err = hcl.Parse(strings.NewReader(`host "` + upstream.URL + `" {
+		rule {
+			regex = "("
+		}
+	}`))
+	assert.NoError(t, err)
+	_, err = r.Create(ctx, "host", ast.Entries[0].(*hcl.Block), memCache, http.NewServeMux(), nil) //nolint:forcetypeassert
+	assert.Error(t, err)
+}
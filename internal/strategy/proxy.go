@@ -8,7 +8,9 @@ import (
 	"strings"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httpclient"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metadatadb"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
@@ -20,15 +22,28 @@ import (
 //
 // The request URI is upgraded to HTTPS and the response is fetched and cached.
 // Only GET requests are intercepted; other methods are passed through.
-func RegisterHTTPProxy(r *Registry) {
-	Register(r, "proxy", "Caching HTTP proxy for absolute-form proxy requests.", func(ctx context.Context, _ ProxyConfig, c cache.Cache, mux Mux) (*HTTPProxy, error) {
-		return NewHTTPProxy(ctx, c, mux)
+func RegisterHTTPProxy(r *Registry, transportProvider httpclient.TransportProvider) {
+	Register(r, "proxy", "Caching HTTP proxy for absolute-form proxy requests.", func(ctx context.Context, config ProxyConfig, c cache.Cache, mux Mux) (*HTTPProxy, error) {
+		p, err := NewHTTPProxy(ctx, config, c, mux)
+		if err != nil {
+			return nil, err
+		}
+		if err := setUpstreamTransport(p, transportProvider, config.UpstreamClient); err != nil {
+			return nil, err
+		}
+		return p, nil
 	})
 }
 
 // ProxyConfig holds configuration for the HTTP proxy strategy.
-// Currently no options are required.
-type ProxyConfig struct{}
+type ProxyConfig struct {
+	Grace          handler.GraceConfig    `hcl:",embed"`
+	Lease          handler.LeaseConfig    `hcl:",embed"`
+	Negative       handler.NegativeConfig `hcl:",embed"`
+	Rules          handler.RulesConfig    `hcl:",embed"`
+	Slices         handler.SliceConfig    `hcl:",embed"`
+	UpstreamClient *httpclient.Config     `hcl:"upstream,block,optional" help:"HTTP client settings for upstream requests, overriding the global upstream block."`
+}
 
 // HTTPProxy is a caching HTTP proxy strategy that handles standard HTTP proxy
 // requests in absolute form (GET http://host/path HTTP/1.1).
@@ -39,20 +54,26 @@ type ProxyConfig struct{}
 // /api/v1/ or /admin/ when the proxied upstream path happens to match them.
 type HTTPProxy struct {
 	logger  *slog.Logger
-	handler http.Handler
+	client  *http.Client
+	handler *handler.Handler
+	lease   handler.LeaseConfig
 }
 
 var (
-	_ Strategy    = (*HTTPProxy)(nil)
-	_ Interceptor = (*HTTPProxy)(nil)
+	_ Strategy         = (*HTTPProxy)(nil)
+	_ Interceptor      = (*HTTPProxy)(nil)
+	_ MetadataConsumer = (*HTTPProxy)(nil)
 )
 
-func NewHTTPProxy(ctx context.Context, c cache.Cache, _ Mux) (*HTTPProxy, error) {
+func NewHTTPProxy(ctx context.Context, config ProxyConfig, c cache.Cache, _ Mux) (*HTTPProxy, error) {
 	logger := logging.FromContext(ctx)
-	client := &http.Client{}
-	p := &HTTPProxy{logger: logger}
+	rules, err := handler.NewRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	p := &HTTPProxy{logger: logger, client: &http.Client{}, lease: config.Lease}
 
-	p.handler = handler.New(client, c).
+	p.handler = handler.New(p.client, c).
 		CacheKey(func(r *http.Request) string {
 			target := p.parseProxyURI(r)
 			if target == nil {
@@ -77,7 +98,11 @@ func NewHTTPProxy(ctx context.Context, c cache.Cache, _ Mux) (*HTTPProxy, error)
 				slog.String("url", target.String()),
 				slog.String("error", err.Error()))
 			http.Error(w, "proxy error: "+err.Error(), http.StatusBadGateway)
-		})
+		}).
+		Grace(config.Grace).
+		Negative(config.Negative).
+		Rules(rules).
+		Slices(config.Slices)
 
 	logger.InfoContext(ctx, "HTTP proxy strategy initialized")
 	return p, nil
@@ -85,6 +110,15 @@ func NewHTTPProxy(ctx context.Context, c cache.Cache, _ Mux) (*HTTPProxy, error)
 
 func (p *HTTPProxy) String() string { return "proxy" }
 
+// SetHTTPTransport overrides the HTTP transport used for upstream requests.
+func (p *HTTPProxy) SetHTTPTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+func (p *HTTPProxy) SetMetadataStore(store *metadatadb.Store) {
+	setFillLeases(p.handler, store, p.lease)
+}
+
 // Intercept returns an http.Handler that intercepts absolute-form GET proxy
 // requests before they reach the ServeMux, delegating all other requests to
 // next. This ensures that a proxied path like /api/v1/... is not accidentally
@@ -0,0 +1,315 @@
+package strategy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/block/cachew/internal/httpclient"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metrics"
+)
+
+// setUpstreamTransport sets the transport a newly created strategy sends
+// upstream requests with: built from its own upstream block if it has one, or
+// else the global one.
+func setUpstreamTransport(s interface{ SetHTTPTransport(http.RoundTripper) }, global httpclient.TransportProvider, override *httpclient.Config) error {
+	transport, err := httpclient.Resolve(global, override)
+	if err != nil {
+		return errors.Wrap(err, "upstream transport")
+	}
+	s.SetHTTPTransport(transport)
+	return nil
+}
+
+// UpstreamsConfig configures failover from a strategy's target to mirrors
+// serving the same content.
+type UpstreamsConfig struct {
+	Mirrors          []string      `hcl:"mirrors,optional" help:"Upstream URLs serving the same content as the target, tried in order when it is unhealthy."`
+	HealthPath       string        `hcl:"health-path,optional" help:"Path probed on each upstream to check its health when mirrors are configured." default:"/"`
+	HealthInterval   time.Duration `hcl:"health-interval,optional" help:"How often to probe upstreams when mirrors are configured. 0 disables active health checks." default:"30s"`
+	FailureThreshold int           `hcl:"failure-threshold,optional" help:"Consecutive failures after which an upstream is skipped." default:"3"`
+	FailureCooldown  time.Duration `hcl:"failure-cooldown,optional" help:"How long an upstream is skipped after failing, before it is tried again." default:"30s"`
+}
+
+// upstreamPool is an [http.RoundTripper] that sends requests for a
+// strategy's target to the first healthy upstream, in priority order: the
+// target, then its mirrors. Requests are built against the target, so cache
+// keys do not depend on which upstream serves them.
+//
+// An upstream is skipped once it fails failure-threshold requests in a row,
+// or fails a health check, until failure-cooldown has passed or it passes a
+// health check. If every upstream is being skipped, each is tried anyway.
+type upstreamPool struct {
+	target      *url.URL
+	upstreams   []*upstream
+	transportMu sync.Mutex
+	transport   http.RoundTripper
+	config      UpstreamsConfig
+	metrics     *upstreamMetrics
+	now         func() time.Time
+}
+
+var _ http.RoundTripper = (*upstreamPool)(nil)
+
+// upstream tracks the health of one upstream of a pool.
+type upstream struct {
+	url      *url.URL
+	name     string
+	mu       sync.Mutex
+	failures int
+	downTill time.Time
+}
+
+// newUpstreamPool creates a pool for target and config's mirrors. Active
+// health checks run until ctx is cancelled.
+func newUpstreamPool(ctx context.Context, target *url.URL, config UpstreamsConfig) (*upstreamPool, error) {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 3
+	}
+	if config.FailureCooldown <= 0 {
+		config.FailureCooldown = 30 * time.Second
+	}
+	p := &upstreamPool{
+		target:    target,
+		upstreams: []*upstream{newUpstream(target)},
+		transport: http.DefaultTransport,
+		config:    config,
+		metrics:   newUpstreamMetrics(),
+		now:       time.Now,
+	}
+	for _, mirror := range config.Mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil {
+			return nil, errors.Errorf("invalid mirror URL %q: %w", mirror, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, errors.Errorf("invalid mirror URL %q: scheme and host are required", mirror)
+		}
+		p.upstreams = append(p.upstreams, newUpstream(u))
+	}
+	for _, u := range p.upstreams {
+		p.metrics.healthy.Record(ctx, 1, metric.WithAttributes(attribute.String("upstream", u.name)))
+	}
+	if len(p.upstreams) > 1 && config.HealthInterval > 0 {
+		go p.checkHealth(ctx)
+	}
+	return p, nil
+}
+
+func newUpstream(u *url.URL) *upstream {
+	return &upstream{url: u, name: u.Host + strings.TrimRight(u.Path, "/")}
+}
+
+// RoundTrip sends r to the first upstream that answers it without a
+// transport error or a 5xx status. The last upstream's response is returned
+// as is, so a 5xx from every upstream reaches the caller. Requests that are
+// not for the pool's target, such as followed redirects, are sent unchanged.
+func (p *upstreamPool) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !p.isTargetURL(r.URL) {
+		return p.getTransport().RoundTrip(r) //nolint:wrapcheck
+	}
+	candidates := p.candidates()
+	var err error
+	for i, u := range candidates {
+		last := i == len(candidates)-1
+		var resp *http.Response
+		resp, err = p.send(r, u)
+		if err == nil && (resp.StatusCode < 500 || last) {
+			return resp, nil
+		}
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck // drain for keep-alive
+			_ = resp.Body.Close()
+			err = errors.Errorf("%s returned %s", u.name, resp.Status)
+		}
+		if r.Context().Err() != nil {
+			return nil, err
+		}
+		if !last {
+			logging.FromContext(r.Context()).WarnContext(r.Context(), "Upstream failed, trying next", "upstream", u.name, "error", err)
+		}
+	}
+	return nil, errors.WithStack(err)
+}
+
+// send sends r to u, rewriting its URL from the target's to u's, and records
+// the outcome against u.
+func (p *upstreamPool) send(r *http.Request, u *upstream) (*http.Response, error) {
+	ctx := r.Context()
+	req := r.Clone(ctx)
+	req.URL = p.rewrite(r.URL, u.url)
+	req.Host = ""
+	start := p.now()
+	resp, err := p.getTransport().RoundTrip(req)
+	result := "ok"
+	switch {
+	case err != nil && ctx.Err() != nil:
+		result = "cancelled"
+	case err != nil:
+		result = "error"
+		p.markFailed(ctx, u)
+	case resp.StatusCode >= 500:
+		result = "server_error"
+		p.markFailed(ctx, u)
+	default:
+		p.markHealthy(ctx, u)
+	}
+	attrs := metric.WithAttributes(attribute.String("upstream", u.name), attribute.String("result", result))
+	p.metrics.requestTotal.Add(ctx, 1, attrs)
+	p.metrics.requestDuration.Record(ctx, p.now().Sub(start).Seconds(), attrs)
+	return resp, errors.WithStack(err)
+}
+
+// setTransport sets the transport requests are sent to upstreams with.
+func (p *upstreamPool) setTransport(t http.RoundTripper) {
+	p.transportMu.Lock()
+	defer p.transportMu.Unlock()
+	p.transport = t
+}
+
+func (p *upstreamPool) getTransport() http.RoundTripper {
+	p.transportMu.Lock()
+	defer p.transportMu.Unlock()
+	return p.transport
+}
+
+// candidates returns the upstreams to try in order: those not being skipped,
+// or every upstream if all of them are.
+func (p *upstreamPool) candidates() []*upstream {
+	now := p.now()
+	candidates := make([]*upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		u.mu.Lock()
+		down := now.Before(u.downTill)
+		u.mu.Unlock()
+		if !down {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return p.upstreams
+	}
+	return candidates
+}
+
+// isTargetURL reports whether u is on the pool's target host.
+func (p *upstreamPool) isTargetURL(u *url.URL) bool {
+	return u.Scheme == p.target.Scheme && u.Host == p.target.Host
+}
+
+// rewrite moves u from the pool's target URL to base.
+func (p *upstreamPool) rewrite(u, base *url.URL) *url.URL {
+	out := *u
+	out.Scheme = base.Scheme
+	out.Host = base.Host
+	out.User = base.User
+	out.Path = strings.TrimRight(base.Path, "/") + strings.TrimPrefix(u.Path, strings.TrimRight(p.target.Path, "/"))
+	out.RawPath = ""
+	return &out
+}
+
+func (p *upstreamPool) markFailed(ctx context.Context, u *upstream) {
+	u.mu.Lock()
+	u.failures++
+	tripped := u.failures >= p.config.FailureThreshold
+	if tripped {
+		u.downTill = p.now().Add(p.config.FailureCooldown)
+	}
+	u.mu.Unlock()
+	if tripped {
+		p.metrics.healthy.Record(ctx, 0, metric.WithAttributes(attribute.String("upstream", u.name)))
+	}
+}
+
+func (p *upstreamPool) markHealthy(ctx context.Context, u *upstream) {
+	u.mu.Lock()
+	u.failures = 0
+	u.downTill = time.Time{}
+	u.mu.Unlock()
+	p.metrics.healthy.Record(ctx, 1, metric.WithAttributes(attribute.String("upstream", u.name)))
+}
+
+// markDown skips u for the failure cooldown, after a failed health check.
+func (p *upstreamPool) markDown(ctx context.Context, u *upstream) {
+	u.mu.Lock()
+	u.failures = max(u.failures, p.config.FailureThreshold)
+	u.downTill = p.now().Add(p.config.FailureCooldown)
+	u.mu.Unlock()
+	p.metrics.healthy.Record(ctx, 0, metric.WithAttributes(attribute.String("upstream", u.name)))
+}
+
+// checkHealth probes every upstream each health interval until ctx is
+// cancelled.
+func (p *upstreamPool) checkHealth(ctx context.Context) {
+	ticker := time.NewTicker(p.config.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probe(ctx)
+		}
+	}
+}
+
+// probe checks each upstream's health path, which is healthy if it answers
+// without a 5xx status.
+func (p *upstreamPool) probe(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	for _, u := range p.upstreams {
+		ctx, cancel := context.WithTimeout(ctx, p.config.HealthInterval)
+		err := p.checkUpstream(ctx, u)
+		cancel()
+		if err != nil {
+			logger.WarnContext(ctx, "Upstream unhealthy", "upstream", u.name, "error", err)
+			p.markDown(ctx, u)
+			continue
+		}
+		p.markHealthy(ctx, u)
+	}
+}
+
+func (p *upstreamPool) checkUpstream(ctx context.Context, u *upstream) error {
+	probeURL := *u.url
+	probeURL.Path = strings.TrimRight(u.url.Path, "/") + "/" + strings.TrimLeft(p.config.HealthPath, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := p.getTransport().RoundTrip(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck // drain for keep-alive
+	if resp.StatusCode >= 500 {
+		return errors.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+type upstreamMetrics struct {
+	requestTotal    metric.Int64Counter
+	requestDuration metric.Float64Histogram
+	healthy         metric.Int64Gauge
+}
+
+func newUpstreamMetrics() *upstreamMetrics {
+	meter := otel.Meter("cachew.upstream")
+	return &upstreamMetrics{
+		requestTotal:    metrics.NewMetric[metric.Int64Counter](meter, "cachew.upstream.requests_total", "{requests}", "Upstream requests by upstream and result (ok, server_error, error, cancelled)"),
+		requestDuration: metrics.NewHistogram(meter, "cachew.upstream.request_duration_seconds", "s", "Time to upstream response headers, by upstream and result", metrics.LatencyBuckets()),
+		healthy:         metrics.NewMetric[metric.Int64Gauge](meter, "cachew.upstream.healthy", "{upstreams}", "Whether an upstream is being tried (1) or skipped after failing (0)"),
+	}
+}
@@ -0,0 +1,181 @@
+package strategy_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+)
+
+type fakeUpstream struct {
+	server  *httptest.Server
+	calls   atomic.Int32
+	healthy atomic.Bool
+	body    string
+}
+
+func newFakeUpstream(t *testing.T, body string, healthy bool) *fakeUpstream {
+	t.Helper()
+	f := &fakeUpstream{body: body}
+	f.healthy.Store(healthy)
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			f.calls.Add(1)
+		}
+		if !f.healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(f.body + r.URL.Path))
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func newUpstreamsTestHost(t *testing.T, target string, config strategy.UpstreamsConfig) (*http.ServeMux, cache.Cache, context.Context) {
+	t.Helper()
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = memCache.Close() })
+	mux := http.NewServeMux()
+	_, err = strategy.NewHost(ctx, strategy.HostConfig{Target: target, Upstreams: config}, memCache, mux)
+	assert.NoError(t, err)
+	return mux, memCache, ctx
+}
+
+func getVia(ctx context.Context, mux http.Handler, target, path string) *httptest.ResponseRecorder {
+	u, _ := url.Parse(target) //nolint:errcheck
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+u.Host+path, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpstreamsFailover(t *testing.T) {
+	primary := newFakeUpstream(t, "primary", false)
+	mirror := newFakeUpstream(t, "mirror", true)
+	mux, memCache, ctx := newUpstreamsTestHost(t, primary.server.URL, strategy.UpstreamsConfig{
+		Mirrors: []string{mirror.server.URL},
+	})
+
+	w := getVia(ctx, mux, primary.server.URL, "/a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mirror/a", w.Body.String())
+	assert.Equal(t, int32(1), primary.calls.Load())
+	assert.Equal(t, int32(1), mirror.calls.Load())
+
+	// The response is cached under the target's URL, not the mirror's.
+	cr, _, err := memCache.Open(ctx, cache.NewKey(primary.server.URL+"/a"))
+	assert.NoError(t, err)
+	body, err := io.ReadAll(cr)
+	assert.NoError(t, err)
+	_ = cr.Close()
+	assert.Equal(t, "mirror/a", string(body))
+}
+
+func TestUpstreamsAllFailing(t *testing.T) {
+	primary := newFakeUpstream(t, "primary", false)
+	mirror := newFakeUpstream(t, "mirror", false)
+	mux, _, ctx := newUpstreamsTestHost(t, primary.server.URL, strategy.UpstreamsConfig{
+		Mirrors: []string{mirror.server.URL},
+	})
+
+	// The last upstream's error response is passed through.
+	w := getVia(ctx, mux, primary.server.URL, "/a")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, int32(1), primary.calls.Load())
+	assert.Equal(t, int32(1), mirror.calls.Load())
+}
+
+func TestUpstreamsCircuitBreaker(t *testing.T) {
+	primary := newFakeUpstream(t, "primary", false)
+	mirror := newFakeUpstream(t, "mirror", true)
+	mux, _, ctx := newUpstreamsTestHost(t, primary.server.URL, strategy.UpstreamsConfig{
+		Mirrors:          []string{mirror.server.URL},
+		FailureThreshold: 2,
+		FailureCooldown:  time.Hour,
+	})
+
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		w := getVia(ctx, mux, primary.server.URL, path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "mirror"+path, w.Body.String())
+	}
+	// After two consecutive failures the target is skipped.
+	assert.Equal(t, int32(2), primary.calls.Load())
+	assert.Equal(t, int32(4), mirror.calls.Load())
+}
+
+func TestUpstreamsHealthCheckRecovery(t *testing.T) {
+	primary := newFakeUpstream(t, "primary", false)
+	mirror := newFakeUpstream(t, "mirror", true)
+	mux, _, ctx := newUpstreamsTestHost(t, primary.server.URL, strategy.UpstreamsConfig{
+		Mirrors:          []string{mirror.server.URL},
+		HealthPath:       "/healthz",
+		HealthInterval:   20 * time.Millisecond,
+		FailureThreshold: 5,
+		FailureCooldown:  time.Hour,
+	})
+
+	// A failed health check skips the target without waiting for requests
+	// to fail.
+	time.Sleep(100 * time.Millisecond)
+	w := getVia(ctx, mux, primary.server.URL, "/a")
+	assert.Equal(t, "mirror/a", w.Body.String())
+	assert.Equal(t, int32(0), primary.calls.Load())
+
+	// Once it passes a health check, the target is preferred again.
+	primary.healthy.Store(true)
+	time.Sleep(100 * time.Millisecond)
+	w = getVia(ctx, mux, primary.server.URL, "/b")
+	assert.Equal(t, "primary/b", w.Body.String())
+}
+
+func TestUpstreamsInvalidMirror(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	_, err = strategy.NewArtifactory(ctx, strategy.ArtifactoryConfig{
+		Target:    "https://example.jfrog.io",
+		Upstreams: strategy.UpstreamsConfig{Mirrors: []string{"mirror.example.com"}},
+	}, memCache, http.NewServeMux())
+	assert.Error(t, err)
+}
+
+func TestArtifactoryFailover(t *testing.T) {
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primaryURL := primary.URL
+	primary.Close() // connection refused
+	mirror := newFakeUpstream(t, "mirror", true)
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	mux := http.NewServeMux()
+	_, err = strategy.NewArtifactory(ctx, strategy.ArtifactoryConfig{
+		Target:    primaryURL,
+		Upstreams: strategy.UpstreamsConfig{Mirrors: []string{mirror.server.URL}},
+	}, memCache, mux)
+	assert.NoError(t, err)
+
+	w := getVia(ctx, mux, primaryURL, "/libs-release/app.jar")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mirror/libs-release/app.jar", w.Body.String())
+}